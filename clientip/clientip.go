@@ -0,0 +1,122 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// contextKey is the type used for values stored in a request context by this package.
+type contextKey int
+
+const (
+	// clientIPKey is the context key of the client ip address of the request.
+	clientIPKey contextKey = iota
+)
+
+// forwardedForHeader is the header in which proxies append the address of the client
+// they received the request from.
+const forwardedForHeader = "X-Forwarded-For"
+
+// Resolver determines the ip address of the client that sent a request. The
+// X-Forwarded-For header is only read for requests received from a trusted proxy, since
+// any client can send it.
+type Resolver struct {
+	trustedProxies []*net.IPNet // trustedProxies are the networks of the proxies whose X-Forwarded-For header is trusted.
+}
+
+// NewResolver initializes and returns a new resolver trusting the proxies with the
+// provided ip addresses or CIDR networks. Without trusted proxies, the client ip address
+// is the remote address of the request.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			r.trustedProxies = append(r.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		r.trustedProxies = append(r.trustedProxies, network)
+	}
+	return r, nil
+}
+
+// Resolve returns the ip address of the client that sent the request. If the request
+// was received from a trusted proxy, the X-Forwarded-For header is read from right to
+// left, skipping the addresses of trusted proxies, and the first other address is the
+// client. A malformed address stops the search at the last proxy, which is then
+// treated as the client.
+func (r *Resolver) Resolve(req *http.Request) string {
+	ip := remoteIP(req)
+	if !r.isTrusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values(forwardedForHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Middleware resolves the client ip address of every request and adds it to the
+// request context, where it can be retrieved with FromRequest.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), clientIPKey, r.Resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// isTrusted reports whether the ip address belongs to a trusted proxy.
+func (r *Resolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range r.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the client ip address of the request stored in the context by
+// Middleware, or the remote address of the request if it was not handled by Middleware.
+func FromRequest(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+// remoteIP returns the ip address of the remote address of the request.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,76 @@
+package clientip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"untitled_rpg/clientip"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct client", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted single address", "192.168.1.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"trusted proxy without header", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"spoofed first hop", "10.1.2.3:1234", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.1, 10.4.5.6"}, "198.51.100.1"},
+		{"multiple headers", "10.1.2.3:1234", []string{"1.1.1.1", "198.51.100.1, 10.4.5.6"}, "198.51.100.1"},
+		{"only trusted hops", "10.1.2.3:1234", []string{"10.4.5.6"}, "10.4.5.6"},
+		{"malformed hop", "10.1.2.3:1234", []string{"198.51.100.1, garbage"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := resolver.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolverInvalid(t *testing.T) {
+	for _, proxy := range []string{"", "proxy.example.com", "10.0.0.0/33", "10.0.0.300"} {
+		if _, err := clientip.NewResolver([]string{proxy}); err == nil {
+			t.Errorf("NewResolver(%q) error = nil, want an error", proxy)
+		}
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := clientip.FromRequest(r); got != "10.1.2.3" {
+		t.Errorf("FromRequest() without Middleware = %q, want the remote address", got)
+	}
+
+	var got string
+	resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientip.FromRequest(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Errorf("FromRequest() with Middleware = %q, want the forwarded address", got)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/migrate"
+	"untitled_rpg/seed"
+	"untitled_rpg/store"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("Invalid arguments")
+
+// command is a subcommand of the binary, run instead of the server.
+type command struct {
+	usage       string                                       // usage lists the arguments of the command.
+	description string                                       // description describes what the command does.
+	run         func(configPath string, args []string) error // run runs the command with the arguments following its name.
+}
+
+// commands are the subcommands of the binary, keyed by name.
+var commands = map[string]command{
+	"migrate": {
+		usage:       "status | up [N] | down [N] | goto V | force V | new [-dir DIR] NAME | verify [flags]",
+		description: "inspect, apply or revert database migrations",
+		run:         migrateCommand,
+	},
+	"admin": {
+		usage:       "bootstrap EMAIL | grant EMAIL ROLE",
+		description: "create the first admin account, reading its password from stdin, or change the role of an account",
+		run:         adminCommand,
+	},
+	"seed": {
+		usage:       "[-file FILE[,FILE]] [PROFILE...]",
+		description: "create the accounts of fixture profiles, minimal by default, and fixture files",
+		run:         seedCommand,
+	},
+}
+
+// runCommand runs the subcommand named by the first argument and returns the exit code.
+func runCommand(configPath string, args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		flag.Usage()
+		return 2
+	}
+
+	if err := cmd.run(configPath, args[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: %s %s %s\n", os.Args[0], args[0], cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage writes the usage of the binary, its flags and its commands to w.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [flags] [command [args]]\n\nRuns the server if no command is provided.\n\nflags:\n", os.Args[0])
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", name, commands[name].usage, commands[name].description)
+	}
+}
+
+// migrateCommand runs the migrate subcommand. Every subcommand but new holds the
+// migration lock while it runs.
+func migrateCommand(configPath string, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "new":
+		return newMigrationCommand(args[1:])
+	case "verify":
+		return verifyMigrationsCommand(configPath, args[1:])
+	}
+
+	// Parse the arguments before connecting, so that typos fail fast.
+	var n int
+	var version int64
+	var err error
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return errUsage
+		}
+	case "up", "down":
+		n = 1
+		if args[0] == "up" {
+			n = 0
+		}
+		if len(args) > 2 {
+			return errUsage
+		}
+		if len(args) == 2 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("Invalid number of migrations %q", args[1])
+			}
+		}
+	case "goto", "force":
+		if len(args) != 2 {
+			return errUsage
+		}
+		min := int64(0)
+		if args[0] == "force" {
+			min = -1
+		}
+		if version, err = strconv.ParseInt(args[1], 10, 64); err != nil || version < min {
+			return fmt.Errorf("Invalid migration version %q", args[1])
+		}
+	default:
+		return errUsage
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	logger := newLogger(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Migrate.LockTimeout)
+	defer cancel()
+	m, err := migrate.New(ctx, logger, config.Database)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			logger.Error().Err(err).Send()
+		}
+	}()
+
+	switch args[0] {
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(os.Stdout, status)
+		return nil
+	case "up":
+		return m.Up(n)
+	case "down":
+		return m.Down(n)
+	case "goto":
+		return m.Goto(uint(version))
+	default:
+		return m.Force(int(version))
+	}
+}
+
+// newMigrationCommand runs the migrate new subcommand, which creates empty migration files.
+func newMigrationCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate new", flag.ContinueOnError)
+	dir := flags.String("dir", "migrate/migrations", "directory the migration files are written to")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	up, down, err := migrate.Create(*dir, flags.Arg(0), time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s\nCreated %s\nRebuild the binary to embed the new migration.\n", up, down)
+	return nil
+}
+
+// verifyMigrationsCommand runs the migrate verify subcommand, which checks the
+// migration files, lints them and runs them up, down and up again against a throwaway
+// database created on the database server.
+func verifyMigrationsCommand(configPath string, args []string) error {
+	flags := flag.NewFlagSet("migrate verify", flag.ContinueOnError)
+	databaseURL := flags.String("database", "", "url of the server the throwaway database is created on, the configured database server if empty")
+	largeTables := flags.String("large-tables", strings.Join(migrate.DefaultLargeTables, ","), "comma-separated tables that must be indexed concurrently")
+	sources := flags.String("sources", "store,ratelimit", "comma-separated directories of the Go files whose queries must not reference dropped columns")
+	offline := flags.Bool("offline", false, "skip the round trip, which requires a database server")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	problems, err := migrate.CheckFiles()
+	if err != nil {
+		return err
+	}
+
+	scripts, err := migrate.Scripts()
+	if err != nil {
+		return err
+	}
+	queries, err := migrate.StoreQueries(strings.Split(*sources, ",")...)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, migrate.Lint(scripts, queries, strings.Split(*largeTables, ","))...)
+
+	if !*offline {
+		logger := logger.Nop()
+		if *databaseURL == "" {
+			config, err := loadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			*databaseURL = config.Database
+			logger = newLogger(config)
+		}
+
+		roundTripProblems, err := migrate.RoundTrip(context.Background(), logger, *databaseURL)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, roundTripProblems...)
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d migration problems", len(problems))
+	}
+	fmt.Println("No migration problems found")
+	return nil
+}
+
+// seedCommand runs the seed subcommand, which applies fixture profiles and files to the
+// database. Seeding is idempotent, so it can run against an already seeded database.
+func seedCommand(configPath string, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	files := flags.String("file", "", "comma-separated YAML fixture files applied after the profiles")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	profiles := flags.Args()
+	if len(profiles) == 0 && *files == "" {
+		profiles = []string{"minimal"}
+	}
+
+	var fixtures seed.Fixtures
+	for _, profile := range profiles {
+		loaded, err := seed.Load(profile)
+		if err != nil {
+			return err
+		}
+		fixtures.Accounts = append(fixtures.Accounts, loaded.Accounts...)
+	}
+	if *files != "" {
+		for _, file := range strings.Split(*files, ",") {
+			loaded, err := seed.LoadFile(file)
+			if err != nil {
+				return err
+			}
+			fixtures.Accounts = append(fixtures.Accounts, loaded.Accounts...)
+		}
+	}
+
+	ctx := context.Background()
+	_, db, txManager, err := connectMigrated(ctx, configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := seed.Apply(ctx, txManager, fixtures)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Accounts created: %d, updated: %d, unchanged: %d\n", result.Created, result.Updated, result.Unchanged)
+	return nil
+}
+
+// adminCommand runs the admin subcommand, which manages the roles of accounts.
+func adminCommand(configPath string, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	var email string
+	role := domain.RoleAdmin
+	switch {
+	case args[0] == "bootstrap" && len(args) == 2:
+		email = args[1]
+	case args[0] == "grant" && len(args) == 3:
+		email = args[1]
+		var err error
+		if role, err = domain.ParseRole(args[2]); err != nil {
+			return fmt.Errorf("Unknown role %q, expected one of %s", args[2], domain.RoleNames())
+		}
+	default:
+		return errUsage
+	}
+
+	account := domain.Account{Email: email}
+	if err := account.NormalizeEmail(); err != nil {
+		return fmt.Errorf("Invalid email address %q", email)
+	}
+
+	ctx := context.Background()
+	config, db, txManager, err := connectMigrated(ctx, configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if args[0] == "grant" {
+		err := txManager.WithTx(ctx, func(tx store.Tx) error {
+			existing, err := tx.Accounts().GetAccount(ctx, account.Email)
+			if err != nil {
+				return err
+			}
+			return tx.Accounts().SetRole(ctx, existing.ID, role)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Role of %s set to %s\n", account.Email, role)
+		return nil
+	}
+
+	// The password is read before the transaction so that it is not held open while
+	// waiting for input. It is only needed if the account does not exist yet.
+	if _, err := store.NewAccountStore(db, config.DB.QueryTimeout).GetAccount(ctx, account.Email); err == store.ErrAccountNotFound {
+		policy, err := newPasswordPolicy(config)
+		if err != nil {
+			return err
+		}
+		if err := domain.SetBcryptCost(config.Password.BcryptCost); err != nil {
+			return err
+		}
+		if account.Password, err = readPassword(); err != nil {
+			return err
+		}
+		if err := account.ValidatePassword(policy); err != nil {
+			return err
+		}
+		if err := account.HashPassword(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	err = txManager.WithTx(ctx, func(tx store.Tx) error {
+		admins, err := tx.Accounts().CountAccountsWithRole(ctx, domain.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if admins > 0 {
+			return errors.New("An admin already exists, use admin grant to add more")
+		}
+
+		existing, err := tx.Accounts().GetAccount(ctx, account.Email)
+		if err == store.ErrAccountNotFound && account.Password != "" {
+			if existing, err = tx.Accounts().CreateAccount(ctx, account); err != nil {
+				return err
+			}
+			// The operator vouches for the address, so the admin can log in even if
+			// verification is required.
+			if err := tx.Accounts().VerifyEmail(ctx, existing.ID, existing.Email); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		return tx.Accounts().SetRole(ctx, existing.ID, domain.RoleAdmin)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now an admin\n", account.Email)
+	return nil
+}
+
+// readPassword reads a password from the terminal without echoing it, or the first line
+// of stdin if it is not a terminal, so that scripts can pipe it in.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirmation, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirmation) {
+		return "", errors.New("Passwords do not match")
+	}
+	return string(password), nil
+}
+
+// connectMigrated loads the config and connects to the database, which must be migrated
+// to the latest version, for commands that use the stores.
+func connectMigrated(ctx context.Context, configPath string) (config, *sqlx.DB, *store.TxManager, error) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return config, nil, nil, err
+	}
+	logger := newLogger(config)
+	db := dbConnect(logger, config)
+
+	version, err := migrate.LatestVersion()
+	if err == nil {
+		err = migrate.CheckVersion(ctx, db, version)
+	}
+	if err != nil {
+		db.Close()
+		return config, nil, nil, fmt.Errorf("Database is not migrated, run migrate up first: %v", err)
+	}
+
+	return config, db, newTxManager(db, config), nil
+}
+
+// printMigrationStatus writes the embedded migrations and the version of the database to w.
+func printMigrationStatus(w io.Writer, status migrate.Status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, migration := range status.Migrations {
+		applied := "no"
+		if migration.Applied {
+			applied = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", migration.Version, migration.Name, applied)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nDatabase version: %d\n", status.Version)
+	if status.Dirty {
+		fmt.Fprintln(w, "Dirty: the last migration failed; repair the database and run migrate force V")
+	}
+}
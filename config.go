@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"untitled_rpg/clientip"
+	"untitled_rpg/logger"
+	"untitled_rpg/ratelimit"
+	"untitled_rpg/store"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces the value of secret config fields when the config is printed.
+const redacted = "REDACTED"
+
+// config contains the server configuration. Every field is loaded from the environment
+// variable named after its path in the config, for example HTTP.ReadTimeout is loaded
+// from HTTP_READ_TIMEOUT. Fields tagged as secret are redacted when the config is printed.
+type config struct {
+	Debug       bool   `default:"false"` // Debug indicates whether debugging is enabled.
+	Database    string `secret:"true"`   // Database is the database connection url.
+	Port        int    // Port is the port that the server listens on.
+	MetricsPort int    `split_words:"true"`               // MetricsPort is the port that metrics are served on; if 0, they are served on /metrics of Port to clients sending the admin key.
+	Key         string `secret:"true"`                    // Key is the HS256 secret key used when generating auth tokens if no token keys are configured.
+	AdminKey    string `split_words:"true" secret:"true"` // AdminKey is the key accepted in the X-Admin-Key header of admin routes instead of an auth token; disabled if empty.
+
+	Log           logConfig           `split_words:"true"` // Log configures logging.
+	HTTP          httpConfig          `split_words:"true"` // HTTP configures the http server.
+	DB            dbConfig            `split_words:"true"` // DB configures the database connection pool.
+	Migrate       migrateConfig       `split_words:"true"` // Migrate configures database migrations.
+	Token         tokenConfig         `split_words:"true"` // Token configures auth and refresh tokens.
+	Password      passwordConfig      `split_words:"true"` // Password configures the password policy and hashing.
+	Mail          mailConfig          `split_words:"true"` // Mail configures how emails are sent.
+	SMTP          smtpConfig          `split_words:"true"` // SMTP configures the SMTP server used by the smtp mail driver.
+	Verification  verificationConfig  `split_words:"true"` // Verification configures email verification.
+	PasswordReset passwordResetConfig `split_words:"true"` // PasswordReset configures password resets.
+	MFA           mfaConfig           `split_words:"true"` // MFA configures two-factor authentication.
+	Login         loginConfig         `split_words:"true"` // Login configures lockouts after failed logins.
+	Admin         adminConfig         `split_words:"true"` // Admin configures the tools of support staff.
+	RateLimit     rateLimitConfig     `split_words:"true"` // RateLimit configures rate limiting.
+	Health        healthConfig        `split_words:"true"` // Health configures the readiness checks.
+}
+
+// logConfig configures logging.
+type logConfig struct {
+	Level           string `default:"info" split_words:"true"` // Level is the minimum level of logged messages: debug, info, warn or error. Debug forces debug.
+	Format          string `default:"json" split_words:"true"` // Format is the log output format: json or console.
+	File            string `split_words:"true"`                // File is the path of the log file; messages are written to stderr if empty.
+	MaxSizeMB       int    `default:"100" split_words:"true"`  // MaxSizeMB is the size in megabytes at which the log file is rotated.
+	MaxBackups      int    `default:"5" split_words:"true"`    // MaxBackups is the number of rotated log files kept, 0 to keep all of them.
+	MaxAgeDays      int    `default:"28" split_words:"true"`   // MaxAgeDays is the number of days rotated log files are kept, 0 to keep them forever.
+	Compress        bool   `default:"true" split_words:"true"` // Compress indicates whether rotated log files are compressed with gzip.
+	DebugSampleRate uint32 `default:"1" split_words:"true"`    // DebugSampleRate is N to log only one in every N debug messages.
+}
+
+// httpConfig configures the http server.
+type httpConfig struct {
+	ReadTimeout       time.Duration `default:"5s" split_words:"true"`  // ReadTimeout is the maximum duration for reading an entire request.
+	ReadHeaderTimeout time.Duration `default:"3s" split_words:"true"`  // ReadHeaderTimeout is the maximum duration for reading request headers.
+	WriteTimeout      time.Duration `default:"5s" split_words:"true"`  // WriteTimeout is the maximum duration before timing out writes of a response.
+	IdleTimeout       time.Duration `default:"30s" split_words:"true"` // IdleTimeout is the maximum duration to wait for the next request on a keep-alive connection.
+	ShutdownDelay     time.Duration `default:"5s" split_words:"true"`  // ShutdownDelay is how long the server reports not ready before it stops accepting connections.
+	ShutdownTimeout   time.Duration `default:"20s" split_words:"true"` // ShutdownTimeout is how long in-flight requests are given to complete on shutdown.
+	TLSCertFile       string        `split_words:"true"`               // TLSCertFile is the path of the TLS certificate; TLS is disabled if empty.
+	TLSKeyFile        string        `split_words:"true"`               // TLSKeyFile is the path of the TLS private key.
+	CORSOrigins       []string      `default:"*" split_words:"true"`   // CORSOrigins are the origins allowed to make cross-origin requests.
+	TrustedProxies    []string      `split_words:"true"`               // TrustedProxies are the ip addresses or CIDR networks of the proxies whose X-Forwarded-For header is trusted.
+}
+
+// dbConfig configures the database connection pool and transactions.
+type dbConfig struct {
+	MaxOpenConns    int           `default:"12" split_words:"true"`             // MaxOpenConns is the maximum number of open connections, 0 for unlimited.
+	MaxIdleConns    int           `default:"3" split_words:"true"`              // MaxIdleConns is the maximum number of idle connections.
+	ConnMaxLifetime time.Duration `default:"0s" split_words:"true"`             // ConnMaxLifetime is the maximum time a connection is reused, 0 for forever.
+	QueryTimeout    time.Duration `default:"3s" split_words:"true"`             // QueryTimeout is the maximum duration of a query, 0 for no timeout.
+	TxIsolation     string        `default:"read_committed" split_words:"true"` // TxIsolation is the default transaction isolation level.
+	TxMaxRetries    int           `default:"3" split_words:"true"`              // TxMaxRetries is how many times transactions failing with a serialization failure or deadlock are retried.
+}
+
+// migrateConfig configures database migrations.
+type migrateConfig struct {
+	Auto        bool          `default:"true" split_words:"true"` // Auto indicates whether pending migrations are applied on startup; disable it when several instances share the database.
+	LockTimeout time.Duration `default:"1m" split_words:"true"`   // LockTimeout is how long to wait for another instance to release the migration lock.
+}
+
+// tokenConfig configures auth and refresh tokens.
+type tokenConfig struct {
+	Keys         []string      `split_words:"true"`                        // Keys are "kid:alg:file:<path>" or "kid:alg:env:<name>" specs of the auth token keys.
+	SigningKeyID string        `split_words:"true"`                        // SigningKeyID is the id of the key in Keys used to sign new auth tokens.
+	Issuer       string        `default:"untitled_rpg" split_words:"true"` // Issuer is the iss claim of issued auth tokens.
+	Audience     string        `default:"untitled_rpg" split_words:"true"` // Audience is the aud claim of issued auth tokens.
+	AccessTTL    time.Duration `default:"15m" split_words:"true"`          // AccessTTL is how long issued auth tokens remain valid.
+	RefreshTTL   time.Duration `default:"720h" split_words:"true"`         // RefreshTTL is how long issued refresh tokens remain valid.
+}
+
+// passwordConfig configures the password policy and hashing.
+type passwordConfig struct {
+	MinLength     int  `default:"10" split_words:"true"`    // MinLength is the minimum password length in characters.
+	MaxLength     int  `default:"72" split_words:"true"`    // MaxLength is the maximum password length in bytes, at most 72.
+	RequireLower  bool `default:"false" split_words:"true"` // RequireLower indicates whether passwords need a lowercase letter.
+	RequireUpper  bool `default:"false" split_words:"true"` // RequireUpper indicates whether passwords need an uppercase letter.
+	RequireDigit  bool `default:"false" split_words:"true"` // RequireDigit indicates whether passwords need a digit.
+	RequireSymbol bool `default:"false" split_words:"true"` // RequireSymbol indicates whether passwords need a symbol.
+	BcryptCost    int  `default:"14" split_words:"true"`    // BcryptCost is the bcrypt cost used to hash passwords.
+}
+
+// mailConfig configures how emails are sent.
+type mailConfig struct {
+	Driver    string `default:"file" split_words:"true"`              // Driver is the mailer used to send emails: smtp, file or memory.
+	From      string `default:"noreply@localhost" split_words:"true"` // From is the sender address of emails.
+	OutboxDir string `default:"outbox" split_words:"true"`            // OutboxDir is the directory emails are written to by the file mailer.
+
+	Workers     int           `default:"2" split_words:"true"`   // Workers is the number of emails sent at the same time in the background.
+	QueueSize   int           `default:"100" split_words:"true"` // QueueSize is the number of emails waiting to be sent in the background before new ones are dropped.
+	SendTimeout time.Duration `default:"10s" split_words:"true"` // SendTimeout is how long sending an email in the background may take.
+}
+
+// smtpConfig configures the SMTP server used by the smtp mail driver.
+type smtpConfig struct {
+	Host     string `split_words:"true"`               // Host is the host of the SMTP server.
+	Port     int    `default:"587" split_words:"true"` // Port is the port of the SMTP server.
+	Username string `split_words:"true"`               // Username is the SMTP username, empty if the server does not require authentication.
+	Password string `split_words:"true" secret:"true"` // Password is the SMTP password.
+}
+
+// verificationConfig configures email verification.
+type verificationConfig struct {
+	URL            string        `default:"http://localhost:8080/verify" split_words:"true"` // URL is the url of the page that confirms email verification tokens.
+	TokenTTL       time.Duration `default:"24h" split_words:"true"`                          // TokenTTL is how long email verification tokens remain valid.
+	ResendInterval time.Duration `default:"1m" split_words:"true"`                           // ResendInterval is the minimum time between two verification emails.
+	Required       bool          `default:"false" split_words:"true"`                        // Required indicates whether accounts must be verified to log in.
+}
+
+// passwordResetConfig configures password resets.
+type passwordResetConfig struct {
+	URL string        `default:"http://localhost:8080/reset-password" split_words:"true"` // URL is the url of the page where a new password is chosen.
+	TTL time.Duration `default:"1h" split_words:"true"`                                   // TTL is how long password reset tokens remain valid.
+}
+
+// mfaConfig configures two-factor authentication.
+type mfaConfig struct {
+	TOTPIssuer   string        `default:"Untitled RPG" split_words:"true"` // TOTPIssuer is the name shown next to accounts in authenticator apps.
+	ChallengeTTL time.Duration `default:"5m" split_words:"true"`           // ChallengeTTL is how long a login can wait for its second factor.
+}
+
+// loginConfig configures lockouts after failed logins.
+type loginConfig struct {
+	AccountThreshold int           `default:"5" split_words:"true"`   // AccountThreshold is the number of failed logins allowed per account before it is locked.
+	IPThreshold      int           `default:"20" split_words:"true"`  // IPThreshold is the number of failed logins allowed per client ip before it is locked.
+	LockoutBaseDelay time.Duration `default:"30s" split_words:"true"` // LockoutBaseDelay is the first lockout delay, doubled with each further failure.
+	LockoutMaxDelay  time.Duration `default:"15m" split_words:"true"` // LockoutMaxDelay is the maximum lockout delay.
+}
+
+// adminConfig configures the tools of support staff.
+type adminConfig struct {
+	ImpersonationTTL time.Duration `default:"15m" split_words:"true"` // ImpersonationTTL is how long read-only impersonation tokens remain valid.
+}
+
+// rateLimitConfig configures rate limiting.
+type rateLimitConfig struct {
+	Store     string        `default:"memory" split_words:"true"`  // Store is where rate limit state is kept: memory or postgres.
+	Default   string        `default:"300/1m" split_words:"true"`  // Default is the limit applied to every client, as "<requests>/<period>".
+	Timeout   time.Duration `default:"250ms" split_words:"true"`   // Timeout is the maximum duration of a postgres store call; requests are allowed when it is exceeded.
+	KeyHeader string        `split_words:"true"`                   // KeyHeader is the header carrying the API key of clients, which are also limited per key; disabled if empty.
+	APIKeys   []string      `split_words:"true" secret:"true"`     // APIKeys are the API keys limited per key; clients sending any other key are limited per ip address.
+	KeyLimit  string        `default:"1000/1m" split_words:"true"` // KeyLimit is the limit applied to each API key, as "<requests>/<period>".
+}
+
+// healthConfig configures the readiness checks.
+type healthConfig struct {
+	CheckTimeout time.Duration `default:"2s" split_words:"true"` // CheckTimeout is how long a readiness check may take before it fails.
+}
+
+// configErrors is the list of problems found while loading the config.
+type configErrors []error
+
+// Error returns every problem on its own line.
+func (errs configErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid config:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// loadConfig loads the server configuration. Values are taken, in order of precedence,
+// from the environment, from the .env file and from the optional config file at path.
+// Fields with none of these use their default. The loaded config is validated and
+// every problem found is reported together.
+func loadConfig(path string) (config, error) {
+	var config config
+
+	godotenv.Load()
+
+	var errs configErrors
+	if path != "" {
+		if err := loadConfigFile(path, config); err != nil {
+			if fileErrs, ok := err.(configErrors); ok {
+				errs = append(errs, fileErrs...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	errs = append(errs, processEnv(&config)...)
+	errs = append(errs, config.validate()...)
+	if len(errs) > 0 {
+		return config, errs
+	}
+	return config, nil
+}
+
+// processEnv loads the config from the environment. envconfig stops at the first value
+// it cannot parse, so each invalid variable is reported and unset while the environment
+// is processed again, letting its field take its default. The environment is restored
+// afterwards.
+func processEnv(config *config) configErrors {
+	var errs configErrors
+	invalid := make(map[string]string)
+	defer func() {
+		for key, value := range invalid {
+			os.Setenv(key, value)
+		}
+	}()
+
+	for {
+		err := envconfig.Process("", config)
+		if err == nil {
+			return errs
+		}
+
+		parseErr, ok := err.(*envconfig.ParseError)
+		if !ok {
+			return append(errs, err)
+		}
+		value, set := os.LookupEnv(parseErr.KeyName)
+		if !set {
+			// The default value itself is invalid, so processing again would fail the same way.
+			return append(errs, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: invalid %s %q", parseErr.KeyName, parseErr.TypeName, value))
+		invalid[parseErr.KeyName] = value
+		os.Unsetenv(parseErr.KeyName)
+	}
+}
+
+// loadConfigFile reads a YAML or TOML config file and exports its values as environment
+// variables that are not already set, so that the environment takes precedence. Nested
+// keys are joined with underscores, so the key read_timeout in the http section sets
+// HTTP_READ_TIMEOUT. Unknown keys are reported as errors.
+func loadConfigFile(path string, config config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, field := range configFields("", reflect.ValueOf(config)) {
+		known[field.key] = true
+	}
+
+	vars := make(map[string]string)
+	flattenConfig("", values, vars)
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs configErrors
+	for _, key := range keys {
+		value := vars[key]
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("%s: unknown key in config file %s", key, path))
+			continue
+		}
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// flattenConfig adds the leaf values of a decoded config file to vars, keyed by the name
+// of the environment variable they set. Lists are joined with commas.
+func flattenConfig(prefix string, value interface{}, vars map[string]string) {
+	join := func(key string) string {
+		key = strings.ToUpper(strings.Replace(key, "-", "_", -1))
+		if prefix == "" {
+			return key
+		}
+		return prefix + "_" + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			flattenConfig(join(key), value, vars)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			flattenConfig(join(fmt.Sprint(key)), value, vars)
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		vars[prefix] = strings.Join(items, ",")
+	case nil:
+		vars[prefix] = ""
+	default:
+		vars[prefix] = fmt.Sprint(v)
+	}
+}
+
+// validate checks the loaded config and returns every problem found.
+func (c config) validate() configErrors {
+	var errs configErrors
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	checkURL := func(key string, value string) {
+		u, err := url.Parse(value)
+		check(err == nil && u.Scheme != "" && u.Host != "", key, "must be an absolute url")
+	}
+
+	check(c.Database != "", "DATABASE", "is required")
+	check(c.Port > 0 && c.Port <= 65535, "PORT", "must be between 1 and 65535")
+	check(c.MetricsPort >= 0 && c.MetricsPort <= 65535 && c.MetricsPort != c.Port, "METRICS_PORT", "must be between 0 and 65535 and differ from PORT")
+	_, err := logger.ParseLevel(c.Log.Level)
+	check(err == nil, "LOG_LEVEL", "must be debug, info, warn or error")
+	check(c.Log.Format == logger.FormatJSON || c.Log.Format == logger.FormatConsole, "LOG_FORMAT", "must be json or console")
+	check(c.Log.MaxSizeMB > 0, "LOG_MAX_SIZE_MB", "must be positive")
+	check(c.Log.MaxBackups >= 0, "LOG_MAX_BACKUPS", "must not be negative")
+	check(c.Log.MaxAgeDays >= 0, "LOG_MAX_AGE_DAYS", "must not be negative")
+
+	check(c.HTTP.ReadTimeout >= 0, "HTTP_READ_TIMEOUT", "must not be negative")
+	check(c.HTTP.ReadHeaderTimeout >= 0, "HTTP_READ_HEADER_TIMEOUT", "must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "HTTP_WRITE_TIMEOUT", "must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "HTTP_IDLE_TIMEOUT", "must not be negative")
+	check(c.HTTP.ShutdownDelay >= 0, "HTTP_SHUTDOWN_DELAY", "must not be negative")
+	check(c.HTTP.ShutdownTimeout > 0, "HTTP_SHUTDOWN_TIMEOUT", "must be positive")
+	check((c.HTTP.TLSCertFile == "") == (c.HTTP.TLSKeyFile == ""), "HTTP_TLS_CERT_FILE", "must be set together with HTTP_TLS_KEY_FILE")
+	check(len(c.HTTP.CORSOrigins) > 0, "HTTP_CORS_ORIGINS", "must not be empty")
+	_, err = clientip.NewResolver(c.HTTP.TrustedProxies)
+	check(err == nil, "HTTP_TRUSTED_PROXIES", "%v", err)
+
+	check(c.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS", "must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "DB_MAX_IDLE_CONNS", "must not exceed DB_MAX_OPEN_CONNS")
+	check(c.DB.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME", "must not be negative")
+	check(c.DB.QueryTimeout >= 0, "DB_QUERY_TIMEOUT", "must not be negative")
+	_, err = store.ParseIsolationLevel(c.DB.TxIsolation)
+	check(err == nil, "DB_TX_ISOLATION", "must be default, read_committed, repeatable_read or serializable")
+	check(c.DB.TxMaxRetries >= 0, "DB_TX_MAX_RETRIES", "must not be negative")
+	check(c.Migrate.LockTimeout > 0, "MIGRATE_LOCK_TIMEOUT", "must be positive")
+
+	check(c.Key != "" || len(c.Token.Keys) > 0, "KEY", "is required if TOKEN_KEYS is not set")
+	check(len(c.Token.Keys) == 0 || c.Token.SigningKeyID != "", "TOKEN_SIGNING_KEY_ID", "is required if TOKEN_KEYS is set")
+	check(c.Token.AccessTTL > 0, "TOKEN_ACCESS_TTL", "must be positive")
+	check(c.Token.RefreshTTL > 0, "TOKEN_REFRESH_TTL", "must be positive")
+
+	check(c.Password.MinLength >= 1, "PASSWORD_MIN_LENGTH", "must be at least 1")
+	check(c.Password.MaxLength <= 72, "PASSWORD_MAX_LENGTH", "must not exceed 72")
+	check(c.Password.MaxLength >= c.Password.MinLength, "PASSWORD_MAX_LENGTH", "must not be less than PASSWORD_MIN_LENGTH")
+	check(c.Password.BcryptCost >= bcrypt.MinCost && c.Password.BcryptCost <= bcrypt.MaxCost, "PASSWORD_BCRYPT_COST", "must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+
+	check(c.Mail.Driver == "smtp" || c.Mail.Driver == "file" || c.Mail.Driver == "memory", "MAIL_DRIVER", "must be smtp, file or memory")
+	check(c.Mail.Driver != "smtp" || c.SMTP.Host != "", "SMTP_HOST", "is required if MAIL_DRIVER is smtp")
+	check(c.Mail.Driver != "file" || c.Mail.OutboxDir != "", "MAIL_OUTBOX_DIR", "is required if MAIL_DRIVER is file")
+	check(c.Mail.Workers > 0, "MAIL_WORKERS", "must be positive")
+	check(c.Mail.QueueSize >= 0, "MAIL_QUEUE_SIZE", "must not be negative")
+	check(c.Mail.SendTimeout > 0, "MAIL_SEND_TIMEOUT", "must be positive")
+	check(c.SMTP.Port > 0 && c.SMTP.Port <= 65535, "SMTP_PORT", "must be between 1 and 65535")
+
+	checkURL("VERIFICATION_URL", c.Verification.URL)
+	check(c.Verification.TokenTTL > 0, "VERIFICATION_TOKEN_TTL", "must be positive")
+	check(c.Verification.ResendInterval >= 0, "VERIFICATION_RESEND_INTERVAL", "must not be negative")
+	checkURL("PASSWORD_RESET_URL", c.PasswordReset.URL)
+	check(c.PasswordReset.TTL > 0, "PASSWORD_RESET_TTL", "must be positive")
+	check(c.MFA.TOTPIssuer != "", "MFA_TOTP_ISSUER", "is required")
+	check(c.MFA.ChallengeTTL > 0, "MFA_CHALLENGE_TTL", "must be positive")
+
+	check(c.Login.AccountThreshold >= 1, "LOGIN_ACCOUNT_THRESHOLD", "must be at least 1")
+	check(c.Login.IPThreshold >= 1, "LOGIN_IP_THRESHOLD", "must be at least 1")
+	check(c.Login.LockoutBaseDelay > 0, "LOGIN_LOCKOUT_BASE_DELAY", "must be positive")
+	check(c.Login.LockoutMaxDelay >= c.Login.LockoutBaseDelay, "LOGIN_LOCKOUT_MAX_DELAY", "must not be less than LOGIN_LOCKOUT_BASE_DELAY")
+
+	check(c.Admin.ImpersonationTTL > 0, "ADMIN_IMPERSONATION_TTL", "must be positive")
+
+	check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "postgres", "RATE_LIMIT_STORE", "must be memory or postgres")
+	_, err = ratelimit.ParseLimit(c.RateLimit.Default)
+	check(err == nil, "RATE_LIMIT_DEFAULT", "%v", err)
+	check(c.RateLimit.Timeout > 0, "RATE_LIMIT_TIMEOUT", "must be positive")
+	if c.RateLimit.KeyHeader != "" {
+		_, err = ratelimit.ParseLimit(c.RateLimit.KeyLimit)
+		check(err == nil, "RATE_LIMIT_KEY_LIMIT", "%v", err)
+		check(len(c.RateLimit.APIKeys) > 0, "RATE_LIMIT_API_KEYS", "must not be empty when RATE_LIMIT_KEY_HEADER is set")
+	}
+
+	check(c.Health.CheckTimeout > 0, "HEALTH_CHECK_TIMEOUT", "must be positive")
+
+	return errs
+}
+
+// printConfig writes the config to w as environment variable assignments, with the
+// value of secret fields redacted.
+func printConfig(w io.Writer, config config) {
+	for _, field := range configFields("", reflect.ValueOf(config)) {
+		var value string
+		switch v := field.value.Interface().(type) {
+		case []string:
+			value = strings.Join(v, ",")
+		default:
+			value = fmt.Sprint(v)
+		}
+		if field.secret && value != "" {
+			value = redact(value)
+		}
+		fmt.Fprintf(w, "%s=%s\n", field.key, value)
+	}
+}
+
+// redact returns value with its secret parts replaced. Only the password is replaced in
+// urls, so that the rest of the url can still be checked; other values are replaced entirely.
+func redact(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	if query.Get("password") != "" {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// configField is a leaf field of the config.
+type configField struct {
+	key    string        // key is the name of the environment variable the field is loaded from.
+	value  reflect.Value // value is the value of the field.
+	secret bool          // secret indicates whether the field must be redacted when printed.
+}
+
+var (
+	// gatherRegexp and acronymRegexp split field names into words the same way envconfig does.
+	gatherRegexp  = regexp.MustCompile("([^A-Z]+|[A-Z]+[^A-Z]+|[A-Z]+)")
+	acronymRegexp = regexp.MustCompile("([A-Z]+)([A-Z][^A-Z]+)")
+)
+
+// configFields returns the leaf fields of the config struct v in declaration order,
+// keyed the same way envconfig names their environment variables.
+func configFields(prefix string, v reflect.Value) []configField {
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		key := field.Name
+		if field.Tag.Get("split_words") == "true" {
+			var words []string
+			for _, match := range gatherRegexp.FindAllString(field.Name, -1) {
+				if m := acronymRegexp.FindStringSubmatch(match); len(m) == 3 {
+					words = append(words, m[1], m[2])
+				} else {
+					words = append(words, match)
+				}
+			}
+			key = strings.Join(words, "_")
+		}
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		key = strings.ToUpper(key)
+
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(key, v.Field(i))...)
+			continue
+		}
+		fields = append(fields, configField{key: key, value: v.Field(i), secret: field.Tag.Get("secret") == "true"})
+	}
+	return fields
+}
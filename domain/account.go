@@ -2,16 +2,79 @@ package domain
 
 import (
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// defaultBcryptCost is the bcrypt cost used by HashPassword unless set with SetBcryptCost.
+const defaultBcryptCost = 14
+
+var (
+	// ErrAccountBanned is returned when a banned account is used.
+	ErrAccountBanned = errors.New("Account banned")
+	// ErrAccountSuspended is returned when a suspended account is used.
+	ErrAccountSuspended = errors.New("Account suspended")
+	// ErrPasswordResetRequired is returned when an account is used before choosing the
+	// new password required by support staff.
+	ErrPasswordResetRequired = errors.New("Password reset required")
+)
+
+var (
+	// bcryptCost is the bcrypt cost used by HashPassword.
+	bcryptCost = defaultBcryptCost
+
+	// dummyPasswordHash is a bcrypt hash, with the same cost as HashPassword, that no
+	// password is checked against successfully in practice. It is used to make login
+	// attempts for unknown accounts take as long as attempts for existing accounts.
+	dummyPasswordHash = []byte("$2a$14$eP.JRrdS5MhsivBuoVG97eK7d/rWmdv4lV.DjS5PjWKuo0uE8WaO.")
+
+	// hashObserver is called with the duration of every password hash.
+	hashObserver = func(time.Duration) {}
+)
+
+// SetBcryptCost sets the bcrypt cost used to hash passwords. Existing password hashes
+// keep the cost they were created with. It must be called before any password is hashed
+// or checked.
+func SetBcryptCost(cost int) error {
+	if cost == bcryptCost {
+		return nil
+	}
+
+	// The result of comparing against the dummy hash is never used, so the password
+	// it is generated from does not need to be secret.
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	if err != nil {
+		return err
+	}
+
+	bcryptCost = cost
+	dummyPasswordHash = hash
+	return nil
+}
+
 // Account represents a user account.
 type Account struct {
 	Meta
 	Email    string `json:"email,omitempty" db:"email" valid:"email"`
-	Password string `json:"password,omitempty" db:"password" valid:"matches(12345)"`
+	Password string `json:"password,omitempty" db:"password" valid:"-"` // Password is validated by a PasswordPolicy.
+	Role     Role   `json:"role,omitempty" db:"role" valid:"-"`         // Role is set by administrators, never by the account itself.
+
+	EmailVerifiedAt    *time.Time `json:"emailVerifiedAt,omitempty" db:"email_verified_at" valid:"-"`
+	VerificationSentAt *time.Time `json:"-" db:"verification_sent_at" valid:"-"`
+
+	TOTPSecret    *string    `json:"-" db:"totp_secret" valid:"-"`
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt,omitempty" db:"totp_enabled_at" valid:"-"`
+	TOTPLastStep  int64      `json:"-" db:"totp_last_step" valid:"-"`
+
+	// Restrictions are set by support staff, never by the account itself.
+	SuspendedUntil          *time.Time `json:"suspendedUntil,omitempty" db:"suspended_until" valid:"-"`
+	BannedAt                *time.Time `json:"bannedAt,omitempty" db:"banned_at" valid:"-"`
+	RestrictionReason       *string    `json:"restrictionReason,omitempty" db:"restriction_reason" valid:"-"`
+	PasswordResetRequiredAt *time.Time `json:"passwordResetRequiredAt,omitempty" db:"password_reset_required_at" valid:"-"`
+	SessionVersion          int        `json:"-" db:"session_version" valid:"-"` // SessionVersion is incremented to invalidate every auth token issued to the account.
 }
 
 // MarshalJSON is a custom json marshaller for Account that omits the password field.
@@ -25,9 +88,17 @@ func (account Account) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// ObservePasswordHashing sets a function that is called with the duration of every
+// password hash. It must be called before any password is hashed.
+func ObservePasswordHashing(observer func(time.Duration)) {
+	hashObserver = observer
+}
+
 // HashPassword hashes the account password.
 func (account *Account) HashPassword() error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(account.Password), 14)
+	start := time.Now()
+	bytes, err := bcrypt.GenerateFromPassword([]byte(account.Password), bcryptCost)
+	hashObserver(time.Since(start))
 	if err != nil {
 		return err
 	}
@@ -41,6 +112,55 @@ func (account Account) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// IsEmailVerified reports whether the account email address has been verified.
+func (account Account) IsEmailVerified() bool {
+	return account.EmailVerifiedAt != nil
+}
+
+// IsTOTPEnabled reports whether two-factor authentication with TOTP codes is enabled for the account.
+func (account Account) IsTOTPEnabled() bool {
+	return account.TOTPEnabledAt != nil && account.TOTPSecret != nil
+}
+
+// IsBanned reports whether the account is permanently banned.
+func (account Account) IsBanned() bool {
+	return account.BannedAt != nil
+}
+
+// IsSuspended reports whether the account is suspended at the provided time.
+func (account Account) IsSuspended(now time.Time) bool {
+	return account.SuspendedUntil != nil && account.SuspendedUntil.After(now)
+}
+
+// CheckAccess returns ErrAccountBanned, ErrAccountSuspended or ErrPasswordResetRequired
+// if the account cannot log in or use its auth tokens at the provided time.
+func (account Account) CheckAccess(now time.Time) error {
+	switch {
+	case account.IsBanned():
+		return ErrAccountBanned
+	case account.IsSuspended(now):
+		return ErrAccountSuspended
+	case account.PasswordResetRequiredAt != nil:
+		return ErrPasswordResetRequired
+	}
+	return nil
+}
+
+// IsSessionRevoked reports whether an auth token carrying the provided session version
+// was revoked along with every session of the account. Auth tokens carry the session
+// version of the account when they are issued, so tokens issued after a revocation
+// remain valid however close to it they are.
+func (account Account) IsSessionRevoked(sessionVersion int) bool {
+	return sessionVersion < account.SessionVersion
+}
+
+// CheckDummyPassword performs the same work as CheckPassword against a dummy hash.
+// It is called when no account exists so the response time does not reveal whether
+// the account exists.
+func CheckDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
 // NormalizeEmail normalizes the account email address.
 func (account *Account) NormalizeEmail() error {
 	normalizedEmail, err := govalidator.NormalizeEmail(account.Email)
@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+// AuditAction is an action of support staff recorded in the audit log.
+type AuditAction string
+
+// Audit actions.
+const (
+	AuditSuspend              AuditAction = "suspend"                // AuditSuspend is recorded when an account is suspended.
+	AuditBan                  AuditAction = "ban"                    // AuditBan is recorded when an account is banned.
+	AuditLiftRestrictions     AuditAction = "lift_restrictions"      // AuditLiftRestrictions is recorded when a suspension or ban is lifted.
+	AuditRequirePasswordReset AuditAction = "require_password_reset" // AuditRequirePasswordReset is recorded when an account must choose a new password.
+	AuditRevokeSessions       AuditAction = "revoke_sessions"        // AuditRevokeSessions is recorded when every session of an account is revoked.
+	AuditImpersonate          AuditAction = "impersonate"            // AuditImpersonate is recorded when an impersonation token is issued for an account.
+)
+
+// AuditEvent records an action taken by support staff on an account. Audit events are
+// kept when the account or the actor is deleted.
+type AuditEvent struct {
+	ID        uint64      `json:"id" db:"id"`
+	ActorID   uint64      `json:"actorId" db:"actor_id"`     // ActorID is the id of the staff account that took the action.
+	AccountID uint64      `json:"accountId" db:"account_id"` // AccountID is the id of the account the action was taken on.
+	Action    AuditAction `json:"action" db:"action"`
+	Reason    string      `json:"reason,omitempty" db:"reason"`
+	RequestID string      `json:"requestId,omitempty" db:"request_id"` // RequestID correlates the event with the log messages of the request.
+	CreatedAt time.Time   `json:"createdAt" db:"created_at"`
+}
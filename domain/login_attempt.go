@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"math"
+	"time"
+)
+
+// LoginAttempt represents the failed login attempts tracked for a key, such as a client
+// ip address or an account email address.
+type LoginAttempt struct {
+	Key           string     `json:"key" db:"key"`
+	Failures      int        `json:"failures" db:"failures"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty" db:"locked_until"`
+	LastFailureAt time.Time  `json:"lastFailureAt" db:"last_failure_at"`
+}
+
+// LockedFor returns how long the key remains locked, or zero if it is not locked.
+func (attempt LoginAttempt) LockedFor() time.Duration {
+	if attempt.LockedUntil == nil {
+		return 0
+	}
+	if d := time.Until(*attempt.LockedUntil); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// LockoutPolicy defines how long a key is locked after repeated failed login attempts.
+type LockoutPolicy struct {
+	Threshold int           // Threshold is the number of failures allowed before the key is locked.
+	BaseDelay time.Duration // BaseDelay is how long the key is locked when the threshold is reached.
+	MaxDelay  time.Duration // MaxDelay is the maximum time the key is locked for.
+}
+
+// Delay returns how long a key is locked after the provided number of failures. The
+// delay doubles with each failure beyond the threshold, up to the maximum delay.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	exp := float64(failures - p.Threshold)
+	delay := float64(p.BaseDelay) * math.Pow(2, exp)
+	if delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
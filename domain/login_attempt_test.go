@@ -0,0 +1,54 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+	"untitled_rpg/domain"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := domain.LockoutPolicy{Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Second},
+		{6, 2 * time.Second},
+		{7, 4 * time.Second},
+		{10, 32 * time.Second},
+		{11, time.Minute},
+		{100, time.Minute},
+		{10000, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginAttemptLockedFor(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name    string
+		attempt domain.LoginAttempt
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{"not locked", domain.LoginAttempt{Failures: 1}, 0, 0},
+		{"lock expired", domain.LoginAttempt{Failures: 5, LockedUntil: &past}, 0, 0},
+		{"locked", domain.LoginAttempt{Failures: 5, LockedUntil: &future}, 50 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.attempt.LockedFor(); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("LockedFor() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/markbates/pkger"
+)
+
+// bcryptMaxLength is the maximum number of password bytes used by bcrypt.
+// Any bytes beyond this limit are silently ignored when hashing.
+const bcryptMaxLength = 72
+
+// PasswordPolicy defines the rules a password must satisfy.
+type PasswordPolicy struct {
+	MinLength     int  // MinLength is the minimum password length in characters.
+	MaxLength     int  // MaxLength is the maximum password length in bytes.
+	RequireLower  bool // RequireLower indicates whether a lowercase letter is required.
+	RequireUpper  bool // RequireUpper indicates whether an uppercase letter is required.
+	RequireDigit  bool // RequireDigit indicates whether a digit is required.
+	RequireSymbol bool // RequireSymbol indicates whether a symbol or punctuation character is required.
+
+	blocklist map[string]struct{} // blocklist is the set of lowercase common passwords that are rejected.
+}
+
+// RuleError is the error of a violated validation rule. The message format and its
+// arguments are kept separately so that the message can be translated.
+type RuleError struct {
+	Format string        // Format is the English message format.
+	Args   []interface{} // Args are the arguments of the message format.
+}
+
+// Error returns the formatted English message satisfying the Error interface.
+func (e *RuleError) Error() string {
+	return fmt.Sprintf(e.Format, e.Args...)
+}
+
+// NewPasswordPolicy initializes and returns a new password policy with the provided
+// rules and the embedded list of common passwords.
+func NewPasswordPolicy(minLength int, maxLength int, requireLower bool, requireUpper bool, requireDigit bool, requireSymbol bool) (*PasswordPolicy, error) {
+	if minLength < 1 {
+		return nil, errors.New("password min length must be at least 1")
+	}
+	if maxLength > bcryptMaxLength {
+		return nil, fmt.Errorf("password max length must not exceed %d bytes", bcryptMaxLength)
+	}
+	if maxLength < minLength {
+		return nil, errors.New("password max length must not be less than min length")
+	}
+
+	blocklist, err := loadBlocklist()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasswordPolicy{
+		MinLength:     minLength,
+		MaxLength:     maxLength,
+		RequireLower:  requireLower,
+		RequireUpper:  requireUpper,
+		RequireDigit:  requireDigit,
+		RequireSymbol: requireSymbol,
+		blocklist:     blocklist,
+	}, nil
+}
+
+// Validate checks the password against every rule of the policy. The email is the
+// email address of the account the password belongs to. If any rules are violated,
+// a govalidator.Errors containing one error per violated rule is returned.
+func (p *PasswordPolicy) Validate(password string, email string) error {
+	var errs govalidator.Errors
+
+	violation := func(validator string, format string, args ...interface{}) {
+		errs = append(errs, govalidator.Error{
+			Name:      "password",
+			Err:       &RuleError{Format: format, Args: args},
+			Validator: validator,
+		})
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		violation("minLength", "must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		violation("maxLength", "must be at most %d bytes long", p.MaxLength)
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireLower && !hasLower {
+		violation("lower", "must contain a lowercase letter")
+	}
+	if p.RequireUpper && !hasUpper {
+		violation("upper", "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violation("digit", "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violation("symbol", "must contain a symbol")
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.blocklist[lower]; ok {
+		violation("common", "is too common")
+	}
+
+	if local := emailLocalPart(email); len(local) >= 3 && strings.Contains(lower, local) {
+		violation("containsEmail", "must not contain the email address")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidatePassword checks the account password against the provided policy.
+func (account Account) ValidatePassword(policy *PasswordPolicy) error {
+	return policy.Validate(account.Password, account.Email)
+}
+
+// emailLocalPart returns the lowercase part of an email address before the @ sign.
+func emailLocalPart(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		email = email[:i]
+	}
+	return strings.ToLower(email)
+}
+
+// loadBlocklist reads the embedded list of common passwords.
+func loadBlocklist() (map[string]struct{}, error) {
+	f, err := pkger.Open("/domain/common_passwords.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	blocklist := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			blocklist[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return blocklist, nil
+}
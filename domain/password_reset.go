@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// PasswordReset represents a single-use token emailed to an account that allows
+// the account password to be reset.
+type PasswordReset struct {
+	Meta
+	AccountID uint64     `db:"account_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// IsExpired reports whether the password reset has expired.
+func (reset PasswordReset) IsExpired() bool {
+	return time.Now().After(reset.ExpiresAt)
+}
+
+// IsUsed reports whether the password reset has already been used.
+func (reset PasswordReset) IsUsed() bool {
+	return reset.UsedAt != nil
+}
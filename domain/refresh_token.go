@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// RefreshToken represents an opaque, single-use token that can be exchanged for a new
+// access token. Refresh tokens issued from the same login share a family id, which
+// identifies the session.
+type RefreshToken struct {
+	Meta
+	AccountID uint64     `db:"account_id"`
+	FamilyID  string     `db:"family_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// IsExpired reports whether the refresh token has expired.
+func (token RefreshToken) IsExpired() bool {
+	return time.Now().After(token.ExpiresAt)
+}
+
+// IsRevoked reports whether the refresh token has been revoked or already used.
+func (token RefreshToken) IsRevoked() bool {
+	return token.RevokedAt != nil
+}
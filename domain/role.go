@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnknownRole is returned when parsing a role that does not exist.
+var ErrUnknownRole = errors.New("Unknown role")
+
+// Role is the role of an account, which grants it a set of permissions. Every account
+// has exactly one role.
+type Role string
+
+// Roles, from least to most privileged.
+const (
+	RolePlayer     Role = "player"      // RolePlayer is the role of new accounts, which grants no permission.
+	RoleModerator  Role = "moderator"   // RoleModerator is the role of community moderators.
+	RoleGameMaster Role = "game_master" // RoleGameMaster is the role of staff running the game world.
+	RoleAdmin      Role = "admin"       // RoleAdmin is the role of operators, which grants every permission.
+)
+
+// Roles are every role, from least to most privileged.
+var Roles = []Role{RolePlayer, RoleModerator, RoleGameMaster, RoleAdmin}
+
+// Permission allows an action restricted to some roles.
+type Permission string
+
+// Permissions.
+const (
+	PermissionManageLockouts Permission = "lockouts:manage" // PermissionManageLockouts allows listing and clearing login lockouts.
+	PermissionManageLogging  Permission = "logging:manage"  // PermissionManageLogging allows changing the log level.
+
+	PermissionViewAccounts     Permission = "accounts:read"        // PermissionViewAccounts allows searching accounts and reading their audit log.
+	PermissionModerateAccounts Permission = "accounts:moderate"    // PermissionModerateAccounts allows suspending, banning and reinstating accounts.
+	PermissionSecureAccounts   Permission = "accounts:secure"      // PermissionSecureAccounts allows forcing password resets and revoking sessions.
+	PermissionImpersonate      Permission = "accounts:impersonate" // PermissionImpersonate allows issuing read-only tokens acting as a player.
+)
+
+// rolePermissions are the permissions granted by each role other than admin.
+var rolePermissions = map[Role][]Permission{
+	RolePlayer:     {},
+	RoleModerator:  {PermissionManageLockouts, PermissionViewAccounts, PermissionModerateAccounts, PermissionSecureAccounts},
+	RoleGameMaster: {PermissionViewAccounts, PermissionImpersonate},
+}
+
+// ParseRole parses a role name.
+func ParseRole(name string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == name {
+			return role, nil
+		}
+	}
+	return "", ErrUnknownRole
+}
+
+// RoleNames returns the names of every role separated by commas.
+func RoleNames() string {
+	names := make([]string, len(Roles))
+	for i, role := range Roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Can reports whether the role grants the permission. Unknown roles grant nothing.
+func (r Role) Can(permission Permission) bool {
+	if r == RoleAdmin {
+		return true
+	}
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// LivenessHandler returns an http handler that reports that the process is alive. It
+// does not run any checks, so that a struggling dependency does not get the process
+// restarted.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler returns an http handler that runs every check of the registry and
+// reports the result of each. The response status is 503 if any check failed.
+func ReadinessHandler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := registry.Check(r.Context())
+
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		respond(w, code, report)
+	})
+}
+
+// respond replies to the request with the provided http status code and report encoded as json.
+func respond(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check statuses.
+const (
+	StatusOK          = "ok"          // StatusOK indicates that a check passed.
+	StatusUnavailable = "unavailable" // StatusUnavailable indicates that a check failed.
+)
+
+// Checker checks whether a subsystem is healthy.
+type Checker interface {
+	// Check returns an error if the subsystem is not healthy. Check should give up
+	// once ctx is done.
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is an adapter to allow the use of ordinary functions as checkers.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// check is a checker registered with a registry.
+type check struct {
+	name    string        // name identifies the check in reports.
+	timeout time.Duration // timeout is how long the check may take before it fails.
+	checker Checker       // checker performs the check.
+}
+
+// Registry is a set of named checks that together decide whether the server is ready.
+// Subsystems contribute checks by registering them.
+type Registry struct {
+	mu             sync.RWMutex
+	checks         []check
+	defaultTimeout time.Duration // defaultTimeout is the timeout of checks registered without one.
+}
+
+// Report is the result of running every check of a registry.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Result is the result of a single check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// NewRegistry initializes and returns a new registry. Checks registered without a
+// timeout fail if they take longer than defaultTimeout.
+func NewRegistry(defaultTimeout time.Duration) *Registry {
+	return &Registry{defaultTimeout: defaultTimeout}
+}
+
+// Register adds a named check to the registry. The check fails if it takes longer than
+// timeout; a zero timeout uses the default timeout of the registry.
+func (r *Registry) Register(name string, timeout time.Duration, checker Checker) {
+	if timeout <= 0 {
+		timeout = r.defaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check{name: name, timeout: timeout, checker: checker})
+}
+
+// Check runs every registered check concurrently and reports the result of each.
+// The report status is StatusOK only if every check passed.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make([]check, len(r.checks))
+	copy(checks, r.checks)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		if results[i].Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+		report.Checks[c.name] = results[i]
+	}
+	return report
+}
+
+// run runs a single check within its timeout. A check that does not return once its
+// timeout expires is reported as failed without waiting for it.
+func run(ctx context.Context, c check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"time"
+	"untitled_rpg/logger"
+)
+
+// component is a started part of the application that must be stopped on shutdown.
+type component struct {
+	name string                          // name identifies the component in logs.
+	stop func(ctx context.Context) error // stop stops the component, giving up when ctx is done.
+}
+
+// lifecycle stops the components of the application in reverse start order, so
+// that components are stopped before the components they depend on.
+type lifecycle struct {
+	logger     logger.Logger
+	components []component
+}
+
+// newLifecycle initializes and returns a new lifecycle manager.
+func newLifecycle(logger logger.Logger) *lifecycle {
+	return &lifecycle{logger: logger}
+}
+
+// register registers a started component. Components must be registered in the
+// order they are started.
+func (l *lifecycle) register(name string, stop func(ctx context.Context) error) {
+	l.components = append(l.components, component{name: name, stop: stop})
+}
+
+// stop stops every registered component in reverse start order. A component that
+// fails to stop is logged and does not prevent the remaining components from stopping.
+func (l *lifecycle) stop(ctx context.Context) {
+	for i := len(l.components) - 1; i >= 0; i-- {
+		c := l.components[i]
+		l.logger.Info().Str("component", c.name).Msg("Stopping component")
+		if err := c.stop(ctx); err != nil {
+			l.logger.Error().Err(err).Str("component", c.name).Msg("Failed to stop component")
+		}
+	}
+}
+
+// startWorker runs fn every interval in the background until the returned stop
+// function is called. Each call of fn is given a context that times out after interval
+// and is canceled if stopping gives up. Stopping waits for a running call of fn to complete.
+func startWorker(logger logger.Logger, name string, interval time.Duration, fn func(ctx context.Context) error) func(ctx context.Context) error {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	workerCtx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancelCall := context.WithTimeout(workerCtx, interval)
+				err := fn(ctx)
+				cancelCall()
+				if err != nil {
+					logger.Error().Err(err).Str("worker", name).Msg("Worker failed")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func(ctx context.Context) error {
+		close(done)
+		defer cancel()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
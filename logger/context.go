@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// contextKey is the type used for values stored in a context by this package.
+type contextKey int
+
+const (
+	// loggerKey is the context key of the logger.
+	loggerKey contextKey = iota
+)
+
+// nopLogger is a logger that discards every message.
+var nopLogger Logger = func() Logger {
+	logger := zerolog.Nop()
+	level := int32(ErrorLevel)
+	return &zerologLogger{logger: &logger, level: &level}
+}()
+
+// Nop returns a logger that discards every message.
+func Nop() Logger {
+	return nopLogger
+}
+
+// NewContext returns a copy of ctx that carries the logger.
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by ctx. If ctx carries no logger, a logger
+// that discards every message is returned.
+func FromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey).(Logger); ok {
+		return logger
+	}
+	return nopLogger
+}
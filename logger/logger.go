@@ -1,5 +1,29 @@
 package logger
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Log output formats.
+const (
+	FormatJSON    = "json"    // FormatJSON writes one JSON object per log message.
+	FormatConsole = "console" // FormatConsole writes human-friendly colored log messages.
+)
+
+// Config contains the logger configuration.
+type Config struct {
+	Level           Level  // Level is the initial minimum level of sent messages.
+	Format          string // Format is the output format, FormatJSON or FormatConsole.
+	File            string // File is the path of the log file; messages are written to stderr if empty.
+	MaxSizeMB       int    // MaxSizeMB is the size in megabytes at which the log file is rotated.
+	MaxBackups      int    // MaxBackups is the number of rotated log files kept, 0 to keep all of them.
+	MaxAgeDays      int    // MaxAgeDays is the number of days rotated log files are kept, 0 to keep them forever.
+	Compress        bool   // Compress indicates whether rotated log files are compressed with gzip.
+	DebugSampleRate uint32 // DebugSampleRate is N to send only one in every N debug messages; 0 or 1 sends all of them.
+}
+
 // Logger defines an interface to a logger.
 type Logger interface {
 	Debug() Event
@@ -8,6 +32,17 @@ type Logger interface {
 	Error() Event
 	Fatal() Event
 	Panic() Event
+	With() Context
+	SetLevel(Level)
+}
+
+// Context defines an interface to a builder of child loggers. Fields added to the
+// context are included in every message logged by the child logger.
+type Context interface {
+	Str(string, string) Context
+	Int(string, int) Context
+	Uint(string, uint) Context
+	Logger() Logger
 }
 
 // Event defines an interface to a log event containing additional context
@@ -19,5 +54,55 @@ type Event interface {
 	Str(string, string) Event
 	Int(string, int) Event
 	Uint(string, uint) Event
+	Float64(string, float64) Event
+	Bool(string, bool) Event
+	Dur(string, time.Duration) Event
+	Time(string, time.Time) Event
+	Strs(string, []string) Event
+	Interface(string, interface{}) Event
+	Object(string, ObjectMarshaler) Event
 	Err(error) Event
 }
+
+// ObjectMarshaler is implemented by types that can add themselves to a log event as a
+// nested object, without the cost of reflection.
+type ObjectMarshaler interface {
+	MarshalLogObject(Event)
+}
+
+// Level is the minimum level of the messages a logger sends.
+type Level int8
+
+// Log levels.
+const (
+	DebugLevel Level = iota // DebugLevel sends every message.
+	InfoLevel               // InfoLevel sends info, warn and error messages.
+	WarnLevel               // WarnLevel sends warn and error messages.
+	ErrorLevel              // ErrorLevel sends error messages only.
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", l)
+	}
+}
+
+// ParseLevel returns the level with the provided name.
+func ParseLevel(name string) (Level, error) {
+	for l := DebugLevel; l <= ErrorLevel; l++ {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
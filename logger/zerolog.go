@@ -1,14 +1,19 @@
 package logger
 
 import (
+	"io"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // zerologLogger is a logger implementing the Logger implementation using zerolog as the underlying logging mechanism.
 type zerologLogger struct {
 	logger *zerolog.Logger
+	level  *int32 // level is the minimum level of sent messages, shared with every child logger.
 }
 
 // zerologEvent wraps a zerolog.Event and implements the Event interface.
@@ -16,40 +21,82 @@ type zerologEvent struct {
 	event *zerolog.Event
 }
 
-// NewZerologLogger initializes and returns a new ZerologLogger.
-func NewZerologLogger(isDebug bool) Logger {
-	logLevel := zerolog.InfoLevel
-	if isDebug {
-		logLevel = zerolog.DebugLevel
-	}
-	zerolog.SetGlobalLevel(logLevel)
+// zerologContext wraps a zerolog.Context and implements the Context interface.
+type zerologContext struct {
+	context zerolog.Context
+	level   *int32
+}
+
+// zerologObject adapts an ObjectMarshaler to the zerolog.LogObjectMarshaler interface.
+type zerologObject struct {
+	object ObjectMarshaler
+}
+
+// NewZerologLogger initializes and returns a new ZerologLogger. Messages are written to
+// the configured log file, which is rotated once it reaches its maximum size, or to
+// stderr if no log file is configured.
+func NewZerologLogger(config Config) Logger {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
-	return &zerologLogger{logger: &logger}
+
+	var out io.Writer = os.Stderr
+	if config.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   config.File,
+			MaxSize:    config.MaxSizeMB,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAgeDays,
+			Compress:   config.Compress,
+		}
+	}
+	if config.Format == FormatConsole {
+		out = zerolog.ConsoleWriter{Out: out, NoColor: config.File != "", TimeFormat: "15:04:05"}
+	}
+
+	logger := zerolog.New(out).With().Timestamp().Logger()
+	if config.DebugSampleRate > 1 {
+		logger = logger.Sample(zerolog.LevelSampler{DebugSampler: &zerolog.BasicSampler{N: config.DebugSampleRate}})
+	}
+
+	level := int32(config.Level)
+	return &zerologLogger{logger: &logger, level: &level}
+}
+
+// enabled reports whether messages of the provided level are sent.
+func (l *zerologLogger) enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(l.level))
+}
+
+// event returns the zerolog event if messages of the provided level are sent, and a
+// disabled event otherwise.
+func (l *zerologLogger) event(level Level, event func() *zerolog.Event) Event {
+	if !l.enabled(level) {
+		return &zerologEvent{}
+	}
+	return &zerologEvent{event: event()}
 }
 
 // Debug starts a new log message with the log level set to debug.
 // The Msg method must be called on the returned event in order to send it.
 func (l *zerologLogger) Debug() Event {
-	return &zerologEvent{event: l.logger.Debug()}
+	return l.event(DebugLevel, l.logger.Debug)
 }
 
 // Info starts a new log message with the log level set to info.
 // The Msg method must be called on the returned event in order to send it.
 func (l *zerologLogger) Info() Event {
-	return &zerologEvent{event: l.logger.Info()}
+	return l.event(InfoLevel, l.logger.Info)
 }
 
 // Warn starts a new log message with the log level set to warn.
 // The Msg method must be called on the returned event in order to send it.
 func (l *zerologLogger) Warn() Event {
-	return &zerologEvent{event: l.logger.Warn()}
+	return l.event(WarnLevel, l.logger.Warn)
 }
 
 // Error starts a new log message with the log level set to error.
 // The Msg method must be called on the returned event in order to send it.
 func (l *zerologLogger) Error() Event {
-	return &zerologEvent{event: l.logger.Error()}
+	return l.event(ErrorLevel, l.logger.Error)
 }
 
 // Fatal starts a new log message with the log level set to fatal.
@@ -66,6 +113,19 @@ func (l *zerologLogger) Panic() Event {
 	return &zerologEvent{event: l.logger.Panic()}
 }
 
+// With creates a child logger context. Fields added to the context are included in
+// every message logged by the child logger returned by its Logger method.
+func (l *zerologLogger) With() Context {
+	return &zerologContext{context: l.logger.With(), level: l.level}
+}
+
+// SetLevel sets the minimum level of sent messages. It can be called at any time and
+// applies to the logger and every logger derived from it. Fatal and panic messages
+// are always sent.
+func (l *zerologLogger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
 // Msg sends the event with msg added as the message field if not empty.
 func (e *zerologEvent) Msg(msg string) {
 	e.event.Msg(msg)
@@ -91,8 +151,69 @@ func (e *zerologEvent) Uint(key string, num uint) Event {
 	return &zerologEvent{event: e.event.Uint(key, num)}
 }
 
+// Float64 adds the field key with num as a float64 to the Event context.
+func (e *zerologEvent) Float64(key string, num float64) Event {
+	return &zerologEvent{event: e.event.Float64(key, num)}
+}
+
+// Bool adds the field key with b as a bool to the Event context.
+func (e *zerologEvent) Bool(key string, b bool) Event {
+	return &zerologEvent{event: e.event.Bool(key, b)}
+}
+
+// Dur adds the field key with d as a number of milliseconds to the Event context.
+func (e *zerologEvent) Dur(key string, d time.Duration) Event {
+	return &zerologEvent{event: e.event.Dur(key, d)}
+}
+
+// Time adds the field key with t as a unix timestamp to the Event context.
+func (e *zerologEvent) Time(key string, t time.Time) Event {
+	return &zerologEvent{event: e.event.Time(key, t)}
+}
+
+// Strs adds the field key with strs as an array of strings to the Event context.
+func (e *zerologEvent) Strs(key string, strs []string) Event {
+	return &zerologEvent{event: e.event.Strs(key, strs)}
+}
+
+// Interface adds the field key with i marshaled using reflection to the Event context.
+func (e *zerologEvent) Interface(key string, i interface{}) Event {
+	return &zerologEvent{event: e.event.Interface(key, i)}
+}
+
+// Object adds the field key with obj as a nested object to the Event context.
+func (e *zerologEvent) Object(key string, obj ObjectMarshaler) Event {
+	return &zerologEvent{event: e.event.Object(key, zerologObject{object: obj})}
+}
+
 // Err adds the field "error" with serialized err to the Event context.
 // If err is nil, no field is added.
 func (e *zerologEvent) Err(err error) Event {
 	return &zerologEvent{event: e.event.Err(err)}
 }
+
+// MarshalZerologObject adds the fields of the object to the zerolog event.
+func (o zerologObject) MarshalZerologObject(e *zerolog.Event) {
+	o.object.MarshalLogObject(&zerologEvent{event: e})
+}
+
+// Str adds the field key with str as a string to the logger context.
+func (c *zerologContext) Str(key string, str string) Context {
+	return &zerologContext{context: c.context.Str(key, str), level: c.level}
+}
+
+// Int adds the field key with num as a int to the logger context.
+func (c *zerologContext) Int(key string, num int) Context {
+	return &zerologContext{context: c.context.Int(key, num), level: c.level}
+}
+
+// Uint adds the field key with num as a uint to the logger context.
+func (c *zerologContext) Uint(key string, num uint) Context {
+	return &zerologContext{context: c.context.Uint(key, num), level: c.level}
+}
+
+// Logger returns the child logger with the fields of the context.
+func (c *zerologContext) Logger() Logger {
+	logger := c.context.Logger()
+	return &zerologLogger{logger: &logger, level: c.level}
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Message represents an email message.
+type Message struct {
+	To      string // To is the recipient email address.
+	Subject string // Subject is the subject line of the message.
+	Body    string // Body is the plain text body of the message.
+}
+
+// Mailer defines an interface to a service that delivers email messages.
+type Mailer interface {
+	// Send delivers a message, giving up when ctx is done.
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders the message as an RFC 5322 email with the provided sender.
+func (msg Message) format(from string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return b.Bytes()
+}
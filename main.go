@@ -1,62 +1,220 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	"untitled_rpg/clientip"
+	"untitled_rpg/domain"
+	"untitled_rpg/health"
 	"untitled_rpg/logger"
+	"untitled_rpg/mail"
+	"untitled_rpg/metrics"
 	"untitled_rpg/migrate"
+	"untitled_rpg/ratelimit"
 	"untitled_rpg/server"
 	"untitled_rpg/service"
 	"untitled_rpg/store"
+	"untitled_rpg/task"
 	"untitled_rpg/token"
 
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
-	"github.com/joho/godotenv"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/rs/zerolog/log"
 )
 
 func main() {
 	// Seed random
 	rand.Seed(time.Now().UnixNano())
 
-	config := loadConfig()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path of an optional YAML or TOML config file")
+	printOnly := flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	flag.Usage = func() {
+		printUsage(flag.CommandLine.Output())
+	}
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(*configPath, flag.Args()))
+	}
+
+	config, err := loadConfig(*configPath)
+	if *printOnly {
+		if _, invalid := err.(configErrors); err == nil || invalid {
+			printConfig(os.Stdout, config)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printOnly {
+		os.Exit(0)
+	}
 
-	logger := logger.NewZerologLogger(config.Debug)
+	logger := newLogger(config)
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	logger.Info().Msg("Server starting")
 
+	lc := newLifecycle(logger)
+
 	db := dbConnect(logger, config)
+	lc.register("database", func(ctx context.Context) error {
+		return db.Close()
+	})
 
-	migrate.Migrate(logger, db)
+	if config.Migrate.Auto {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Migrate.LockTimeout)
+		err := migrate.Migrate(ctx, logger, config.Database)
+		cancel()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to migrate database")
+		}
+	} else {
+		logger.Info().Msg("Automatic migration disabled")
+	}
 
-	tokenProvider := token.NewProvider(config.Key)
-	accountStore := store.NewAccountStore(db)
-	accountService := service.NewAccountService(accountStore)
-	authService := service.NewAuthService(accountStore, tokenProvider)
+	migrationVersion, err := migrate.LatestVersion()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to read latest migration version")
+	}
 
-	server := server.NewServer(logger, config.Port, accountService, authService)
+	metricsRegistry := metrics.NewPrometheusRegistry("untitled_rpg")
+	metrics.RegisterDBStats(metricsRegistry, db.DB)
+	passwordHashDuration := metricsRegistry.Histogram("password_hash_duration_seconds", "Latency of bcrypt password hashing.", []float64{.05, .1, .25, .5, 1, 2, 4, 8})
+	domain.ObservePasswordHashing(func(d time.Duration) {
+		passwordHashDuration.Observe(d.Seconds())
+	})
 
-	go server.Start()
+	registry := health.NewRegistry(config.Health.CheckTimeout)
+	registry.Register("database", 0, health.CheckerFunc(db.PingContext))
+	registry.Register("migrations", 0, health.CheckerFunc(func(ctx context.Context) error {
+		return migrate.CheckVersion(ctx, db, migrationVersion)
+	}))
 
-	logger.Info().Msg("Server started")
+	tokenKeys := loadTokenKeys(logger, config)
+	tokenProvider := token.NewProvider(tokenKeys, config.Token.Issuer, config.Token.Audience, config.Token.AccessTTL)
+	accountStore := store.NewAccountStore(db, config.DB.QueryTimeout)
+	refreshTokenStore := store.NewRefreshTokenStore(db, config.DB.QueryTimeout)
+	passwordResetStore := store.NewPasswordResetStore(db, config.DB.QueryTimeout)
+	recoveryCodeStore := store.NewRecoveryCodeStore(db, config.DB.QueryTimeout)
+	loginAttemptStore := store.NewLoginAttemptStore(db, config.DB.QueryTimeout)
+	auditEventStore := store.NewAuditEventStore(db, config.DB.QueryTimeout)
+	txManager := newTxManager(db, config)
+	passwordPolicy, err := newPasswordPolicy(config)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create password policy")
+	}
+	if err := domain.SetBcryptCost(config.Password.BcryptCost); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set bcrypt cost")
+	}
 
-	<-c
+	mailer := newMailer(logger, config)
+	mailQueue := task.NewQueue(config.Mail.Workers, config.Mail.QueueSize, config.Mail.SendTimeout)
+	lc.register("mail queue", mailQueue.Stop)
+	verificationService := service.NewVerificationService(accountStore, tokenProvider, mailer, mailQueue, config.Verification.URL, config.Verification.TokenTTL, config.Verification.ResendInterval)
+	accountService := service.NewAccountService(txManager, accountStore, refreshTokenStore, tokenProvider, passwordPolicy, verificationService)
+	mfaService := service.NewMFAService(txManager, accountStore, refreshTokenStore, recoveryCodeStore, tokenProvider, config.MFA.TOTPIssuer)
+	loginGuard := service.NewLoginGuard(loginAttemptStore,
+		domain.LockoutPolicy{Threshold: config.Login.AccountThreshold, BaseDelay: config.Login.LockoutBaseDelay, MaxDelay: config.Login.LockoutMaxDelay},
+		domain.LockoutPolicy{Threshold: config.Login.IPThreshold, BaseDelay: config.Login.LockoutBaseDelay, MaxDelay: config.Login.LockoutMaxDelay},
+	)
+	lockoutService := service.NewLockoutService(loginAttemptStore, tokenProvider, accountStore, refreshTokenStore, config.AdminKey)
+	logLevelService := service.NewLogLevelService(logger, tokenProvider, accountStore, refreshTokenStore, config.AdminKey)
+	authService := service.NewAuthService(accountStore, refreshTokenStore, tokenProvider, mfaService, loginGuard, metricsRegistry, config.Token.RefreshTTL, config.MFA.ChallengeTTL, config.Verification.Required)
+	passwordService := service.NewPasswordService(txManager, accountStore, passwordResetStore, passwordPolicy, mailer, mailQueue, config.PasswordReset.URL, config.PasswordReset.TTL)
+	adminService := service.NewAdminService(txManager, accountStore, refreshTokenStore, auditEventStore, tokenProvider, passwordService, config.Admin.ImpersonationTTL)
 
-	logger.Info().Msg("Shutdown started")
+	resolver, err := clientip.NewResolver(config.HTTP.TrustedProxies)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create client ip resolver")
+	}
+	limiter := newLimiter(logger, db, config)
+	lc.register("pruner", startWorker(logger, "pruner", time.Minute, func(ctx context.Context) error {
+		if err := limiter.Prune(ctx); err != nil {
+			return err
+		}
+		return loginAttemptStore.PruneLoginAttempts(ctx, config.Login.LockoutMaxDelay)
+	}))
 
-	// Graceful shutdown here; stop services
+	server := server.NewServer(logger, server.Config{
+		Port:              config.Port,
+		ReadTimeout:       config.HTTP.ReadTimeout,
+		ReadHeaderTimeout: config.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      config.HTTP.WriteTimeout,
+		IdleTimeout:       config.HTTP.IdleTimeout,
+		ShutdownDelay:     config.HTTP.ShutdownDelay,
+		TLSCertFile:       config.HTTP.TLSCertFile,
+		TLSKeyFile:        config.HTTP.TLSKeyFile,
+		CORSOrigins:       config.HTTP.CORSOrigins,
+		MetricsPort:       config.MetricsPort,
+		AdminKey:          config.AdminKey,
+	}, registry, metricsRegistry, resolver, limiter, accountService, authService, verificationService, passwordService, mfaService, lockoutService, logLevelService, adminService)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	lc.register("http server", func(ctx context.Context) error {
+		return server.Stop(ctx)
+	})
+
+	logger.Info().Msg("Server started")
+
+	exitCode := 0
+	select {
+	case sig := <-c:
+		logger.Info().Str("signal", sig.String()).Msg("Shutdown started")
+	case err := <-serverErr:
+		logger.Error().Err(err).Msg("Server failed, shutdown started")
+		exitCode = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.HTTP.ShutdownDelay+config.HTTP.ShutdownTimeout)
+	defer cancel()
+	lc.stop(ctx)
 
 	logger.Info().Msg("Shutdown complete")
 
-	os.Exit(0)
+	os.Exit(exitCode)
+}
+
+// newLogger initializes the logger from the Log config. Debug forces the debug level.
+func newLogger(config config) logger.Logger {
+	level, _ := logger.ParseLevel(config.Log.Level)
+	if config.Debug {
+		level = logger.DebugLevel
+	}
+
+	return logger.NewZerologLogger(logger.Config{
+		Level:           level,
+		Format:          config.Log.Format,
+		File:            config.Log.File,
+		MaxSizeMB:       config.Log.MaxSizeMB,
+		MaxBackups:      config.Log.MaxBackups,
+		MaxAgeDays:      config.Log.MaxAgeDays,
+		Compress:        config.Log.Compress,
+		DebugSampleRate: config.Log.DebugSampleRate,
+	})
+}
+
+// newPasswordPolicy initializes the password policy from the Password config.
+func newPasswordPolicy(config config) (*domain.PasswordPolicy, error) {
+	return domain.NewPasswordPolicy(
+		config.Password.MinLength,
+		config.Password.MaxLength,
+		config.Password.RequireLower,
+		config.Password.RequireUpper,
+		config.Password.RequireDigit,
+		config.Password.RequireSymbol,
+	)
 }
 
 // dbConnect connects to postgres and pings the database to test the connection.
@@ -74,10 +232,9 @@ func dbConnect(logger logger.Logger, config config) *sqlx.DB {
 
 	logger.Info().Msg("Connected to database")
 
-	// TODO: these parameters should be set as part of the application's config
-	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(3)
-	db.SetMaxOpenConns(12)
+	db.SetConnMaxLifetime(config.DB.ConnMaxLifetime)
+	db.SetMaxIdleConns(config.DB.MaxIdleConns)
+	db.SetMaxOpenConns(config.DB.MaxOpenConns)
 
 	var dbVersion string
 	if err := db.QueryRow("SELECT version()").Scan(&dbVersion); err != nil {
@@ -87,22 +244,88 @@ func dbConnect(logger logger.Logger, config config) *sqlx.DB {
 	return db
 }
 
-// config contains the server configuration.
-type config struct {
-	Debug    bool   `default:"false"` // Debug indicates whether debugging is enabled.
-	Database string `required:"true"` // Database is the database connection url.
-	Port     int    `required:"true"` // Port is the port that the server listens on.
-	Key      string `required:"true"` // Key is the secret key used when generating auth tokens.
+// newTxManager initializes the transaction manager from the DB config.
+func newTxManager(db *sqlx.DB, config config) *store.TxManager {
+	isolation, _ := store.ParseIsolationLevel(config.DB.TxIsolation)
+	return store.NewTxManager(db, store.TxOptions{Isolation: isolation}, config.DB.TxMaxRetries, config.DB.QueryTimeout)
+}
+
+// loadTokenKeys loads the key set used to sign and verify auth tokens. If no
+// token keys are configured, Key is used as a single HS256 key.
+func loadTokenKeys(logger logger.Logger, config config) *token.KeySet {
+	if len(config.Token.Keys) == 0 {
+		key, err := token.NewHMACKey("default", []byte(config.Key))
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Either KEY or TOKEN_KEYS must be set")
+		}
+		keys, err := token.NewKeySet(key.ID, key)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to create token key set")
+		}
+		return keys
+	}
+
+	var keys []*token.Key
+	for _, spec := range config.Token.Keys {
+		key, err := token.LoadKey(spec)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load token key")
+		}
+		keys = append(keys, key)
+	}
+
+	keySet, err := token.NewKeySet(config.Token.SigningKeyID, keys...)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create token key set")
+	}
+	return keySet
+}
+
+// newMailer initializes the mailer selected by the MailDriver config.
+func newMailer(logger logger.Logger, config config) mail.Mailer {
+	switch config.Mail.Driver {
+	case "smtp":
+		return mail.NewSMTPMailer(config.SMTP.Host, config.SMTP.Port, config.SMTP.Username, config.SMTP.Password, config.Mail.From)
+	case "file":
+		mailer, err := mail.NewFileMailer(config.Mail.OutboxDir, config.Mail.From)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to create mail outbox")
+		}
+		return mailer
+	case "memory":
+		return mail.NewMemoryMailer()
+	default:
+		logger.Fatal().Str("mailDriver", config.Mail.Driver).Msg("Unknown mail driver")
+		return nil
+	}
 }
 
-// loadConfig loads the server configuration from environment.
-func loadConfig() config {
-	godotenv.Load()
+// newLimiter initializes the rate limiter selected by the RateLimitStore config.
+func newLimiter(logger logger.Logger, db *sqlx.DB, config config) *ratelimit.Limiter {
+	var store ratelimit.Store
+	switch config.RateLimit.Store {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(db, config.RateLimit.Timeout)
+	default:
+		logger.Fatal().Str("rateLimitStore", config.RateLimit.Store).Msg("Unknown rate limit store")
+	}
+
+	limit, err := ratelimit.ParseLimit(config.RateLimit.Default)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to parse default rate limit")
+	}
+	policies := []ratelimit.Policy{{Name: "default", Limit: limit}}
 
-	var config config
-	if err := envconfig.Process("", &config); err != nil {
-		log.Fatal().Err(err).Msg("Failed to parse environment variables")
+	// Clients sending a known API key are also limited per key, across all of their ip addresses.
+	if config.RateLimit.KeyHeader != "" {
+		keyLimit, err := ratelimit.ParseLimit(config.RateLimit.KeyLimit)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to parse API key rate limit")
+		}
+		policies = append(policies, ratelimit.Policy{Name: "api_key", Limit: keyLimit, Key: ratelimit.ByAPIKey(config.RateLimit.KeyHeader, config.RateLimit.APIKeys)})
 	}
 
-	return config
+	return ratelimit.NewLimiter(logger, store, policies, service.TooManyRequests)
 }
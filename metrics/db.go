@@ -0,0 +1,32 @@
+package metrics
+
+import "database/sql"
+
+// RegisterDBStats registers gauges and counters exposing the connection pool statistics
+// of db, read on every scrape.
+func RegisterDBStats(registry Registry, db *sql.DB) {
+	registry.GaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	registry.GaugeFunc("db_open_connections", "Number of established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.GaugeFunc("db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.GaugeFunc("db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	registry.CounterFunc("db_wait_count_total", "Total number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	registry.CounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	registry.CounterFunc("db_max_idle_closed_total", "Total number of connections closed due to the maximum number of idle connections.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	registry.CounterFunc("db_max_lifetime_closed_total", "Total number of connections closed due to the maximum connection lifetime.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+}
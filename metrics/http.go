@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// unmatchedRoute is the route label of requests that did not match any route, so that
+// requests to arbitrary paths cannot create an unbounded number of label values.
+const unmatchedRoute = "unmatched"
+
+// HTTPMetrics records the number and latency of http requests.
+type HTTPMetrics struct {
+	requests Counter   // requests counts requests by route, method and status.
+	duration Histogram // duration observes request latency by route and method.
+}
+
+// statusRecorder wraps an http.ResponseWriter to record the response status.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// NewHTTPMetrics initializes and returns new http metrics registered with the provided registry.
+func NewHTTPMetrics(registry Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: registry.Counter("http_requests_total", "Number of http requests by route, method and status.", "route", "method", "status"),
+		duration: registry.Histogram("http_request_duration_seconds", "Latency of http requests by route and method.", nil, "route", "method"),
+	}
+}
+
+// Middleware records the metrics of every request handled by next. Requests are labelled
+// by the path template of their mux route, so it must be used on a mux router.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := unmatchedRoute
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		m.requests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		m.duration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// WriteHeader records the status before writing it.
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
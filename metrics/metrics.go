@@ -0,0 +1,47 @@
+package metrics
+
+import "net/http"
+
+// Registry defines an interface to a metrics registry that services use to register
+// their own metrics. Metric names are prefixed with the namespace of the registry.
+// Registering two metrics with the same name panics.
+type Registry interface {
+	// Counter registers a counter partitioned by the provided label names.
+	Counter(name string, help string, labels ...string) Counter
+	// Gauge registers a gauge partitioned by the provided label names.
+	Gauge(name string, help string, labels ...string) Gauge
+	// Histogram registers a histogram with the provided buckets, partitioned by the
+	// provided label names. If buckets is nil, DefaultBuckets are used.
+	Histogram(name string, help string, buckets []float64, labels ...string) Histogram
+	// GaugeFunc registers a gauge whose value is read by calling fn on every scrape.
+	GaugeFunc(name string, help string, fn func() float64)
+	// CounterFunc registers a counter whose value is read by calling fn on every scrape.
+	// The values returned by fn must never decrease.
+	CounterFunc(name string, help string, fn func() float64)
+	// Handler returns an http handler that serves the registered metrics.
+	Handler() http.Handler
+}
+
+// Counter is a metric that only goes up. The label values must be given in the order
+// of the label names the counter was registered with.
+type Counter interface {
+	Inc(labelValues ...string)
+	Add(value float64, labelValues ...string)
+}
+
+// Gauge is a metric that can go up and down. The label values must be given in the
+// order of the label names the gauge was registered with.
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+	Inc(labelValues ...string)
+	Dec(labelValues ...string)
+}
+
+// Histogram samples observations in configurable buckets. The label values must be
+// given in the order of the label names the histogram was registered with.
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// DefaultBuckets are the default histogram buckets, in seconds, suited to request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
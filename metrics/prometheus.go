@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// prometheusRegistry is a registry implementing the Registry interface using prometheus
+// as the underlying metrics mechanism.
+type prometheusRegistry struct {
+	namespace string
+	registry  *prometheus.Registry
+}
+
+// prometheusCounter wraps a prometheus.CounterVec and implements the Counter interface.
+type prometheusCounter struct {
+	vec *prometheus.CounterVec
+}
+
+// prometheusGauge wraps a prometheus.GaugeVec and implements the Gauge interface.
+type prometheusGauge struct {
+	vec *prometheus.GaugeVec
+}
+
+// prometheusHistogram wraps a prometheus.HistogramVec and implements the Histogram interface.
+type prometheusHistogram struct {
+	vec *prometheus.HistogramVec
+}
+
+// NewPrometheusRegistry initializes and returns a new prometheus registry. The registry
+// also exposes the standard Go runtime and process metrics.
+func NewPrometheusRegistry(namespace string) Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return &prometheusRegistry{namespace: namespace, registry: registry}
+}
+
+// Counter registers a counter partitioned by the provided label names.
+func (r *prometheusRegistry) Counter(name string, help string, labels ...string) Counter {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: r.namespace, Name: name, Help: help}, labels)
+	r.registry.MustRegister(vec)
+	return &prometheusCounter{vec: vec}
+}
+
+// Gauge registers a gauge partitioned by the provided label names.
+func (r *prometheusRegistry) Gauge(name string, help string, labels ...string) Gauge {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: r.namespace, Name: name, Help: help}, labels)
+	r.registry.MustRegister(vec)
+	return &prometheusGauge{vec: vec}
+}
+
+// Histogram registers a histogram with the provided buckets, partitioned by the
+// provided label names. If buckets is nil, DefaultBuckets are used.
+func (r *prometheusRegistry) Histogram(name string, help string, buckets []float64, labels ...string) Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: r.namespace, Name: name, Help: help, Buckets: buckets}, labels)
+	r.registry.MustRegister(vec)
+	return &prometheusHistogram{vec: vec}
+}
+
+// GaugeFunc registers a gauge whose value is read by calling fn on every scrape.
+func (r *prometheusRegistry) GaugeFunc(name string, help string, fn func() float64) {
+	r.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: r.namespace, Name: name, Help: help}, fn))
+}
+
+// CounterFunc registers a counter whose value is read by calling fn on every scrape.
+func (r *prometheusRegistry) CounterFunc(name string, help string, fn func() float64) {
+	r.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: r.namespace, Name: name, Help: help}, fn))
+}
+
+// Handler returns an http handler that serves the registered metrics in the prometheus
+// exposition format.
+func (r *prometheusRegistry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+// Inc increments the counter by 1.
+func (c *prometheusCounter) Inc(labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Inc()
+}
+
+// Add adds the value, which must not be negative, to the counter.
+func (c *prometheusCounter) Add(value float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(value)
+}
+
+// Set sets the gauge to the value.
+func (g *prometheusGauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+// Inc increments the gauge by 1.
+func (g *prometheusGauge) Inc(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Inc()
+}
+
+// Dec decrements the gauge by 1.
+func (g *prometheusGauge) Dec(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Dec()
+}
+
+// Observe adds a single observation to the histogram.
+func (h *prometheusHistogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
@@ -0,0 +1,37 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// versionFormat is the time layout of migration versions.
+const versionFormat = "20060102150405"
+
+// namePattern matches valid migration names.
+var namePattern = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+
+// Create writes empty up and down migration files named after the provided name to dir,
+// versioned with the current time, and returns their paths. The migrations are embedded
+// in the binary, so it must be rebuilt before they can be applied.
+func Create(dir string, name string, now time.Time) (up string, down string, err error) {
+	if !namePattern.MatchString(name) {
+		return "", "", fmt.Errorf("Invalid migration name %q, expected lowercase words separated by underscores", name)
+	}
+
+	prefix := filepath.Join(dir, now.UTC().Format(versionFormat)+"_"+name)
+	up, down = prefix+".up.sql", prefix+".down.sql"
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", "", err
+		}
+	}
+	return up, down, nil
+}
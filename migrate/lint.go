@@ -0,0 +1,228 @@
+package migrate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultLargeTables are the tables expected to grow large in production, on which
+// building an index without CONCURRENTLY blocks writes for too long.
+var DefaultLargeTables = []string{"accounts", "refresh_tokens", "password_resets", "recovery_codes", "login_attempts"}
+
+// Script is the SQL of a migration.
+type Script struct {
+	Version uint   // Version is the version of the migration.
+	Name    string // Name describes the migration.
+	Up      string // Up is the SQL applying the migration.
+	Down    string // Down is the SQL reverting the migration, empty if it has no down file.
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([\w."]+)`)
+	createIndexPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:[\w"]+\s+)?ON\s+(?:ONLY\s+)?([\w."]+)`)
+	alterTablePattern  = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([\w."]+)\s+(.*)$`)
+	dropColumnPattern  = regexp.MustCompile(`(?is)\bDROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?([\w"]+)`)
+	dropTablePattern   = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(.+?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	lineCommentPattern = regexp.MustCompile(`--[^\n]*`)
+)
+
+// dropKeywords are the words following DROP in ALTER TABLE actions that do not drop a column.
+var dropKeywords = map[string]bool{"constraint": true, "default": true, "not": true, "identity": true, "expression": true}
+
+// Scripts returns the SQL of the embedded migrations, oldest first.
+func Scripts() ([]Script, error) {
+	src, err := newSource()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	migrations, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	scripts := make([]Script, len(migrations))
+	for i, migration := range migrations {
+		scripts[i] = Script{Version: migration.Version, Name: migration.Name}
+
+		r, _, err := src.ReadUp(migration.Version)
+		if err != nil {
+			return nil, err
+		}
+		up, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		scripts[i].Up = string(up)
+
+		r, _, err = src.ReadDown(migration.Version)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		down, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		scripts[i].Down = string(down)
+	}
+	return scripts, nil
+}
+
+// StoreQueries returns the string literals of the non-test Go files in dirs, joined per
+// file. Lint checks them for references to dropped tables and columns.
+func StoreQueries(dirs ...string) ([]string, error) {
+	var queries []string
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+			return !strings.HasSuffix(info.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				var literals []string
+				ast.Inspect(file, func(node ast.Node) bool {
+					if lit, ok := node.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						if value, err := strconv.Unquote(lit.Value); err == nil {
+							literals = append(literals, value)
+						}
+					}
+					return true
+				})
+				queries = append(queries, strings.Join(literals, "\n"))
+			}
+		}
+	}
+	return queries, nil
+}
+
+// Lint checks the up migrations of scripts for operations that are dangerous on a
+// production database:
+//
+//   - indexes built without CONCURRENTLY on one of largeTables, which blocks writes to
+//     the table during the build, unless the table is created by the same migration;
+//   - indexes built with CONCURRENTLY alongside other statements, which fails since the
+//     statements of a migration run in a single transaction;
+//   - tables and columns dropped while one of queries still references them, which
+//     breaks instances still running the previous release during a rolling deploy.
+//
+// A column counts as referenced by a query mentioning both the column and its table.
+// Statements are split on semicolons, so the check does not support semicolons within
+// string literals or function bodies.
+func Lint(scripts []Script, queries []string, largeTables []string) []Problem {
+	large := make(map[string]bool)
+	for _, table := range largeTables {
+		large[strings.ToLower(table)] = true
+	}
+
+	var problems []Problem
+	report := func(script Script, format string, args ...interface{}) {
+		problems = append(problems, Problem{Version: script.Version, Check: "lint", Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, script := range scripts {
+		statements := splitStatements(script.Up)
+		created := make(map[string]bool)
+		for _, statement := range statements {
+			if m := createTablePattern.FindStringSubmatch(statement); m != nil {
+				created[identifier(m[1])] = true
+			}
+		}
+
+		for _, statement := range statements {
+			if m := createIndexPattern.FindStringSubmatch(statement); m != nil {
+				table := identifier(m[2])
+				concurrent := m[1] != ""
+				if concurrent && len(statements) > 1 {
+					report(script, "CREATE INDEX CONCURRENTLY on %s must be the only statement of its migration", table)
+				}
+				if !concurrent && large[table] && !created[table] {
+					report(script, "CREATE INDEX on large table %s blocks writes, use CREATE INDEX CONCURRENTLY in its own migration", table)
+				}
+			}
+
+			if m := alterTablePattern.FindStringSubmatch(statement); m != nil {
+				table := identifier(m[1])
+				for _, drop := range dropColumnPattern.FindAllStringSubmatch(m[2], -1) {
+					column := identifier(drop[1])
+					if dropKeywords[column] {
+						continue
+					}
+					if referenced(queries, table, column) {
+						report(script, "Column %s.%s is dropped but still referenced by store queries", table, column)
+					}
+				}
+			}
+
+			if m := dropTablePattern.FindStringSubmatch(statement); m != nil {
+				for _, name := range strings.Split(m[1], ",") {
+					table := identifier(name)
+					if referenced(queries, table) {
+						report(script, "Table %s is dropped but still referenced by store queries", table)
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// splitStatements returns the statements of a migration without comments.
+func splitStatements(sql string) []string {
+	sql = lineCommentPattern.ReplaceAllString(sql, "")
+
+	var statements []string
+	for _, statement := range strings.Split(sql, ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// identifier returns the unqualified, unquoted and lowercased name of a database object.
+func identifier(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Trim(name, `"`))
+}
+
+// referenced reports whether one of the queries mentions every word.
+func referenced(queries []string, words ...string) bool {
+	patterns := make([]*regexp.Regexp, len(words))
+	for i, word := range words {
+		patterns[i] = regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(word) + `\b`)
+	}
+
+	for _, query := range queries {
+		matches := true
+		for _, pattern := range patterns {
+			if !pattern.MatchString(query) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
@@ -1,52 +1,334 @@
 package migrate
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"untitled_rpg/logger"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/httpfs"
+	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/markbates/pkger"
 )
 
-// Migrate checks database migration status and performs database migrations.
-func Migrate(logger logger.Logger, db *sqlx.DB) {
-	logger.Info().Msg("Starting database migration")
+// lockID is the key of the Postgres advisory lock held by a Migrator, so that instances
+// started at the same time never run migrations concurrently. It differs from the key
+// of the lock taken by the migrate library around each operation.
+const lockID int64 = 5786213492214066517
+
+// ErrDirty is returned by CheckVersion if a migration failed part way through.
+var ErrDirty = errors.New("Database migration is dirty")
+
+// Migrator applies and reverts the embedded migrations. It uses its own connections to
+// the database and holds the migration lock from its creation until it is closed.
+type Migrator struct {
+	logger logger.Logger    // logger logs the migrations run.
+	db     *sql.DB          // db is the connection pool of the migrator.
+	lock   *sql.Conn        // lock is the connection holding the advisory lock.
+	ms     *migrate.Migrate // ms runs the migrations.
+}
 
-	dbInstance, err := postgres.WithInstance(db.DB, &postgres.Config{})
+// Migration describes an embedded migration.
+type Migration struct {
+	Version uint   // Version is the version of the migration, the time it was created at.
+	Name    string // Name describes the migration.
+	Applied bool   // Applied indicates whether the database is at or past the migration.
+}
+
+// Status describes the migration state of the database.
+type Status struct {
+	Version    uint        // Version is the version of the last migration applied, 0 if none were.
+	Dirty      bool        // Dirty indicates whether the last migration failed part way through.
+	Migrations []Migration // Migrations are the embedded migrations, oldest first.
+}
+
+// migrateLogger adapts a Logger to the logger interface of the migrate library.
+type migrateLogger struct {
+	logger logger.Logger
+}
+
+// New connects a new migrator to the database at databaseURL. If another instance holds
+// the migration lock, New waits until it is released or ctx is done. The migrator must
+// be closed to release the lock.
+func New(ctx context.Context, logger logger.Logger, databaseURL string) (*Migrator, error) {
+	db, err := sql.Open("pgx", databaseURL)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to create migrate database instance")
+		return nil, err
 	}
 
-	srcInstance, err := httpfs.New(pkger.Dir("/migrate/migrations"), "")
+	m := &Migrator{logger: logger, db: db}
+	if err := m.acquireLock(ctx); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	dbInstance, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to create migrate source instance")
+		m.Close()
+		return nil, fmt.Errorf("Failed to create migrate database instance: %v", err)
 	}
 
-	ms, err := migrate.NewWithInstance("httpfs", srcInstance, "postgres", dbInstance)
+	srcInstance, err := newSource()
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to create migration service")
+		m.Close()
+		return nil, fmt.Errorf("Failed to create migrate source instance: %v", err)
 	}
 
-	version, _, err := ms.Version()
+	m.ms, err = migrate.NewWithInstance("httpfs", srcInstance, "postgres", dbInstance)
 	if err != nil {
-		if err == migrate.ErrNilVersion {
-			logger.Info().Msg("No migrations have been applied yet")
-		} else {
-			logger.Fatal().Err(err).Msg("Failed to get migration version")
+		m.Close()
+		return nil, fmt.Errorf("Failed to create migration service: %v", err)
+	}
+	m.ms.Log = migrateLogger{logger: logger}
+
+	return m, nil
+}
+
+// acquireLock takes the migration lock on a dedicated connection.
+func (m *Migrator) acquireLock(ctx context.Context) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	m.lock = conn
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockID).Scan(&locked); err != nil {
+		return err
+	}
+	if locked {
+		return nil
+	}
+
+	m.logger.Info().Msg("Waiting for another instance to finish migrating")
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("Failed to acquire migration lock: %v", err)
+	}
+	return nil
+}
+
+// Close releases the migration lock and closes the connections of the migrator.
+func (m *Migrator) Close() error {
+	var errs []string
+	if m.lock != nil {
+		if _, err := m.lock.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			errs = append(errs, err.Error())
 		}
+		if err := m.lock.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// Closing the migration service also closes the connection pool.
+	if m.ms != nil {
+		srcErr, dbErr := m.ms.Close()
+		for _, err := range []error{srcErr, dbErr} {
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	} else if err := m.db.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Failed to close migrator: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Version returns the version of the last migration applied, 0 if none were, and
+// whether it failed part way through.
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.ms.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Status returns the migration state of the database.
+func (m *Migrator) Status() (Status, error) {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return Status{}, err
+	}
+
+	migrations, err := List()
+	if err != nil {
+		return Status{}, err
+	}
+	for i := range migrations {
+		migrations[i].Applied = version > 0 && migrations[i].Version <= version
+	}
+
+	return Status{Version: version, Dirty: dirty, Migrations: migrations}, nil
+}
+
+// Up applies the next n pending migrations, or every pending migration if n is 0.
+func (m *Migrator) Up(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid number of migrations %d", n)
+	}
+
+	var err error
+	if n == 0 {
+		err = m.ms.Up()
 	} else {
-		logger.Info().Uint("migrationVersion", version).Send()
+		err = m.ms.Steps(n)
+	}
+	return m.result(err)
+}
+
+// Down reverts the last n applied migrations.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("Invalid number of migrations %d", n)
+	}
+	return m.result(m.ms.Steps(-n))
+}
+
+// Goto applies or reverts migrations until the database is at the provided version.
+func (m *Migrator) Goto(version uint) error {
+	return m.result(m.ms.Migrate(version))
+}
+
+// Force sets the migration version without running any migration and clears the dirty
+// flag. It is used to recover from a failed migration once the database was repaired
+// by hand. A version of -1 marks the database as having no migration applied.
+func (m *Migrator) Force(version int) error {
+	if err := m.ms.Force(version); err != nil {
+		return err
+	}
+	m.logger.Info().Int("migrationVersion", version).Msg("Forced migration version")
+	return nil
+}
+
+// result logs the outcome of a migration and returns its error, ignoring the error
+// reported when there was nothing to migrate.
+func (m *Migrator) result(err error) error {
+	if err == migrate.ErrNoChange {
+		m.logger.Info().Msg("No migrations to run")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	m.logger.Info().Uint("migrationVersion", version).Msg("Database migration complete")
+	return nil
+}
+
+// Migrate applies every pending migration to the database at databaseURL. If another
+// instance is migrating the database, Migrate waits until it is done or ctx is done.
+func Migrate(ctx context.Context, logger logger.Logger, databaseURL string) error {
+	logger.Info().Msg("Starting database migration")
+
+	m, err := New(ctx, logger, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			logger.Error().Err(err).Send()
+		}
+	}()
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
 	}
+	logger.Info().Uint("migrationVersion", version).Send()
 
-	if err := ms.Up(); err != nil {
-		if err == migrate.ErrNoChange {
-			logger.Info().Msg("No new migrations to apply")
-		} else {
-			logger.Fatal().Err(err).Msg("Failed to apply migrations")
+	return m.Up(0)
+}
+
+// Printf logs a message of the migrate library.
+func (l migrateLogger) Printf(format string, v ...interface{}) {
+	l.logger.Info().Msg(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+// Verbose reports false, so that only the migrations run are logged.
+func (l migrateLogger) Verbose() bool {
+	return false
+}
+
+// newSource returns the migration source of the embedded migration files.
+func newSource() (source.Driver, error) {
+	return httpfs.New(pkger.Dir("/migrate/migrations"), "")
+}
+
+// List returns the embedded migrations, oldest first. None of them is marked applied.
+func List() ([]Migration, error) {
+	src, err := newSource()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var migrations []Migration
+	version, err := src.First()
+	for err == nil {
+		r, name, readErr := src.ReadUp(version)
+		if readErr != nil {
+			return nil, readErr
 		}
+		r.Close()
+		migrations = append(migrations, Migration{Version: version, Name: name})
+
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
-	logger.Info().Msg("Database migration complete")
+	return migrations, nil
+}
+
+// LatestVersion returns the version of the most recent embedded migration, which is
+// the version the database is at once every migration has been applied.
+func LatestVersion() (uint, error) {
+	migrations, err := List()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, os.ErrNotExist
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// CheckVersion returns an error unless the database is at the expected migration version
+// and the last migration completed.
+func CheckVersion(ctx context.Context, db *sqlx.DB, expected uint) error {
+	var migration struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+	query := "SELECT version, dirty FROM " + postgres.DefaultMigrationsTable + " LIMIT 1"
+	if err := db.GetContext(ctx, &migration, query); err != nil {
+		return err
+	}
+
+	if migration.Dirty {
+		return ErrDirty
+	}
+	if migration.Version != int64(expected) {
+		return fmt.Errorf("Database is at migration version %d, expected %d", migration.Version, expected)
+	}
+	return nil
 }
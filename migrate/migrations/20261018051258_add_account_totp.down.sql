@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_secret;
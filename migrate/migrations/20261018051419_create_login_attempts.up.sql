@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+  key TEXT PRIMARY KEY,
+  failures INTEGER DEFAULT 0 NOT NULL,
+  locked_until TIMESTAMPTZ,
+  last_failure_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+CREATE INDEX IF NOT EXISTS login_attempts_locked_until_idx ON login_attempts (locked_until);
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);
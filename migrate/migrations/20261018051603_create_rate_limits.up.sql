@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+  key TEXT PRIMARY KEY,
+  tat TIMESTAMPTZ NOT NULL
+);
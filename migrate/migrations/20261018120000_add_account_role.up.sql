@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role TEXT DEFAULT 'player' NOT NULL
+  CONSTRAINT accounts_role_check CHECK (role IN ('player', 'moderator', 'game_master', 'admin'));
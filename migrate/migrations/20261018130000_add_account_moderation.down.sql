@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE accounts
+  DROP COLUMN IF EXISTS suspended_until,
+  DROP COLUMN IF EXISTS banned_at,
+  DROP COLUMN IF EXISTS restriction_reason,
+  DROP COLUMN IF EXISTS password_reset_required_at,
+  DROP COLUMN IF EXISTS session_version;
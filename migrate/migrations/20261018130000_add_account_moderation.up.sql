@@ -0,0 +1,17 @@
+ALTER TABLE accounts
+  ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS banned_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS restriction_reason TEXT,
+  ADD COLUMN IF NOT EXISTS password_reset_required_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS session_version INTEGER DEFAULT 0 NOT NULL;
+-- Audit events outlive the accounts they reference, so the ids are not foreign keys.
+CREATE TABLE IF NOT EXISTS audit_events (
+  id SERIAL PRIMARY KEY,
+  actor_id INTEGER NOT NULL,
+  account_id INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  reason TEXT DEFAULT '' NOT NULL,
+  request_id TEXT DEFAULT '' NOT NULL,
+  created_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_events_account_id_idx ON audit_events (account_id, id);
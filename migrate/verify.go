@@ -0,0 +1,301 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"untitled_rpg/logger"
+
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jmoiron/sqlx"
+	"github.com/markbates/pkger"
+)
+
+// Problem is a problem found by the migration checks.
+type Problem struct {
+	Version uint   // Version is the version of the migration with the problem, 0 if it concerns no single migration.
+	Check   string // Check is the name of the check that found the problem.
+	Message string // Message describes the problem.
+}
+
+// String returns the problem prefixed by the check and migration version.
+func (p Problem) String() string {
+	if p.Version == 0 {
+		return fmt.Sprintf("%s: %s", p.Check, p.Message)
+	}
+	return fmt.Sprintf("%s: %d: %s", p.Check, p.Version, p.Message)
+}
+
+// snapshotQuery lists the schema objects of the current schema, one per row, except the
+// migrations table. Two databases with the same rows have the same schema.
+const snapshotQuery = `
+SELECT 'COLUMN ' || table_name || '.' || column_name || ' ' || data_type
+	|| CASE WHEN is_nullable = 'NO' THEN ' NOT NULL' ELSE '' END
+	|| COALESCE(' DEFAULT ' || column_default, '')
+FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name <> $1
+UNION ALL
+SELECT 'CONSTRAINT ' || rel.relname || '.' || con.conname || ' ' || pg_get_constraintdef(con.oid)
+FROM pg_constraint con
+JOIN pg_class rel ON rel.oid = con.conrelid
+JOIN pg_namespace ns ON ns.oid = rel.relnamespace
+WHERE ns.nspname = current_schema() AND rel.relname <> $1
+UNION ALL
+SELECT 'INDEX ' || indexdef
+FROM pg_indexes
+WHERE schemaname = current_schema() AND tablename <> $1
+UNION ALL
+SELECT 'SEQUENCE ' || sequence_name
+FROM information_schema.sequences
+WHERE sequence_schema = current_schema()
+UNION ALL
+SELECT 'TYPE ' || t.typname
+FROM pg_type t
+JOIN pg_namespace ns ON ns.oid = t.typnamespace
+WHERE ns.nspname = current_schema() AND t.typtype IN ('d', 'e')`
+
+// CheckFiles checks that every embedded migration file is named after the
+// <version>_<name>.<up|down>.sql pattern and that every up migration has a down
+// migration with the same name, and the reverse.
+func CheckFiles() ([]Problem, error) {
+	dir, err := pkger.Open("/migrate/migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return checkFileNames(names), nil
+}
+
+// checkFileNames checks the names of the migration files.
+func checkFileNames(names []string) []Problem {
+	type pair struct {
+		up, down string
+	}
+	pairs := make(map[uint]*pair)
+	var versions []uint
+
+	var problems []Problem
+	for _, name := range names {
+		m, err := source.Parse(name)
+		if err != nil || !strings.HasSuffix(name, ".sql") {
+			problems = append(problems, Problem{Check: "files", Message: fmt.Sprintf("Unrecognized migration file %s", name)})
+			continue
+		}
+
+		p, ok := pairs[m.Version]
+		if !ok {
+			p = &pair{}
+			pairs[m.Version] = p
+			versions = append(versions, m.Version)
+		}
+		identifier := &p.up
+		if m.Direction == source.Down {
+			identifier = &p.down
+		}
+		if *identifier != "" {
+			problems = append(problems, Problem{Version: m.Version, Check: "files", Message: fmt.Sprintf("Duplicate %s migration %s", m.Direction, name)})
+		}
+		*identifier = m.Identifier
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	for _, version := range versions {
+		p := pairs[version]
+		switch {
+		case p.down == "":
+			problems = append(problems, Problem{Version: version, Check: "files", Message: fmt.Sprintf("Missing down migration for %s", p.up)})
+		case p.up == "":
+			problems = append(problems, Problem{Version: version, Check: "files", Message: fmt.Sprintf("Missing up migration for %s", p.down)})
+		case p.up != p.down:
+			problems = append(problems, Problem{Version: version, Check: "files", Message: fmt.Sprintf("Up migration %s and down migration %s have different names", p.up, p.down)})
+		}
+	}
+	return problems
+}
+
+// RoundTrip applies every embedded migration up, down and up again, one at a time,
+// against a throwaway database created on the server at serverURL and dropped once
+// done. It reports down migrations that do not restore the schema, up migrations that
+// do not produce the same schema when reapplied and migrations that fail or leave the
+// database dirty. The round trip stops at the first failing migration. The user of
+// serverURL must be allowed to create databases.
+func RoundTrip(ctx context.Context, logger logger.Logger, serverURL string) ([]Problem, error) {
+	server, err := sqlx.Open("pgx", serverURL)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	name := "untitled_rpg_verify_" + hex.EncodeToString(suffix)
+	dbURL, err := databaseURL(serverURL, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := server.ExecContext(ctx, `CREATE DATABASE `+name); err != nil {
+		return nil, fmt.Errorf("Failed to create throwaway database: %v", err)
+	}
+	defer func() {
+		if _, err := server.ExecContext(context.Background(), `DROP DATABASE IF EXISTS `+name); err != nil {
+			logger.Error().Err(err).Str("database", name).Msg("Failed to drop throwaway database")
+		}
+	}()
+
+	return roundTrip(ctx, logger, dbURL)
+}
+
+// roundTrip runs the round trip against the empty database at dbURL.
+func roundTrip(ctx context.Context, logger logger.Logger, dbURL string) ([]Problem, error) {
+	db, err := sqlx.Open("pgx", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	m, err := New(ctx, logger, dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	migrations, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := snapshot(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	previous := uint(0)
+	for _, migration := range migrations {
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, Problem{Version: migration.Version, Check: "round trip", Message: fmt.Sprintf(format, args...)})
+		}
+
+		// step runs a migration step and checks the version the database is left at.
+		step := func(direction string, fn func(int) error, expected uint) string {
+			if err := fn(1); err != nil {
+				return fmt.Sprintf("%s migration failed: %v", direction, err)
+			}
+			version, dirty, err := m.Version()
+			if err != nil {
+				return fmt.Sprintf("Failed to read version after %s migration: %v", direction, err)
+			}
+			if dirty {
+				return fmt.Sprintf("%s migration left the database dirty", direction)
+			}
+			if version != expected {
+				return fmt.Sprintf("%s migration left the database at version %d, expected %d", direction, version, expected)
+			}
+			return ""
+		}
+
+		if msg := step("Up", m.Up, migration.Version); msg != "" {
+			report("%s", msg)
+			return problems, nil
+		}
+		after, err := snapshot(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg := step("Down", m.Down, previous); msg != "" {
+			report("%s", msg)
+			return problems, nil
+		}
+		reverted, err := snapshot(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if diff := diffSnapshots(before, reverted); diff != "" {
+			report("Down migration does not restore the schema:%s", diff)
+		}
+
+		if msg := step("Reapplied up", m.Up, migration.Version); msg != "" {
+			report("%s", msg)
+			return problems, nil
+		}
+		reapplied, err := snapshot(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if diff := diffSnapshots(after, reapplied); diff != "" {
+			report("Reapplied up migration produces a different schema:%s", diff)
+		}
+
+		before, previous = reapplied, migration.Version
+	}
+	return problems, nil
+}
+
+// databaseURL returns serverURL with its database replaced by name.
+func databaseURL(serverURL string, name string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", fmt.Errorf("Database must be a postgres:// url")
+	}
+	u.Path = "/" + name
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// snapshot returns the sorted schema objects of the database.
+func snapshot(ctx context.Context, db *sqlx.DB) ([]string, error) {
+	var objects []string
+	if err := db.SelectContext(ctx, &objects, snapshotQuery, postgres.DefaultMigrationsTable); err != nil {
+		return nil, fmt.Errorf("Failed to snapshot schema: %v", err)
+	}
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// diffSnapshots returns the schema objects only in want prefixed by "-" and the objects
+// only in got prefixed by "+", one per line, or an empty string if the snapshots match.
+func diffSnapshots(want []string, got []string) string {
+	count := make(map[string]int)
+	for _, object := range want {
+		count[object]++
+	}
+	for _, object := range got {
+		count[object]--
+	}
+
+	var diff []string
+	for _, object := range want {
+		if count[object] > 0 {
+			diff = append(diff, "\n  - "+object)
+			count[object]--
+		}
+	}
+	for _, object := range got {
+		if count[object] < 0 {
+			diff = append(diff, "\n  + "+object)
+			count[object]++
+		}
+	}
+	return strings.Join(diff, "")
+}
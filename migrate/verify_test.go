@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"untitled_rpg/logger"
+)
+
+// TestEmbeddedMigrations runs the static checks against the embedded migrations.
+func TestEmbeddedMigrations(t *testing.T) {
+	problems, err := CheckFiles()
+	if err != nil {
+		t.Fatalf("CheckFiles() error = %v", err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+
+	scripts, err := Scripts()
+	if err != nil {
+		t.Fatalf("Scripts() error = %v", err)
+	}
+	queries, err := StoreQueries("../store", "../ratelimit")
+	if err != nil {
+		t.Fatalf("StoreQueries() error = %v", err)
+	}
+	for _, problem := range Lint(scripts, queries, DefaultLargeTables) {
+		t.Error(problem)
+	}
+}
+
+// TestRoundTrip applies every migration up, down and up again against a throwaway
+// database created on the server at TEST_DATABASE_URL.
+func TestRoundTrip(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	problems, err := RoundTrip(context.Background(), logger.Nop(), url)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
+
+func TestCheckFileNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"paired", []string{"1_a.up.sql", "1_a.down.sql", "2_b.down.sql", "2_b.up.sql"}, nil},
+		{"missing down", []string{"1_a.up.sql"}, []string{"Missing down migration for a"}},
+		{"missing up", []string{"1_a.down.sql"}, []string{"Missing up migration for a"}},
+		{"renamed", []string{"1_a.up.sql", "1_b.down.sql"}, []string{"Up migration a and down migration b have different names"}},
+		{"duplicate", []string{"1_a.up.sql", "1_b.up.sql", "1_a.down.sql"}, []string{"Duplicate up migration 1_b.up.sql", "Up migration b and down migration a have different names"}},
+		{"unrecognized", []string{"a.up.sql", "1_a.up.txt"}, []string{"Unrecognized migration file a.up.sql", "Unrecognized migration file 1_a.up.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMessages(t, checkFileNames(tt.files), tt.want)
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	queries := []string{
+		`SELECT id, email, nickname FROM accounts WHERE id = $1`,
+		`DELETE FROM sessions WHERE id = $1`,
+	}
+
+	tests := []struct {
+		name string
+		up   string
+		want []string
+	}{
+		{"index on new table", `CREATE TABLE accounts (id SERIAL); CREATE INDEX accounts_idx ON accounts (id);`, nil},
+		{"index on small table", `CREATE INDEX IF NOT EXISTS rate_limits_idx ON rate_limits (tat);`, nil},
+		{"index on large table", `CREATE INDEX IF NOT EXISTS accounts_idx ON public.accounts (email);`, []string{
+			"CREATE INDEX on large table accounts blocks writes, use CREATE INDEX CONCURRENTLY in its own migration",
+		}},
+		{"concurrent index", `CREATE UNIQUE INDEX CONCURRENTLY accounts_idx ON accounts (email);`, nil},
+		{"concurrent index with other statements", "CREATE INDEX CONCURRENTLY accounts_idx ON accounts (email);\nALTER TABLE accounts ADD COLUMN x TEXT;", []string{
+			"CREATE INDEX CONCURRENTLY on accounts must be the only statement of its migration",
+		}},
+		{"drop referenced column", `ALTER TABLE accounts DROP COLUMN IF EXISTS nickname;`, []string{
+			"Column accounts.nickname is dropped but still referenced by store queries",
+		}},
+		{"drop unreferenced column", `-- nickname is no longer read
+ALTER TABLE accounts DROP COLUMN avatar, ALTER COLUMN email DROP NOT NULL, DROP CONSTRAINT accounts_email_key;`, nil},
+		{"drop column of another table", `ALTER TABLE players DROP COLUMN nickname;`, nil},
+		{"drop referenced table", `DROP TABLE IF EXISTS old_sessions, sessions CASCADE;`, []string{
+			"Table sessions is dropped but still referenced by store queries",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scripts := []Script{{Version: 1, Name: "test", Up: tt.up}}
+			checkMessages(t, Lint(scripts, queries, DefaultLargeTables), tt.want)
+		})
+	}
+}
+
+// checkMessages checks that the messages of problems are want, in order.
+func checkMessages(t *testing.T, problems []Problem, want []string) {
+	t.Helper()
+
+	got := make([]string, len(problems))
+	for i, problem := range problems {
+		got[i] = problem.Message
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems = %q, want %q", got, want)
+	}
+}
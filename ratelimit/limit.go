@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"untitled_rpg/clientip"
+)
+
+// Limit defines how many requests are allowed within a period. Requests are allowed
+// in bursts of up to Requests, after which capacity is regained at a steady rate of
+// Requests per Period, as with a token bucket.
+type Limit struct {
+	Requests int           // Requests is the number of requests allowed per period, also the burst size.
+	Period   time.Duration // Period is the time it takes to regain the full burst.
+}
+
+// PerSecond returns a limit of n requests per second.
+func PerSecond(n int) Limit {
+	return Limit{Requests: n, Period: time.Second}
+}
+
+// PerMinute returns a limit of n requests per minute.
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// PerHour returns a limit of n requests per hour.
+func PerHour(n int) Limit {
+	return Limit{Requests: n, Period: time.Hour}
+}
+
+// ParseLimit parses a limit of the form "<requests>/<period>", such as "100/1m".
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 1 {
+		return Limit{}, fmt.Errorf("invalid rate limit requests %q", parts[0])
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit period %q", parts[1])
+	}
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// interval returns the time it takes to regain capacity for a single request.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// KeyFunc returns the key that identifies the client of a request. Requests with the
+// same key share the same limit.
+type KeyFunc func(r *http.Request) string
+
+// ByIP identifies clients by the ip address of the request, as resolved by the
+// clientip middleware so that clients behind trusted proxies are told apart.
+func ByIP(r *http.Request) string {
+	return "ip:" + clientip.FromRequest(r)
+}
+
+// ByAPIKey returns a KeyFunc that identifies clients by the API key sent in a header,
+// if it is one of the provided keys. Clients are identified by a hash of their key, so
+// keys are not kept in the rate limit state. Clients that send no key or an unknown key
+// are identified by ip address, so made up keys cannot be used to get a fresh limit.
+func ByAPIKey(header string, keys []string) KeyFunc {
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[hashKey(key)] = true
+	}
+	return func(r *http.Request) string {
+		if value := r.Header.Get(header); value != "" {
+			if hash := hashKey(value); known[hash] {
+				return "key:" + hash
+			}
+		}
+		return ByIP(r)
+	}
+}
+
+// hashKey returns the hex encoded SHA-256 hash of an API key.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Policy defines the limit applied to a route or group of routes.
+type Policy struct {
+	Name  string  // Name identifies the policy; clients have a separate limit per policy.
+	Limit Limit   // Limit is the limit applied to each client.
+	Key   KeyFunc // Key identifies the client of a request, ByIP if nil.
+}
@@ -0,0 +1,79 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"untitled_rpg/ratelimit"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ratelimit.Limit
+		wantErr bool
+	}{
+		{"100/1m", ratelimit.PerMinute(100), false},
+		{"10/1s", ratelimit.PerSecond(10), false},
+		{"5/1h", ratelimit.PerHour(5), false},
+		{"3/90s", ratelimit.Limit{Requests: 3, Period: 90 * time.Second}, false},
+		{"", ratelimit.Limit{}, true},
+		{"100", ratelimit.Limit{}, true},
+		{"0/1m", ratelimit.Limit{}, true},
+		{"-1/1m", ratelimit.Limit{}, true},
+		{"many/1m", ratelimit.Limit{}, true},
+		{"100/0s", ratelimit.Limit{}, true},
+		{"100/-1m", ratelimit.Limit{}, true},
+		{"100/minute", ratelimit.Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ratelimit.ParseLimit(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestByAPIKey(t *testing.T) {
+	keyFunc := ratelimit.ByAPIKey("X-API-Key", []string{"secret", "other"})
+
+	tests := []struct {
+		name   string
+		apiKey string
+		wantIP bool
+	}{
+		{"no API key", "", true},
+		{"unknown API key", "guess", true},
+		{"known API key", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "203.0.113.7:1234"
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			got := keyFunc(r)
+			if (got == "ip:203.0.113.7") != tt.wantIP {
+				t.Errorf("ByAPIKey() = %q, want the ip address key: %v", got, tt.wantIP)
+			}
+			if strings.Contains(got, tt.apiKey) && tt.apiKey != "" {
+				t.Errorf("ByAPIKey() = %q, contains the API key", got)
+			}
+		})
+	}
+
+	known := httptest.NewRequest(http.MethodGet, "/", nil)
+	known.Header.Set("X-API-Key", "secret")
+	other := httptest.NewRequest(http.MethodGet, "/", nil)
+	other.Header.Set("X-API-Key", "other")
+	if keyFunc(known) == keyFunc(other) {
+		t.Errorf("ByAPIKey() = %q for two different API keys", keyFunc(known))
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"untitled_rpg/logger"
+)
+
+// contextKey is the type used for values stored in a request context by this package.
+type contextKey int
+
+const (
+	// limiterKey is the context key of the limiter handling the request.
+	limiterKey contextKey = iota
+)
+
+// LimitedFunc writes the response to a request that exceeded its limit. The client
+// may retry after retryAfter.
+type LimitedFunc func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration)
+
+// Limiter enforces rate limit policies using a Store.
+type Limiter struct {
+	logger          logger.Logger // logger provides logging.
+	store           Store         // store holds the rate limit state.
+	defaultPolicies []Policy      // defaultPolicies are applied to every request.
+	limited         LimitedFunc   // limited writes the response to requests that exceeded their limit.
+}
+
+// NewLimiter initializes and returns a new limiter applying the default policies to
+// every request. Requests that exceed their limit are answered by limited, or with a
+// plain text 429 response if limited is nil.
+func NewLimiter(logger logger.Logger, store Store, defaultPolicies []Policy, limited LimitedFunc) *Limiter {
+	if limited == nil {
+		limited = func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		}
+	}
+	return &Limiter{
+		logger:          logger,
+		store:           store,
+		defaultPolicies: defaultPolicies,
+		limited:         limited,
+	}
+}
+
+// Middleware applies the default policies to every request and makes the limiter
+// available to route policies declared with Apply.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, policy := range l.defaultPolicies {
+			if !l.allow(w, r, policy) {
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), limiterKey, l)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Prune removes rate limit state that no longer affects any limit.
+func (l *Limiter) Prune(ctx context.Context) error {
+	return l.store.Prune(ctx)
+}
+
+// Apply returns a middleware that applies the policy to a route, in addition to the
+// default policy. It lets services declare per-route limits when registering routes:
+//
+//	router.Handle("/authenticate", ratelimit.Apply(policy)(handler))
+//
+// The policy is enforced by the limiter whose Middleware handled the request. If the
+// request was not handled by a limiter, the policy is not enforced.
+func Apply(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l, ok := r.Context().Value(limiterKey).(*Limiter); ok && !l.allow(w, r, policy) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow takes a request from the policy limit of the client and sets the RateLimit
+// headers. If the request is not allowed, a 429 response is written and false is
+// returned. Requests are allowed if the store fails, so an unavailable store does not
+// take the server down.
+func (l *Limiter) allow(w http.ResponseWriter, r *http.Request, policy Policy) bool {
+	keyFunc := policy.Key
+	if keyFunc == nil {
+		keyFunc = ByIP
+	}
+
+	res, err := l.store.Take(r.Context(), policy.Name+":"+keyFunc(r), policy.Limit)
+	if err != nil {
+		l.logger.Error().Err(err).Str("policy", policy.Name).Msg("Failed to check rate limit")
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(res.ResetAfter)))
+
+	if !res.Allowed {
+		header.Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+		l.limited(w, r, res.RetryAfter)
+		return false
+	}
+	return true
+}
+
+// seconds rounds a duration up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
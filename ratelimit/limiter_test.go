@@ -0,0 +1,133 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"untitled_rpg/logger"
+	"untitled_rpg/ratelimit"
+)
+
+// failingStore is a Store that always fails.
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("Store unavailable")
+}
+
+func (failingStore) Prune(ctx context.Context) error {
+	return nil
+}
+
+// ok is a handler that replies to every request with no content.
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+// serve sends a request from the ip address to the handler and returns the response.
+func serve(handler http.Handler, ip string, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	var retryAfter time.Duration
+	limiter := ratelimit.NewLimiter(logger.Nop(), ratelimit.NewMemoryStore(), []ratelimit.Policy{
+		{Name: "default", Limit: ratelimit.PerMinute(3)},
+	}, func(w http.ResponseWriter, r *http.Request, d time.Duration) {
+		retryAfter = d
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", ok)
+	mux.Handle("/strict", ratelimit.Apply(ratelimit.Policy{Name: "strict", Limit: ratelimit.PerMinute(1)})(ok))
+	handler := limiter.Middleware(mux)
+
+	// The steps run in order. The default policy allows 3 requests per minute per ip
+	// address, and the strict route additionally allows a single request per minute.
+	// Requests denied by a route policy still count against the default policy.
+	tests := []struct {
+		name           string
+		ip             string
+		path           string
+		wantStatus     int
+		wantLimit      string
+		wantRemaining  string
+		wantRetryAfter string
+	}{
+		{"first request", "203.0.113.7", "/", http.StatusNoContent, "3", "2", ""},
+		{"strict route", "203.0.113.7", "/strict", http.StatusNoContent, "1", "0", ""},
+		{"strict route again", "203.0.113.7", "/strict", http.StatusTooManyRequests, "1", "0", "60"},
+		{"over the default limit", "203.0.113.7", "/", http.StatusTooManyRequests, "3", "0", "20"},
+		{"other ip address", "203.0.113.8", "/", http.StatusNoContent, "3", "2", ""},
+	}
+	for _, tt := range tests {
+		retryAfter = 0
+		w := serve(handler, tt.ip, tt.path)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+
+		header := w.Header()
+		if got := header.Get("RateLimit-Limit"); got != tt.wantLimit {
+			t.Errorf("%s: RateLimit-Limit = %q, want %q", tt.name, got, tt.wantLimit)
+		}
+		if got := header.Get("RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("%s: RateLimit-Remaining = %q, want %q", tt.name, got, tt.wantRemaining)
+		}
+		if header.Get("RateLimit-Reset") == "" {
+			t.Errorf("%s: RateLimit-Reset is not set", tt.name)
+		}
+		if got := header.Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.name, got, tt.wantRetryAfter)
+		}
+		if w.Code == http.StatusTooManyRequests && retryAfter <= 0 {
+			t.Errorf("%s: limited func called with retry after %v, want a positive duration", tt.name, retryAfter)
+		}
+	}
+}
+
+func TestLimiterStoreFailure(t *testing.T) {
+	limiter := ratelimit.NewLimiter(logger.Nop(), failingStore{}, []ratelimit.Policy{
+		{Name: "default", Limit: ratelimit.PerMinute(1)},
+	}, nil)
+	handler := limiter.Middleware(ok)
+
+	for i := 0; i < 3; i++ {
+		if w := serve(handler, "203.0.113.7", "/"); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d with a failing store, want %d", w.Code, http.StatusNoContent)
+		}
+	}
+}
+
+func TestApplyWithoutLimiter(t *testing.T) {
+	handler := ratelimit.Apply(ratelimit.Policy{Name: "strict", Limit: ratelimit.PerMinute(1)})(ok)
+
+	for i := 0; i < 3; i++ {
+		if w := serve(handler, "203.0.113.7", "/"); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d without a limiter, want %d", w.Code, http.StatusNoContent)
+		}
+	}
+}
+
+func TestLimiterDefaultResponse(t *testing.T) {
+	limiter := ratelimit.NewLimiter(logger.Nop(), ratelimit.NewMemoryStore(), []ratelimit.Policy{
+		{Name: "api_key", Limit: ratelimit.PerMinute(1), Key: ratelimit.ByAPIKey("X-API-Key", []string{"secret"})},
+	}, nil)
+	handler := limiter.Middleware(ok)
+
+	if w := serve(handler, "203.0.113.7", "/"); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	w := serve(handler, "203.0.113.7", "/")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("status = %d with Retry-After %q, want %d with 60", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store that keeps rate limit state in memory. Limits are only
+// enforced per server instance.
+type memoryStore struct {
+	mu   sync.Mutex
+	tats map[string]time.Time // tats maps keys to the theoretical arrival time of their next request.
+}
+
+// NewMemoryStore initializes and returns a new in-memory store.
+func NewMemoryStore() Store {
+	return &memoryStore{tats: make(map[string]time.Time)}
+}
+
+// Take takes a single request from the limit of a key.
+func (s *memoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	tat, ok := s.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+
+	next := tat.Add(limit.interval())
+	if next.Sub(now) > limit.Period {
+		return result(limit, false, tat, now), nil
+	}
+
+	s.tats[key] = next
+	return result(limit, true, next, now), nil
+}
+
+// Prune removes keys whose limits are fully replenished.
+func (s *memoryStore) Prune(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, tat := range s.tats {
+		if tat.Before(now) {
+			delete(s.tats, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+	"untitled_rpg/ratelimit"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+
+	// The period is long enough that no capacity is regained while the test runs, so
+	// each request of the burst is allowed and the next one is denied until a single
+	// interval of 12 minutes elapses.
+	limit := ratelimit.PerHour(5)
+	interval := 12 * time.Minute
+
+	tests := []struct {
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{true, 4},
+		{true, 3},
+		{true, 2},
+		{true, 1},
+		{true, 0},
+		{false, 0},
+		{false, 0},
+	}
+	for i, tt := range tests {
+		res, err := s.Take(ctx, "client", limit)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if res.Allowed != tt.wantAllowed || res.Remaining != tt.wantRemaining || res.Limit != 5 {
+			t.Errorf("Take() #%d = %+v, want allowed %v with %d remaining", i+1, res, tt.wantAllowed, tt.wantRemaining)
+		}
+		if res.Allowed && res.RetryAfter != 0 {
+			t.Errorf("Take() #%d RetryAfter = %v, want 0 when allowed", i+1, res.RetryAfter)
+		}
+		if !res.Allowed && (res.RetryAfter <= interval-time.Second || res.RetryAfter > interval) {
+			t.Errorf("Take() #%d RetryAfter = %v, want about %v", i+1, res.RetryAfter, interval)
+		}
+		if wantReset := time.Duration(i+1) * interval; res.Allowed && (res.ResetAfter <= wantReset-time.Second || res.ResetAfter > wantReset) {
+			t.Errorf("Take() #%d ResetAfter = %v, want about %v", i+1, res.ResetAfter, wantReset)
+		}
+	}
+
+	res, err := s.Take(ctx, "other client", limit)
+	if err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+	if !res.Allowed || res.Remaining != 4 {
+		t.Errorf("Take() for another key = %+v, want a separate limit", res)
+	}
+}
+
+func TestMemoryStoreReplenish(t *testing.T) {
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	limit := ratelimit.Limit{Requests: 2, Period: 100 * time.Millisecond}
+
+	for i := 0; i < limit.Requests; i++ {
+		if res, err := s.Take(ctx, "client", limit); err != nil || !res.Allowed {
+			t.Fatalf("Take() = %+v, %v, want allowed", res, err)
+		}
+	}
+	res, err := s.Take(ctx, "client", limit)
+	if err != nil || res.Allowed {
+		t.Fatalf("Take() = %+v, %v, want denied", res, err)
+	}
+
+	time.Sleep(res.RetryAfter)
+	if res, err := s.Take(ctx, "client", limit); err != nil || !res.Allowed {
+		t.Errorf("Take() after RetryAfter = %+v, %v, want allowed", res, err)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	short := ratelimit.Limit{Requests: 1, Period: 10 * time.Millisecond}
+	long := ratelimit.PerHour(1)
+
+	if res, err := s.Take(ctx, "short", short); err != nil || !res.Allowed {
+		t.Fatalf("Take() = %+v, %v, want allowed", res, err)
+	}
+	if res, err := s.Take(ctx, "long", long); err != nil || !res.Allowed {
+		t.Fatalf("Take() = %+v, %v, want allowed", res, err)
+	}
+
+	time.Sleep(2 * short.Period)
+	if err := s.Prune(ctx); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	if res, err := s.Take(ctx, "long", long); err != nil || res.Allowed {
+		t.Errorf("Take() of a limit still in effect after Prune = %+v, %v, want denied", res, err)
+	}
+	if res, err := s.Take(ctx, "short", short); err != nil || !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Take() of a replenished limit after Prune = %+v, %v, want allowed", res, err)
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// postgresStore is a Store that keeps rate limit state in postgres, so limits are
+// shared by every server instance using the same database.
+type postgresStore struct {
+	db      *sqlx.DB      // db is the database handle.
+	timeout time.Duration // timeout bounds each call, so a slow database does not hold up requests.
+}
+
+// NewPostgresStore initializes and returns a new postgres store with the provided db
+// handle. Each call is canceled after timeout.
+func NewPostgresStore(db *sqlx.DB, timeout time.Duration) Store {
+	return &postgresStore{db: db, timeout: timeout}
+}
+
+// tatRow is the theoretical arrival time of a key along with the database time.
+type tatRow struct {
+	TAT time.Time `db:"tat"`
+	Now time.Time `db:"now"`
+}
+
+// Take takes a single request from the limit of a key. The update only happens if
+// the request is allowed, so a denied request is detected by no row being returned.
+func (s *postgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `INSERT INTO rate_limits (key, tat) VALUES ($1, now() + make_interval(secs => $2))
+		ON CONFLICT (key) DO UPDATE SET tat = GREATEST(rate_limits.tat, now()) + make_interval(secs => $2)
+		WHERE GREATEST(rate_limits.tat, now()) + make_interval(secs => $2) - now() <= make_interval(secs => $3)
+		RETURNING tat, now() AS now`
+	var row tatRow
+
+	err := s.db.GetContext(ctx, &row, query, key, limit.interval().Seconds(), limit.Period.Seconds())
+	if err == nil {
+		return result(limit, true, row.TAT, row.Now), nil
+	}
+	if err != sql.ErrNoRows {
+		return Result{}, err
+	}
+
+	query = `SELECT tat, now() AS now FROM rate_limits WHERE key = $1`
+	if err := s.db.GetContext(ctx, &row, query, key); err != nil {
+		return Result{}, err
+	}
+	return result(limit, false, row.TAT, row.Now), nil
+}
+
+// Prune removes keys whose limits are fully replenished.
+func (s *postgresStore) Prune(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE tat < now()`)
+	return err
+}
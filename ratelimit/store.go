@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Result describes the outcome of taking a request from a limit.
+type Result struct {
+	Allowed    bool          // Allowed indicates whether the request is allowed.
+	Limit      int           // Limit is the number of requests allowed per period.
+	Remaining  int           // Remaining is the number of requests that can still be made immediately.
+	RetryAfter time.Duration // RetryAfter is how long to wait before the next request is allowed, zero if allowed.
+	ResetAfter time.Duration // ResetAfter is how long until the full burst is available again.
+}
+
+// Store defines an interface to the storage of rate limit state.
+type Store interface {
+	// Take takes a single request from the limit of a key.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Prune removes state that no longer affects any limit.
+	Prune(ctx context.Context) error
+}
+
+// result computes the result of a request given the theoretical arrival time of the
+// next request after the request is taken, as used by the generic cell rate algorithm.
+func result(limit Limit, allowed bool, tat time.Time, now time.Time) Result {
+	interval := limit.interval()
+	burst := limit.Period
+
+	res := Result{Allowed: allowed, Limit: limit.Requests}
+	if d := tat.Sub(now); d > 0 {
+		res.ResetAfter = d
+	}
+	res.Remaining = int((burst - res.ResetAfter) / interval)
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	if !allowed {
+		res.RetryAfter = tat.Add(interval).Add(-burst).Sub(now)
+	}
+	return res
+}
@@ -0,0 +1,312 @@
+// Package seed loads declarative fixtures into the stores, so that development, QA and
+// test databases start with known accounts instead of being populated by hand.
+package seed
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"untitled_rpg/domain"
+	"untitled_rpg/store"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/markbates/pkger"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// fixturesDir is the directory of the embedded profiles, one <profile>.yaml file each.
+var fixturesDir = pkger.Include("/seed/fixtures")
+
+// profilePattern matches valid profile names.
+var profilePattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// totpEncoding is the base32 encoding of TOTP secrets.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Fixtures are the records created by a seed. Fixtures are declarative: applying them
+// again leaves the stores unchanged.
+type Fixtures struct {
+	Include  []string  `yaml:"include"`  // Include are the profiles applied before these fixtures.
+	Accounts []Account `yaml:"accounts"` // Accounts are the accounts to create.
+}
+
+// Account is an account fixture.
+type Account struct {
+	Email        string `yaml:"email"`         // Email is the email address; if Count is set, {n} is replaced by the number of each account.
+	PasswordHash string `yaml:"password_hash"` // PasswordHash is the bcrypt hash of the password.
+	Verified     bool   `yaml:"verified"`      // Verified indicates whether the email address is verified.
+	Role         string `yaml:"role"`          // Role is the role of the account, empty to leave it unchanged; new accounts are players.
+	TOTPSecret   string `yaml:"totp_secret"`   // TOTPSecret is the base32 secret of enabled two-factor authentication, empty to leave it unchanged.
+	Count        int    `yaml:"count"`         // Count is the number of accounts generated from the fixture, numbered from 1; 0 creates a single account.
+}
+
+// Result counts the accounts applied by a seed.
+type Result struct {
+	Created   int // Created is the number of accounts created.
+	Updated   int // Updated is the number of existing accounts changed to match their fixture.
+	Unchanged int // Unchanged is the number of existing accounts that already matched their fixture.
+}
+
+// Profiles returns the names of the embedded profiles.
+func Profiles() ([]string, error) {
+	dir, err := pkger.Open(fixturesDir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []string
+	for _, info := range infos {
+		if name := info.Name(); !info.IsDir() && strings.HasSuffix(name, ".yaml") {
+			profiles = append(profiles, strings.TrimSuffix(name, ".yaml"))
+		}
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// Load returns the fixtures of an embedded profile, with the fixtures of the profiles
+// it includes.
+func Load(profile string) (Fixtures, error) {
+	return load(profile, nil)
+}
+
+// LoadFile returns the fixtures of a YAML file, with the fixtures of the embedded
+// profiles it includes.
+func LoadFile(path string) (Fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Fixtures{}, err
+	}
+
+	fixtures, err := Parse(data)
+	if err != nil {
+		return Fixtures{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return resolve(fixtures, nil)
+}
+
+// MustLoad is like Load but panics if the profile cannot be loaded. It simplifies the
+// setup of tests.
+func MustLoad(profile string) Fixtures {
+	fixtures, err := Load(profile)
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+// Parse decodes and validates fixtures. Unknown keys are rejected, so that typos do not
+// silently skip records. Included profiles are not loaded.
+func Parse(data []byte) (Fixtures, error) {
+	var fixtures Fixtures
+	if err := yaml.UnmarshalStrict(data, &fixtures); err != nil {
+		return Fixtures{}, err
+	}
+
+	for i, account := range fixtures.Accounts {
+		if err := account.validate(); err != nil {
+			return Fixtures{}, fmt.Errorf("accounts[%d]: %v", i, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// load loads an embedded profile. loading holds the profiles being loaded, to detect
+// include cycles.
+func load(profile string, loading []string) (Fixtures, error) {
+	if !profilePattern.MatchString(profile) {
+		return Fixtures{}, fmt.Errorf("Invalid profile name %q", profile)
+	}
+	for _, p := range loading {
+		if p == profile {
+			return Fixtures{}, fmt.Errorf("Profile %s includes itself through %s", profile, strings.Join(loading, ", "))
+		}
+	}
+
+	f, err := pkger.Open(path.Join(fixturesDir, profile+".yaml"))
+	if os.IsNotExist(err) {
+		return Fixtures{}, fmt.Errorf("Unknown profile %q", profile)
+	}
+	if err != nil {
+		return Fixtures{}, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return Fixtures{}, err
+	}
+	fixtures, err := Parse(data)
+	if err != nil {
+		return Fixtures{}, fmt.Errorf("Profile %s: %v", profile, err)
+	}
+	return resolve(fixtures, append(loading, profile))
+}
+
+// resolve returns the fixtures preceded by the fixtures of the profiles they include.
+func resolve(fixtures Fixtures, loading []string) (Fixtures, error) {
+	var resolved Fixtures
+	for _, profile := range fixtures.Include {
+		included, err := load(profile, loading)
+		if err != nil {
+			return Fixtures{}, err
+		}
+		resolved.Accounts = append(resolved.Accounts, included.Accounts...)
+	}
+	resolved.Accounts = append(resolved.Accounts, fixtures.Accounts...)
+	return resolved, nil
+}
+
+// validate checks an account fixture.
+func (a Account) validate() error {
+	if a.Count < 0 {
+		return errors.New("count must not be negative")
+	}
+	if a.Count > 0 && !strings.Contains(a.Email, "{n}") {
+		return errors.New("email must contain {n} if count is set")
+	}
+	if email := strings.Replace(a.Email, "{n}", "1", -1); !govalidator.IsEmail(email) {
+		return fmt.Errorf("invalid email %q", a.Email)
+	}
+	if _, err := bcrypt.Cost([]byte(a.PasswordHash)); err != nil {
+		return fmt.Errorf("password_hash must be a bcrypt hash: %v", err)
+	}
+	if _, err := domain.ParseRole(a.Role); a.Role != "" && err != nil {
+		return fmt.Errorf("role must be one of %s", domain.RoleNames())
+	}
+	if _, err := totpEncoding.DecodeString(strings.ToUpper(a.TOTPSecret)); err != nil {
+		return fmt.Errorf("totp_secret must be base32 encoded: %v", err)
+	}
+	return nil
+}
+
+// expand returns the accounts generated from the fixtures, in order, with normalized
+// email addresses. If several fixtures have the same email address, the last one applies.
+func expand(fixtures Fixtures) []Account {
+	var accounts []Account
+	index := make(map[string]int)
+	add := func(account Account) {
+		account.Count = 0
+		normalized := domain.Account{Email: account.Email}
+		if err := normalized.NormalizeEmail(); err == nil {
+			account.Email = normalized.Email
+		}
+		if i, ok := index[account.Email]; ok {
+			accounts[i] = account
+			return
+		}
+		index[account.Email] = len(accounts)
+		accounts = append(accounts, account)
+	}
+
+	for _, fixture := range fixtures.Accounts {
+		if fixture.Count == 0 {
+			add(fixture)
+			continue
+		}
+		for n := 1; n <= fixture.Count; n++ {
+			account := fixture
+			account.Email = strings.Replace(fixture.Email, "{n}", strconv.Itoa(n), -1)
+			add(account)
+		}
+	}
+	return accounts
+}
+
+// Apply creates the fixtures within a single transaction. Existing accounts are updated
+// to match their fixture, so that applying the same fixtures again changes nothing.
+// Fixtures never delete records, undo the verification of an email address or disable
+// two-factor authentication, and only change roles they set.
+func Apply(ctx context.Context, transactor store.Transactor, fixtures Fixtures) (Result, error) {
+	accounts := expand(fixtures)
+
+	var result Result
+	err := transactor.WithTx(ctx, func(tx store.Tx) error {
+		result = Result{}
+		for _, account := range accounts {
+			created, updated, err := applyAccount(ctx, tx.Accounts(), account)
+			if err != nil {
+				return fmt.Errorf("Failed to seed account %s: %v", account.Email, err)
+			}
+			switch {
+			case created:
+				result.Created++
+			case updated:
+				result.Updated++
+			default:
+				result.Unchanged++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// applyAccount creates the account of a fixture, or updates the existing account to match it.
+func applyAccount(ctx context.Context, accounts store.AccountRepository, fixture Account) (created bool, updated bool, err error) {
+	existing, err := accounts.GetAccount(ctx, fixture.Email)
+	if err == store.ErrAccountNotFound {
+		existing, err = accounts.CreateAccount(ctx, domain.Account{Email: fixture.Email, Password: fixture.PasswordHash})
+		created = true
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	if existing.Password != fixture.PasswordHash {
+		if err := accounts.UpdatePassword(ctx, existing.ID, fixture.PasswordHash); err != nil {
+			return false, false, err
+		}
+		updated = true
+	}
+
+	if fixture.Verified && existing.EmailVerifiedAt == nil {
+		if err := accounts.VerifyEmail(ctx, existing.ID, existing.Email); err != nil {
+			return false, false, err
+		}
+		updated = true
+	}
+
+	if role := domain.Role(fixture.Role); role != "" && existing.Role != role {
+		if err := accounts.SetRole(ctx, existing.ID, role); err != nil {
+			return false, false, err
+		}
+		updated = true
+	}
+
+	totpMatches := existing.TOTPEnabledAt != nil && existing.TOTPSecret != nil && *existing.TOTPSecret == fixture.TOTPSecret
+	if fixture.TOTPSecret != "" && !totpMatches {
+		if existing.TOTPEnabledAt != nil {
+			if err := accounts.DisableTOTP(ctx, existing.ID); err != nil {
+				return false, false, err
+			}
+		}
+		if err := accounts.SetPendingTOTPSecret(ctx, existing.ID, fixture.TOTPSecret); err != nil {
+			return false, false, err
+		}
+		if err := accounts.EnableTOTP(ctx, existing.ID); err != nil {
+			return false, false, err
+		}
+		updated = true
+	}
+
+	return created, updated && !created, nil
+}
@@ -0,0 +1,116 @@
+package seed_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"untitled_rpg/domain"
+	"untitled_rpg/seed"
+	"untitled_rpg/store"
+)
+
+func TestProfiles(t *testing.T) {
+	profiles, err := seed.Profiles()
+	if err != nil {
+		t.Fatalf("Profiles() error = %v", err)
+	}
+	if strings.Join(profiles, ",") != "load-test,minimal,qa" {
+		t.Errorf("Profiles() = %q", profiles)
+	}
+
+	for _, profile := range profiles {
+		profile := profile
+		t.Run(profile, func(t *testing.T) {
+			s := store.NewMemoryStore()
+			fixtures := seed.MustLoad(profile)
+
+			first, err := seed.Apply(context.Background(), s, fixtures)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if first.Created == 0 || first.Updated != 0 || first.Unchanged != 0 {
+				t.Errorf("first Apply() = %+v, want only created accounts", first)
+			}
+
+			second, err := seed.Apply(context.Background(), s, fixtures)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if want := (seed.Result{Unchanged: first.Created}); second != want {
+				t.Errorf("second Apply() = %+v, want %+v", second, want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	if _, err := seed.Apply(ctx, s, seed.MustLoad("qa")); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	account, err := s.GetAccount(ctx, "qa-mfa@example.com")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if account.EmailVerifiedAt == nil || account.TOTPEnabledAt == nil {
+		t.Errorf("account = %+v, want verified with two-factor authentication", account)
+	}
+	account, err = s.GetAccount(ctx, "qa-moderator@example.com")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if account.Role != domain.RoleModerator {
+		t.Errorf("account role = %q, want %q", account.Role, domain.RoleModerator)
+	}
+
+	// Changed fixtures update existing accounts.
+	fixtures, err := seed.Parse([]byte(`
+accounts:
+  - email: qa-unverified@example.com
+    password_hash: $2a$04$0123456789012345678901uWmyPtZqWYE9YTiDR9ZFmnEuLPPXz.u
+    verified: true
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	result, err := seed.Apply(ctx, s, fixtures)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := (seed.Result{Updated: 1}); result != want {
+		t.Errorf("Apply() = %+v, want %+v", result, want)
+	}
+	account, err = s.GetAccount(ctx, "qa-unverified@example.com")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if account.Password != fixtures.Accounts[0].PasswordHash || account.EmailVerifiedAt == nil {
+		t.Errorf("account = %+v, want updated password and verified email", account)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"unknown key", "characters: []", "field characters not found"},
+		{"invalid email", "accounts: [{email: nope, password_hash: $2a$04$0123456789012345678901uWmyPtZqWYE9YTiDR9ZFmnEuLPPXz.u}]", "invalid email"},
+		{"plain password", "accounts: [{email: a@example.com, password_hash: secret}]", "password_hash must be a bcrypt hash"},
+		{"count without placeholder", "accounts: [{email: a@example.com, count: 2, password_hash: $2a$04$0123456789012345678901uWmyPtZqWYE9YTiDR9ZFmnEuLPPXz.u}]", "must contain {n}"},
+		{"invalid totp secret", "accounts: [{email: a@example.com, totp_secret: '!', password_hash: $2a$04$0123456789012345678901uWmyPtZqWYE9YTiDR9ZFmnEuLPPXz.u}]", "totp_secret must be base32"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := seed.Parse([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,10 +1,17 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
+	"untitled_rpg/clientip"
+	"untitled_rpg/health"
 	"untitled_rpg/logger"
+	"untitled_rpg/metrics"
+	"untitled_rpg/ratelimit"
 	"untitled_rpg/service"
 
 	"github.com/gorilla/handlers"
@@ -12,62 +19,159 @@ import (
 )
 
 // Server represents the http server that handles requests.
-//
 type Server struct {
-	logger         logger.Logger           // logger provides logging.
-	srv            *http.Server            // srv is the underlying http server.
-	accountService *service.AccountService // accountService is the service that handles account related requests.
-	authService    *service.AuthService    // authService is the service that handles login requests.
+	logger       logger.Logger // logger provides logging.
+	srv          *http.Server  // srv is the underlying http server.
+	metricsSrv   *http.Server  // metricsSrv serves metrics on the metrics port; nil if they are served by srv.
+	config       Config        // config is the server configuration.
+	shuttingDown int32         // shuttingDown is set to 1 once the server starts shutting down.
 }
 
-// NewServer initializes and returns a new server.
-func NewServer(logger logger.Logger, port int, accountService *service.AccountService, authService *service.AuthService) *Server {
-	s := &Server{logger: logger}
+// Config contains the server configuration.
+type Config struct {
+	Port              int           // Port is the port that the server listens on.
+	ReadTimeout       time.Duration // ReadTimeout is the maximum duration for reading an entire request.
+	ReadHeaderTimeout time.Duration // ReadHeaderTimeout is the maximum duration for reading request headers.
+	WriteTimeout      time.Duration // WriteTimeout is the maximum duration before timing out writes of a response.
+	IdleTimeout       time.Duration // IdleTimeout is the maximum duration to wait for the next request on a keep-alive connection.
+	ShutdownDelay     time.Duration // ShutdownDelay is how long the server reports not ready before it stops accepting connections.
+	TLSCertFile       string        // TLSCertFile is the path of the TLS certificate; TLS is disabled if empty.
+	TLSKeyFile        string        // TLSKeyFile is the path of the TLS private key.
+	CORSOrigins       []string      // CORSOrigins are the origins allowed to make cross-origin requests.
+	MetricsPort       int           // MetricsPort is the port that metrics are served on over plain HTTP; if 0, they are served on /metrics behind the admin key.
+	AdminKey          string        // AdminKey is the key required in the X-Admin-Key header to read metrics on /metrics.
+}
+
+// ErrShuttingDown is reported by the server readiness check once the server starts shutting down.
+var ErrShuttingDown = errors.New("Server is shutting down")
 
-	handler := s.setupServices(accountService, authService)
+// NewServer initializes and returns a new server that routes requests to the provided services.
+// The client ip address of every request is resolved by the provided resolver, and every
+// request is rate limited by the provided limiter. The server serves the liveness
+// probe on /healthz and the readiness probe on /readyz, which runs every check of the
+// provided registry along with a check that fails once the server starts shutting down.
+// The metrics of the provided metrics registry, including request metrics, are served on
+// the metrics port if one is configured, and otherwise on /metrics to clients sending the
+// admin key, so they are never public.
+func NewServer(logger logger.Logger, config Config, registry *health.Registry, metricsRegistry metrics.Registry, resolver *clientip.Resolver, limiter *ratelimit.Limiter, services ...service.Service) *Server {
+	s := &Server{logger: logger, config: config}
+
+	registry.Register("server", 0, health.CheckerFunc(s.checkShutdown))
+	handler := s.setupServices(registry, metricsRegistry, resolver, limiter, services...)
 
-	// TODO: timeout values from config object as well as port
 	s.srv = &http.Server{
-		Addr:              ":" + strconv.Itoa(port),
-		ReadTimeout:       5 * time.Second,
-		WriteTimeout:      5 * time.Second,
-		IdleTimeout:       30 * time.Second,
-		ReadHeaderTimeout: 3 * time.Second,
+		Addr:              ":" + strconv.Itoa(config.Port),
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
 		Handler:           handler,
 	}
+	if config.MetricsPort != 0 {
+		s.metricsSrv = &http.Server{
+			Addr:              ":" + strconv.Itoa(config.MetricsPort),
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			Handler:           metricsRegistry.Handler(),
+		}
+	}
 	return s
 }
 
-// Start starts the server.
-func (s *Server) Start() {
-	if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		s.logger.Fatal().Err(err).Send()
+// Start starts the server and blocks until the server fails or is stopped. The server
+// serves HTTPS if a TLS certificate is configured, while metrics are served over plain
+// HTTP on the metrics port, which is meant to be reachable only from the internal network.
+// An error is returned if the server fails; nil is returned once it is stopped.
+func (s *Server) Start() error {
+	errs := make(chan error, 2)
+	if s.metricsSrv != nil {
+		go func() {
+			errs <- s.metricsSrv.ListenAndServe()
+		}()
 	}
+	go func() {
+		if s.config.TLSCertFile != "" {
+			errs <- s.srv.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+		} else {
+			errs <- s.srv.ListenAndServe()
+		}
+	}()
+
+	if err := <-errs; err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
-// Stop stops the server.
-func (s *Server) Stop() {
+// Stop gracefully stops the server. The server first reports not ready for the
+// shutdown delay so load balancers stop routing new requests to it, then stops
+// accepting connections and waits for in-flight requests to complete. If ctx is
+// done before all requests complete, the remaining connections are closed and the
+// context error is returned.
+func (s *Server) Stop(ctx context.Context) error {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	select {
+	case <-time.After(s.config.ShutdownDelay):
+	case <-ctx.Done():
+	}
 
+	if s.metricsSrv != nil {
+		if err := s.metricsSrv.Shutdown(ctx); err != nil {
+			s.metricsSrv.Close()
+		}
+	}
+	if err := s.srv.Shutdown(ctx); err != nil {
+		s.srv.Close()
+		return err
+	}
+	return nil
+}
+
+// checkShutdown is a health check that fails once the server starts shutting down.
+func (s *Server) checkShutdown(ctx context.Context) error {
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		return ErrShuttingDown
+	}
+	return nil
 }
 
 // setupServices initializes the server's http handler and then attaches core
 // middleware functions and services.
-func (s *Server) setupServices(services ...service.Service) http.Handler {
+func (s *Server) setupServices(registry *health.Registry, metricsRegistry metrics.Registry, resolver *clientip.Resolver, limiter *ratelimit.Limiter, services ...service.Service) http.Handler {
 	router := mux.NewRouter()
 
-	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-	})
+	// The access log and metrics are recorded by route, so they are applied by the router.
+	// The router only applies middleware to requests that match a route, so unmatched
+	// requests are wrapped separately.
+	httpMetrics := metrics.NewHTTPMetrics(metricsRegistry)
+	router.Use(service.AccessLog, httpMetrics.Middleware, limiter.Middleware)
+	router.NotFoundHandler = service.AccessLog(httpMetrics.Middleware(http.HandlerFunc(service.NotFound)))
+	router.MethodNotAllowedHandler = service.AccessLog(httpMetrics.Middleware(http.HandlerFunc(service.MethodNotAllowed)))
 
 	for _, s := range services {
 		s.Register(router)
 	}
 
-	CORSHeaders := handlers.AllowedHeaders([]string{"Authorization", "Content-Type", "User-Agent"})
-	CORSOrigins := handlers.AllowedOrigins([]string{"*"})
-	CORSMethods := handlers.AllowedMethods([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete})
-	handler := handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(router)
-	handler = handlers.CORS(CORSHeaders, CORSOrigins, CORSMethods)(handler)
+	CORSHeaders := handlers.AllowedHeaders([]string{"Authorization", "Content-Type", "User-Agent", "X-Request-ID"})
+	CORSExposedHeaders := handlers.ExposedHeaders([]string{"X-Request-ID"})
+	CORSOrigins := handlers.AllowedOrigins(s.config.CORSOrigins)
+	CORSMethods := handlers.AllowedMethods([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete})
+	// Probes and metrics are routed before the router so they are neither rate limited
+	// nor logged.
+	root := http.NewServeMux()
+	root.Handle("/healthz", health.LivenessHandler())
+	root.Handle("/readyz", health.ReadinessHandler(registry))
+	if s.config.MetricsPort == 0 {
+		root.Handle("/metrics", service.RequireAdminKey(s.config.AdminKey)(metricsRegistry.Handler()))
+	}
+	root.Handle("/", resolver.Middleware(service.AssignRequestID(s.logger)(router)))
+
+	var handler http.Handler = root
+	handler = handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(handler)
+	handler = handlers.CORS(CORSHeaders, CORSExposedHeaders, CORSOrigins, CORSMethods)(handler)
 	handler = handlers.CompressHandler(handler)
 	return handler
 }
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"untitled_rpg/domain"
+	"untitled_rpg/ratelimit"
 	"untitled_rpg/store"
+	"untitled_rpg/token"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/gorilla/mux"
@@ -12,52 +14,222 @@ import (
 
 // AccountService is a collection of account related http handlers.
 type AccountService struct {
-	store *store.AccountStore // store is the account store used to access and save account data.
+	transactor        store.Transactor             // transactor runs a password change within a transaction, revoking the other sessions.
+	store             store.AccountRepository      // store is the account store used to access and save account data.
+	refreshTokenStore store.RefreshTokenRepository // refreshTokenStore is used to check that the sessions of auth tokens are active.
+	tokenProvider     *token.Provider              // tokenProvider is used to verify the auth token of requests to protected routes.
+	passwordPolicy    *domain.PasswordPolicy       // passwordPolicy is the policy new passwords are validated against.
+	verification      *VerificationService         // verification is used to send verification emails to new email addresses.
+}
+
+// updateAccountRequest is the request body of an account update. The current password
+// is required to change either the email or the password.
+type updateAccountRequest struct {
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	CurrentPassword string `json:"currentPassword"`
 }
 
 // NewAccountService initializes and returns a new account service.
-func NewAccountService(store *store.AccountStore) *AccountService {
+func NewAccountService(transactor store.Transactor, store store.AccountRepository, refreshTokenStore store.RefreshTokenRepository, tokenProvider *token.Provider, passwordPolicy *domain.PasswordPolicy, verification *VerificationService) *AccountService {
 	return &AccountService{
-		store: store,
+		transactor:        transactor,
+		store:             store,
+		refreshTokenStore: refreshTokenStore,
+		tokenProvider:     tokenProvider,
+		passwordPolicy:    passwordPolicy,
+		verification:      verification,
 	}
 }
 
 // Register registers all service routes with the provided router.
 func (s *AccountService) Register(router *mux.Router) {
-	router.HandleFunc("/accounts", s.createAccount).Methods(http.MethodPost)
+	router.Handle("/accounts", limited("create_account", ratelimit.PerHour(20), s.createAccount)).Methods(http.MethodPost)
+
+	me := router.Path("/accounts/me").Subrouter()
+	me.Use(RequireAuth(s.tokenProvider, s.store, s.refreshTokenStore))
+	me.HandleFunc("", s.getAccount).Methods(http.MethodGet)
+	me.Handle("", limitedByAccount("update_account", ratelimit.PerMinute(10), s.updateAccount)).Methods(http.MethodPatch)
+	me.HandleFunc("", s.deleteAccount).Methods(http.MethodDelete)
 }
 
-// createAccount is an http handler that creates a new account.
+// createAccount is an http handler that creates a new account and sends a
+// verification email to its email address.
 func (s *AccountService) createAccount(w http.ResponseWriter, r *http.Request) {
 	var account domain.Account
 
 	defer r.Body.Close()
 	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
-		respondErr(w, newBadRequestError("Invalid request body"))
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
 		return
 	}
 
 	_, err := govalidator.ValidateStruct(account)
 	if err != nil {
-		respondErr(w, newValidationError(err))
+		respondErr(w, r, newValidationError(err))
 		return
 	}
 
 	if err := account.NormalizeEmail(); err != nil {
-		respondErr(w, newInternalServerError(err))
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	if err := account.ValidatePassword(s.passwordPolicy); err != nil {
+		respondErr(w, r, newValidationError(err))
 		return
 	}
 
 	if err := account.HashPassword(); err != nil {
-		respondErr(w, newInternalServerError(err))
+		respondErr(w, r, newInternalServerError(err))
 		return
 	}
 
-	if err := s.store.CreateAccount(account); err != nil {
+	account, err = s.store.CreateAccount(r.Context(), account)
+	if err != nil {
 		if err == store.ErrAccountExists {
-			respondErr(w, newConflictError(err.Error()))
+			respondErr(w, r, newConflictError(CodeAccountExists, err.Error()))
 		} else {
-			respondErr(w, newInternalServerError(err))
+			respondErr(w, r, newInternalServerError(err))
 		}
+		return
+	}
+
+	// The account is usable even if the email could not be sent, since a new
+	// verification email can be requested at any time.
+	s.verification.queueVerification(r.Context(), account)
+}
+
+// getAccount is an http handler that returns the authenticated account.
+func (s *AccountService) getAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := currentAccount(s.store, w, r)
+	if !ok {
+		return
+	}
+
+	respond(w, r, http.StatusOK, account)
+}
+
+// updateAccount is an http handler that changes the email and/or password of the
+// authenticated account after checking the current password. Changing the password
+// revokes every other session of the account along with every auth token issued to the
+// account; the current session is kept, and its refresh token is used to get a new auth token.
+func (s *AccountService) updateAccount(w http.ResponseWriter, r *http.Request) {
+	var req updateAccountRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	if req.Email == "" && req.Password == "" {
+		respondErr(w, r, newBadRequestError(CodeNothingToUpdate, "Nothing to update"))
+		return
+	}
+
+	account, ok := currentAccount(s.store, w, r)
+	if !ok {
+		return
 	}
+
+	if match := account.CheckPassword(req.CurrentPassword); !match {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	changes := domain.Account{Email: req.Email, Password: req.Password}
+	if _, err := govalidator.ValidateStruct(changes); err != nil {
+		respondErr(w, r, newValidationError(err))
+		return
+	}
+
+	if changes.Email != "" {
+		if err := changes.NormalizeEmail(); err != nil {
+			respondErr(w, r, newInternalServerError(err))
+			return
+		}
+		account.Email = changes.Email
+	}
+
+	if changes.Password != "" {
+		changes.Email = account.Email
+		if err := changes.ValidatePassword(s.passwordPolicy); err != nil {
+			respondErr(w, r, newValidationError(err))
+			return
+		}
+		if err := changes.HashPassword(); err != nil {
+			respondErr(w, r, newInternalServerError(err))
+			return
+		}
+		account.Password = changes.Password
+	}
+
+	err := s.transactor.WithTx(r.Context(), func(tx store.Tx) error {
+		updated, err := tx.Accounts().UpdateAccount(r.Context(), account)
+		if err != nil {
+			return err
+		}
+		account = updated
+
+		if changes.Password == "" {
+			return nil
+		}
+		if err := tx.Accounts().RevokeSessions(r.Context(), account.ID); err != nil {
+			return err
+		}
+		sessionID, _ := SessionID(r.Context())
+		return tx.RefreshTokens().RevokeOtherRefreshTokens(r.Context(), account.ID, sessionID)
+	})
+	if err != nil {
+		switch err {
+		case store.ErrAccountExists:
+			respondErr(w, r, newConflictError(CodeAccountExists, err.Error()))
+		case store.ErrAccountNotFound:
+			respondErr(w, r, newNotFoundError(CodeAccountNotFound, err.Error()))
+		default:
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	if changes.Email != "" && !account.IsEmailVerified() {
+		s.verification.queueVerification(r.Context(), account)
+	}
+
+	respond(w, r, http.StatusOK, account)
+}
+
+// deleteAccount is an http handler that deletes the authenticated account.
+func (s *AccountService) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	id, _ := AccountID(r.Context())
+
+	if err := s.store.DeleteAccount(r.Context(), id); err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newNotFoundError(CodeAccountNotFound, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentAccount retrieves the authenticated account from the account store. If the
+// account cannot be retrieved, an error is written to the response and false is returned.
+func currentAccount(accountStore store.AccountRepository, w http.ResponseWriter, r *http.Request) (domain.Account, bool) {
+	id, _ := AccountID(r.Context())
+
+	account, err := accountStore.GetAccountByID(r.Context(), id)
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newNotFoundError(CodeAccountNotFound, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return account, false
+	}
+
+	return account, true
 }
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultSearchLimit is the number of accounts returned by a search without a limit.
+	defaultSearchLimit = 50
+	// maxSearchLimit is the maximum number of accounts returned by a search.
+	maxSearchLimit = 200
+)
+
+// AdminService is a collection of admin http handlers used by support staff to find and
+// moderate accounts. Every change is recorded in the audit log along with the staff
+// account that made it, so the routes require an auth token and do not accept the admin key.
+type AdminService struct {
+	transactor        store.Transactor             // transactor runs each action within a transaction along with its audit event.
+	store             store.AccountRepository      // store is the account store used to search and restrict accounts.
+	refreshTokenStore store.RefreshTokenRepository // refreshTokenStore is used to check that the sessions of auth tokens are active.
+	auditEventStore   store.AuditEventRepository   // auditEventStore is used to read the audit log of accounts.
+	tokenProvider     *token.Provider              // tokenProvider verifies the auth tokens of staff accounts and issues impersonation tokens.
+	passwords         *PasswordService             // passwords is used to email a password reset link to accounts that must choose a new password.
+	impersonationTTL  time.Duration                // impersonationTTL is how long impersonation tokens remain valid.
+}
+
+// accountSearchResponse is the response body of an account search. NextCursor is set if
+// more accounts may match, and continues the search when passed as the cursor parameter.
+type accountSearchResponse struct {
+	Accounts   []domain.Account `json:"accounts"`
+	NextCursor uint64           `json:"nextCursor,omitempty"`
+}
+
+// moderationRequest is the request body of an action on an account. Until is only used
+// by suspensions, which require it.
+type moderationRequest struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+// impersonationResponse is the response body returned when an impersonation token is issued.
+type impersonationResponse struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresIn   int64  `json:"expiresIn"`
+}
+
+// NewAdminService initializes and returns a new admin service.
+func NewAdminService(transactor store.Transactor, store store.AccountRepository, refreshTokenStore store.RefreshTokenRepository, auditEventStore store.AuditEventRepository, tokenProvider *token.Provider, passwords *PasswordService, impersonationTTL time.Duration) *AdminService {
+	return &AdminService{
+		transactor:        transactor,
+		store:             store,
+		refreshTokenStore: refreshTokenStore,
+		auditEventStore:   auditEventStore,
+		tokenProvider:     tokenProvider,
+		passwords:         passwords,
+		impersonationTTL:  impersonationTTL,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *AdminService) Register(router *mux.Router) {
+	admin := router.PathPrefix("/admin/accounts").Subrouter()
+	admin.Use(RequireAuth(s.tokenProvider, s.store, s.refreshTokenStore))
+	admin.Handle("", withPermission(domain.PermissionViewAccounts, s.searchAccounts)).Methods(http.MethodGet)
+	admin.Handle("/{id:[0-9]+}", withPermission(domain.PermissionViewAccounts, s.getAccount)).Methods(http.MethodGet)
+	admin.Handle("/{id:[0-9]+}/audit", withPermission(domain.PermissionViewAccounts, s.listAuditEvents)).Methods(http.MethodGet)
+	admin.Handle("/{id:[0-9]+}/suspend", withPermission(domain.PermissionModerateAccounts, s.suspend)).Methods(http.MethodPost)
+	admin.Handle("/{id:[0-9]+}/ban", withPermission(domain.PermissionModerateAccounts, s.ban)).Methods(http.MethodPost)
+	admin.Handle("/{id:[0-9]+}/reinstate", withPermission(domain.PermissionModerateAccounts, s.reinstate)).Methods(http.MethodPost)
+	admin.Handle("/{id:[0-9]+}/require-password-reset", withPermission(domain.PermissionSecureAccounts, s.requirePasswordReset)).Methods(http.MethodPost)
+	admin.Handle("/{id:[0-9]+}/revoke-sessions", withPermission(domain.PermissionSecureAccounts, s.revokeSessions)).Methods(http.MethodPost)
+	admin.Handle("/{id:[0-9]+}/impersonate", withPermission(domain.PermissionImpersonate, s.impersonate)).Methods(http.MethodPost)
+}
+
+// withPermission wraps an http handler with RequirePermission.
+func withPermission(permission domain.Permission, handler http.HandlerFunc) http.Handler {
+	return RequirePermission(permission)(handler)
+}
+
+// searchAccounts is an http handler that returns the accounts matching the email, id,
+// createdAfter and createdBefore query parameters, ordered by id. Emails match if they
+// contain the parameter and dates use the RFC 3339 format. Results are paginated with
+// the limit and cursor parameters.
+func (s *AdminService) searchAccounts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.AccountFilter{Email: query.Get("email"), Limit: defaultSearchLimit}
+
+	for name, dest := range map[string]*uint64{"id": &filter.ID, "cursor": &filter.AfterID} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				respondErr(w, r, newBadRequestError(CodeBadRequest, "Invalid "+name+" parameter"))
+				return
+			}
+			*dest = n
+		}
+	}
+	for name, dest := range map[string]**time.Time{"createdAfter": &filter.CreatedAfter, "createdBefore": &filter.CreatedBefore} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondErr(w, r, newBadRequestError(CodeBadRequest, "Invalid "+name+" parameter"))
+				return
+			}
+			*dest = &t
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			respondErr(w, r, newBadRequestError(CodeBadRequest, "Invalid limit parameter"))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	accounts, err := s.store.SearchAccounts(r.Context(), filter)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	res := accountSearchResponse{Accounts: accounts}
+	if len(accounts) == filter.Limit {
+		res.NextCursor = accounts[len(accounts)-1].ID
+	}
+	respond(w, r, http.StatusOK, res)
+}
+
+// getAccount is an http handler that returns an account by id.
+func (s *AdminService) getAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	respond(w, r, http.StatusOK, account)
+}
+
+// listAuditEvents is an http handler that returns the audit log of an account, most
+// recent first. The audit log is kept after the account is deleted.
+func (s *AdminService) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+
+	events, err := s.auditEventStore.ListAuditEvents(r.Context(), id)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	respond(w, r, http.StatusOK, events)
+}
+
+// suspend is an http handler that suspends an account until the provided time and ends
+// its sessions. A new suspension replaces the previous one.
+func (s *AdminService) suspend(w http.ResponseWriter, r *http.Request) {
+	_, ok := s.moderate(w, r, domain.AuditSuspend, func(tx store.Tx, account domain.Account, req moderationRequest) error {
+		if err := tx.Accounts().SuspendAccount(r.Context(), account.ID, *req.Until, req.Reason); err != nil {
+			return err
+		}
+		return endSessions(r, tx, account.ID)
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ban is an http handler that permanently bans an account and ends its sessions.
+func (s *AdminService) ban(w http.ResponseWriter, r *http.Request) {
+	_, ok := s.moderate(w, r, domain.AuditBan, func(tx store.Tx, account domain.Account, req moderationRequest) error {
+		if err := tx.Accounts().BanAccount(r.Context(), account.ID, req.Reason); err != nil {
+			return err
+		}
+		return endSessions(r, tx, account.ID)
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reinstate is an http handler that lifts the suspension and the ban of an account.
+func (s *AdminService) reinstate(w http.ResponseWriter, r *http.Request) {
+	_, ok := s.moderate(w, r, domain.AuditLiftRestrictions, func(tx store.Tx, account domain.Account, req moderationRequest) error {
+		return tx.Accounts().LiftRestrictions(r.Context(), account.ID)
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requirePasswordReset is an http handler that ends the sessions of an account and
+// prevents it from logging in until it chooses a new password, using the password reset
+// link emailed to it. Requiring a reset again sends a new link.
+func (s *AdminService) requirePasswordReset(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.moderate(w, r, domain.AuditRequirePasswordReset, func(tx store.Tx, account domain.Account, req moderationRequest) error {
+		if err := tx.Accounts().RequirePasswordReset(r.Context(), account.ID); err != nil {
+			return err
+		}
+		return endSessions(r, tx, account.ID)
+	})
+	if !ok {
+		return
+	}
+
+	if err := s.passwords.sendRequiredReset(r.Context(), account); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSessions is an http handler that ends every session of an account, including
+// its auth tokens that have not expired yet.
+func (s *AdminService) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	_, ok := s.moderate(w, r, domain.AuditRevokeSessions, func(tx store.Tx, account domain.Account, req moderationRequest) error {
+		return endSessions(r, tx, account.ID)
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// impersonate is an http handler that issues a short-lived, read-only auth token acting
+// as a player account, so that staff can see what the player sees while investigating
+// an issue. Every request made with the token is logged with the impersonator.
+func (s *AdminService) impersonate(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.moderate(w, r, domain.AuditImpersonate, nil)
+	if !ok {
+		return
+	}
+
+	actorID, _ := AccountID(r.Context())
+	accessToken, err := s.tokenProvider.IssueImpersonationToken(account, actorID, s.impersonationTTL)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	respond(w, r, http.StatusOK, impersonationResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(s.impersonationTTL.Seconds()),
+	})
+}
+
+// moderate decodes and validates a moderation request, then applies the action to the
+// account of the request path, if apply is not nil, and records it in the audit log
+// within a transaction. Staff accounts can only be acted upon by admins, nobody can act
+// upon their own account, and only player accounts can be impersonated.
+//
+// If the action fails, an error is written to the response and false is returned;
+// otherwise the caller replies to the request.
+func (s *AdminService) moderate(w http.ResponseWriter, r *http.Request, action domain.AuditAction, apply func(tx store.Tx, account domain.Account, req moderationRequest) error) (domain.Account, bool) {
+	var req moderationRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return domain.Account{}, false
+	}
+	if err := req.validate(action); err != nil {
+		respondErr(w, r, newValidationError(err))
+		return domain.Account{}, false
+	}
+
+	account, ok := s.loadAccount(w, r)
+	if !ok {
+		return domain.Account{}, false
+	}
+
+	actorID, _ := AccountID(r.Context())
+	actorRole, _ := AccountRole(r.Context())
+	switch {
+	case action == domain.AuditImpersonate && account.Role != domain.RolePlayer:
+		respondErr(w, r, newForbiddenError(CodeImpersonationNotAllowed, "Only player accounts can be impersonated"))
+		return domain.Account{}, false
+	case account.ID == actorID, account.Role != domain.RolePlayer && actorRole != domain.RoleAdmin:
+		respondErr(w, r, newForbiddenError(CodePermissionDenied, "Permission denied"))
+		return domain.Account{}, false
+	}
+
+	requestID, _ := RequestID(r.Context())
+	err := s.transactor.WithTx(r.Context(), func(tx store.Tx) error {
+		if apply != nil {
+			if err := apply(tx, account, req); err != nil {
+				return err
+			}
+		}
+		_, err := tx.AuditEvents().RecordAuditEvent(r.Context(), domain.AuditEvent{
+			ActorID:   actorID,
+			AccountID: account.ID,
+			Action:    action,
+			Reason:    req.Reason,
+			RequestID: requestID,
+		})
+		return err
+	})
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newNotFoundError(CodeAccountNotFound, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return domain.Account{}, false
+	}
+
+	logger.FromContext(r.Context()).Info().
+		Str("action", string(action)).
+		Uint("targetAccountId", uint(account.ID)).
+		Str("reason", req.Reason).
+		Msg("Account moderated")
+
+	return account, true
+}
+
+// validate checks that the request explains the action, and that suspensions end in
+// the future. If not, a govalidator.Errors containing one error per invalid field is returned.
+func (req moderationRequest) validate(action domain.AuditAction) error {
+	var errs govalidator.Errors
+
+	invalid := func(name string, validator string, format string) {
+		errs = append(errs, govalidator.Error{Name: name, Err: &domain.RuleError{Format: format}, Validator: validator})
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		invalid("reason", "required", "is required")
+	}
+	if action == domain.AuditSuspend {
+		if req.Until == nil {
+			invalid("until", "required", "is required")
+		} else if !req.Until.After(time.Now()) {
+			invalid("until", "future", "must be in the future")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// loadAccount retrieves the account of the request path. If it does not exist, an error
+// is written to the response and false is returned.
+func (s *AdminService) loadAccount(w http.ResponseWriter, r *http.Request) (domain.Account, bool) {
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newNotFoundError(CodeAccountNotFound, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return domain.Account{}, false
+	}
+	return account, true
+}
+
+// endSessions revokes the auth tokens and refresh tokens of an account within a transaction.
+func endSessions(r *http.Request, tx store.Tx, accountID uint64) error {
+	if err := tx.Accounts().RevokeSessions(r.Context(), accountID); err != nil {
+		return err
+	}
+	return tx.RefreshTokens().RevokeAccountRefreshTokens(r.Context(), accountID)
+}
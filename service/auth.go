@@ -1,9 +1,14 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
+	"untitled_rpg/clientip"
 	"untitled_rpg/domain"
+	"untitled_rpg/metrics"
+	"untitled_rpg/ratelimit"
 	"untitled_rpg/store"
 	"untitled_rpg/token"
 
@@ -12,61 +17,387 @@ import (
 
 // AuthService is a collection of authentication related http handlers.
 type AuthService struct {
-	store         *store.AccountStore // store is the account store used to access and save account data.
-	tokenProvider *token.Provider     // tokenProvider is used to generate a new auth token following a successful login.
+	store             store.AccountRepository      // store is the account store used to access and save account data.
+	refreshTokenStore store.RefreshTokenRepository // refreshTokenStore is used to save, rotate and revoke refresh tokens.
+	tokenProvider     *token.Provider              // tokenProvider is used to generate a new auth token following a successful login.
+	mfa               *MFAService                  // mfa is used to check the second factor of accounts with two-factor authentication enabled.
+	guard             *LoginGuard                  // guard locks out clients and accounts after repeated failed login attempts.
+	refreshTokenTTL   time.Duration                // refreshTokenTTL is how long issued refresh tokens remain valid.
+	mfaChallengeTTL   time.Duration                // mfaChallengeTTL is how long a login can wait for its second factor.
+	requireVerified   bool                         // requireVerified indicates whether accounts must verify their email before logging in.
+	logins            metrics.Counter              // logins counts login attempts by result.
 }
 
-// NewAuthService initializes and returns a new auth service.
-func NewAuthService(store *store.AccountStore, tokenProvider *token.Provider) *AuthService {
+// tokenResponse is the response body returned following a successful login or token refresh.
+type tokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// mfaChallengeResponse is the response body returned following a successful password
+// check for an account with two-factor authentication enabled.
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+	ExpiresIn   int64  `json:"expiresIn"`
+}
+
+// mfaLoginRequest is the request body of the second step of a two-factor login.
+type mfaLoginRequest struct {
+	mfaCodeRequest
+	MFAToken string `json:"mfaToken"`
+}
+
+// refreshRequest is the request body of a token refresh.
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Login results counted by the logins metric.
+const (
+	loginSuccess = "success" // loginSuccess is counted when a login completes.
+	loginFailure = "failure" // loginFailure is counted when a password or second factor is wrong.
+	loginLocked  = "locked"  // loginLocked is counted when a login is refused because of a lockout.
+)
+
+// NewAuthService initializes and returns a new auth service. The login counter is
+// registered with the provided metrics registry.
+func NewAuthService(store store.AccountRepository, refreshTokenStore store.RefreshTokenRepository, tokenProvider *token.Provider, mfa *MFAService, guard *LoginGuard, registry metrics.Registry, refreshTokenTTL time.Duration, mfaChallengeTTL time.Duration, requireVerified bool) *AuthService {
 	return &AuthService{
-		store:         store,
-		tokenProvider: tokenProvider,
+		store:             store,
+		refreshTokenStore: refreshTokenStore,
+		tokenProvider:     tokenProvider,
+		mfa:               mfa,
+		guard:             guard,
+		refreshTokenTTL:   refreshTokenTTL,
+		mfaChallengeTTL:   mfaChallengeTTL,
+		requireVerified:   requireVerified,
+		logins:            registry.Counter("logins_total", "Number of login attempts by result.", "result"),
 	}
 }
 
 // Register registers all service routes with the provided router.
 func (s *AuthService) Register(router *mux.Router) {
-	router.HandleFunc("/authenticate", s.authenticate).Methods(http.MethodPost)
+	router.Handle("/authenticate", limited("authenticate", ratelimit.PerMinute(10), s.authenticate)).Methods(http.MethodPost)
+	router.Handle("/authenticate/mfa", limited("authenticate_mfa", ratelimit.PerMinute(10), s.authenticateMFA)).Methods(http.MethodPost)
+	router.Handle("/authenticate/refresh", limited("authenticate_refresh", ratelimit.PerMinute(30), s.refresh)).Methods(http.MethodPost)
+	router.HandleFunc("/.well-known/jwks.json", s.jwks).Methods(http.MethodGet)
+	router.Handle("/logout", RequireAuth(s.tokenProvider, s.store, s.refreshTokenStore)(http.HandlerFunc(s.logout))).Methods(http.MethodPost)
 }
 
 // authenticate is an http handler that validates an email and password combination and
-// returns an auth token that can be used to interact with the server.
+// returns an auth token that can be used to interact with the server, along with a
+// refresh token that can be used to obtain a new auth token once it expires. For accounts
+// with two-factor authentication enabled, a short-lived MFA token is returned instead,
+// which must be exchanged along with a second factor using authenticateMFA.
+//
+// Unknown accounts and wrong passwords receive the same response after the same amount
+// of work, and repeated failures lock out the client and the account for a while.
 func (s *AuthService) authenticate(w http.ResponseWriter, r *http.Request) {
 	var checkAccount domain.Account
 
 	defer r.Body.Close()
 	if err := json.NewDecoder(r.Body).Decode(&checkAccount); err != nil {
-		respondErr(w, newBadRequestError("Invalid request body"))
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
 		return
 	}
 
 	if err := checkAccount.NormalizeEmail(); err != nil {
-		respondErr(w, newInternalServerError(err))
+		respondErr(w, r, newUnauthorizedError())
 		return
 	}
 
-	account, err := s.store.GetAccount(checkAccount.Email)
+	ip := clientip.FromRequest(r)
+	if !s.checkLockout(w, r, ip, checkAccount.Email) {
+		return
+	}
+
+	account, err := s.store.GetAccount(r.Context(), checkAccount.Email)
 	if err != nil {
 		if err == store.ErrAccountNotFound {
-			respondErr(w, newNotFoundError(err.Error()))
+			domain.CheckDummyPassword(checkAccount.Password)
+			s.fail(w, r, ip, checkAccount.Email)
 		} else {
-			respondErr(w, newInternalServerError(err))
+			respondErr(w, r, newInternalServerError(err))
 		}
 		return
 	}
 
 	if match := account.CheckPassword(checkAccount.Password); !match {
-		respondErr(w, newUnauthorizedError())
+		s.fail(w, r, ip, account.Email)
+		return
+	}
+
+	if s.requireVerified && !account.IsEmailVerified() {
+		respondErr(w, r, newForbiddenError(CodeEmailNotVerified, "Email address not verified"))
+		return
+	}
+
+	if !s.checkAccess(w, r, account) {
+		return
+	}
+
+	if account.IsTOTPEnabled() {
+		mfaToken, err := s.tokenProvider.IssuePurposeToken(token.PurposeMFA, account.ID, "", s.mfaChallengeTTL)
+		if err != nil {
+			respondErr(w, r, newInternalServerError(err))
+			return
+		}
+
+		respond(w, r, http.StatusOK, mfaChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(s.mfaChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	s.login(w, r, account)
+}
+
+// authenticateMFA is an http handler that completes a two-factor login by exchanging
+// the MFA token returned by authenticate, along with a TOTP code or recovery code, for
+// an auth token and refresh token.
+func (s *AuthService) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaLoginRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	claims, err := s.tokenProvider.VerifyPurposeToken(req.MFAToken, token.PurposeMFA)
+	if err != nil {
+		respondErr(w, r, newUnauthorizedError())
 		return
 	}
 
-	token, err := s.tokenProvider.IssueToken(account)
+	account, err := s.store.GetAccountByID(r.Context(), claims.AccountID)
 	if err != nil {
-		respondErr(w, newUnauthorizedError())
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newUnauthorizedError())
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	if !account.IsTOTPEnabled() {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	ip := clientip.FromRequest(r)
+	if !s.checkLockout(w, r, ip, account.Email) {
 		return
 	}
 
-	if _, err := w.Write([]byte(token)); err != nil {
-		respondErr(w, newInternalServerError(err))
+	if ok, err := s.mfa.verifyCode(r.Context(), account, req.mfaCodeRequest); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	} else if !ok {
+		s.fail(w, r, ip, account.Email)
+		return
+	}
+
+	if !s.checkAccess(w, r, account) {
+		return
+	}
+
+	s.login(w, r, account)
+}
+
+// checkLockout checks whether the client ip address or the account email address is
+// locked out. If so, an error is written to the response and false is returned.
+func (s *AuthService) checkLockout(w http.ResponseWriter, r *http.Request, ip string, email string) bool {
+	wait, err := s.guard.lockedFor(r.Context(), ip, email)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return false
+	}
+	if wait > 0 {
+		s.logins.Inc(loginLocked)
+		respondErr(w, r, newTooManyRequestsError(wait))
+		return false
+	}
+	return true
+}
+
+// checkAccess checks whether the account is banned, suspended or must reset its password.
+// If so, an error is written to the response and false is returned. Restrictions are
+// only revealed once the password has been checked.
+func (s *AuthService) checkAccess(w http.ResponseWriter, r *http.Request, account domain.Account) bool {
+	if err := account.CheckAccess(time.Now()); err != nil {
+		respondErr(w, r, newAccessError(err))
+		return false
+	}
+	return true
+}
+
+// fail records a failed login attempt and replies to the request with an unauthorized error.
+func (s *AuthService) fail(w http.ResponseWriter, r *http.Request, ip string, email string) {
+	s.logins.Inc(loginFailure)
+	if err := s.guard.fail(r.Context(), ip, email); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+	respondErr(w, r, newUnauthorizedError())
+}
+
+// login starts a new session for the account and replies to the request with a new
+// auth token and refresh token.
+func (s *AuthService) login(w http.ResponseWriter, r *http.Request, account domain.Account) {
+	if err := s.guard.succeed(r.Context(), account.Email); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	sessionID, err := token.NewSessionID()
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
 	}
+
+	tokens, err := s.issueTokens(r.Context(), account, sessionID)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	s.logins.Inc(loginSuccess)
+	respond(w, r, http.StatusOK, tokens)
+}
+
+// refresh is an http handler that exchanges a refresh token for a new auth token and
+// refresh token. Each refresh token can only be used once; presenting a refresh token
+// that was already used revokes every token in its family, ending the session.
+func (s *AuthService) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	refreshToken, err := s.refreshTokenStore.GetRefreshToken(r.Context(), token.HashToken(req.RefreshToken))
+	if err != nil {
+		if err == store.ErrRefreshTokenNotFound {
+			respondErr(w, r, newUnauthorizedError())
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	if refreshToken.IsRevoked() {
+		s.revokeFamily(w, r, refreshToken.FamilyID)
+		return
+	}
+
+	if refreshToken.IsExpired() {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	// The account is loaded so that the new auth token carries its current role.
+	account, err := s.store.GetAccountByID(r.Context(), refreshToken.AccountID)
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newUnauthorizedError())
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	if !s.checkAccess(w, r, account) {
+		return
+	}
+
+	// The new refresh token is created before the presented one is revoked, so the session
+	// stays active for auth tokens throughout the rotation. Concurrent requests presenting
+	// the same token cannot both succeed: the one that fails to revoke it revokes the whole
+	// family, including the token just created.
+	tokens, err := s.issueTokens(r.Context(), account, refreshToken.FamilyID)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	revoked, err := s.refreshTokenStore.RevokeRefreshToken(r.Context(), refreshToken.ID)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+	if !revoked {
+		s.revokeFamily(w, r, refreshToken.FamilyID)
+		return
+	}
+
+	respond(w, r, http.StatusOK, tokens)
+}
+
+// logout is an http handler that revokes the session of the authenticated caller.
+func (s *AuthService) logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := SessionID(r.Context())
+	if !ok || sessionID == "" {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	if err := s.refreshTokenStore.RevokeRefreshTokenFamily(r.Context(), sessionID); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwks is an http handler that returns the public keys used to verify auth tokens,
+// allowing other services to verify tokens without sharing a secret.
+func (s *AuthService) jwks(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, s.tokenProvider.JWKS())
+}
+
+// revokeFamily revokes a refresh token family after a refresh token was reused and
+// replies to the request with an unauthorized error.
+func (s *AuthService) revokeFamily(w http.ResponseWriter, r *http.Request, familyID string) {
+	if err := s.refreshTokenStore.RevokeRefreshTokenFamily(r.Context(), familyID); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+	respondErr(w, r, newUnauthorizedError())
+}
+
+// issueTokens issues a new auth token and refresh token for the account within the
+// provided session.
+func (s *AuthService) issueTokens(ctx context.Context, account domain.Account, sessionID string) (tokenResponse, error) {
+	accessToken, err := s.tokenProvider.IssueToken(account, sessionID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refreshToken, err := token.NewRefreshToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	err = s.refreshTokenStore.CreateRefreshToken(ctx, domain.RefreshToken{
+		AccountID: account.ID,
+		FamilyID:  sessionID,
+		TokenHash: token.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokenProvider.TTL().Seconds()),
+	}, nil
 }
@@ -0,0 +1,251 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/metrics"
+	"untitled_rpg/service"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+// accountLockout is the account lockout policy of test servers.
+var accountLockout = domain.LockoutPolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+// testServer serves the auth and MFA routes backed by a memory store.
+type testServer struct {
+	store    *store.MemoryStore
+	provider *token.Provider
+	router   *mux.Router
+}
+
+// tokens is the response body of a successful login or token refresh.
+type tokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	MFARequired  bool   `json:"mfaRequired"`
+	MFAToken     string `json:"mfaToken"`
+}
+
+// newTestServer returns a test server. Accounts are locked out following
+// accountLockout, while client ip addresses are never locked out.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if err := domain.SetBcryptCost(bcrypt.MinCost); err != nil {
+		t.Fatalf("SetBcryptCost() error = %v", err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key, err := token.ParseKey("rsa", "RS256", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+	if err != nil {
+		t.Fatalf("ParseKey() error = %v", err)
+	}
+	keys, err := token.NewKeySet("rsa", key)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	s := &testServer{
+		store:    store.NewMemoryStore(),
+		provider: token.NewProvider(keys, "untitled_rpg", "players", time.Minute),
+		router:   mux.NewRouter(),
+	}
+	ipLockout := domain.LockoutPolicy{Threshold: 1000, BaseDelay: time.Second, MaxDelay: time.Second}
+	mfa := service.NewMFAService(s.store, s.store, s.store, s.store, s.provider, "Untitled RPG")
+	guard := service.NewLoginGuard(s.store, accountLockout, ipLockout)
+	auth := service.NewAuthService(s.store, s.store, s.provider, mfa, guard, metrics.NewPrometheusRegistry("test"), time.Hour, time.Minute, false)
+	auth.Register(s.router)
+	mfa.Register(s.router)
+	return s
+}
+
+// createAccount creates an account with testPassword.
+func (s *testServer) createAccount(t *testing.T, email string) domain.Account {
+	t.Helper()
+	account := domain.Account{Email: email, Password: testPassword}
+	if err := account.HashPassword(); err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	account, err := s.store.CreateAccount(context.Background(), account)
+	if err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	return account
+}
+
+// do sends a request with a json body and returns the response. If accessToken is
+// not empty, it is sent as a bearer token.
+func (s *testServer) do(t *testing.T, method string, path string, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	r := httptest.NewRequest(method, path, &b)
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// login authenticates with an email and password and returns the response.
+func (s *testServer) login(t *testing.T, email string, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	return s.do(t, http.MethodPost, "/authenticate", "", map[string]string{"email": email, "password": password})
+}
+
+// decodeTokens decodes the tokens of a successful response.
+func decodeTokens(t *testing.T, w *httptest.ResponseRecorder) tokens {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var got tokens
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	return got
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t)
+	s.createAccount(t, "player@example.com")
+
+	issued := []tokens{decodeTokens(t, s.login(t, "player@example.com", testPassword))}
+
+	// Each step presents the refresh token issued by a previous step. Reusing a rotated
+	// refresh token revokes the whole family, including the latest refresh token.
+	tests := []struct {
+		name       string
+		present    int
+		wantStatus int
+	}{
+		{"refresh token issued at login", 0, http.StatusOK},
+		{"rotated refresh token", 1, http.StatusOK},
+		{"reused refresh token", 0, http.StatusUnauthorized},
+		{"latest refresh token after reuse", 2, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := s.do(t, http.MethodPost, "/authenticate/refresh", "", map[string]string{"refreshToken": issued[tt.present].RefreshToken})
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if w.Code == http.StatusOK {
+			issued = append(issued, decodeTokens(t, w))
+		}
+	}
+
+	first, err := s.provider.VerifyToken(issued[0].AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+	last, err := s.provider.VerifyToken(issued[2].AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+	if first.SessionID == "" || first.SessionID != last.SessionID {
+		t.Errorf("refreshed session id = %q, want the session id of the login %q", last.SessionID, first.SessionID)
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	s := newTestServer(t)
+	account := s.createAccount(t, "player@example.com")
+
+	expired, err := token.NewRefreshToken()
+	if err != nil {
+		t.Fatalf("NewRefreshToken() error = %v", err)
+	}
+	err = s.store.CreateRefreshToken(context.Background(), domain.RefreshToken{
+		AccountID: account.ID,
+		FamilyID:  "expired",
+		TokenHash: token.HashToken(expired),
+		ExpiresAt: time.Now().Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       interface{}
+		wantStatus int
+	}{
+		{"missing refresh token", map[string]string{}, http.StatusBadRequest},
+		{"invalid body", "refresh", http.StatusBadRequest},
+		{"unknown refresh token", map[string]string{"refreshToken": "unknown"}, http.StatusUnauthorized},
+		{"expired refresh token", map[string]string{"refreshToken": expired}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, http.MethodPost, "/authenticate/refresh", "", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	s := newTestServer(t)
+	s.createAccount(t, "player@example.com")
+
+	session := decodeTokens(t, s.login(t, "player@example.com", testPassword))
+	other := decodeTokens(t, s.login(t, "player@example.com", testPassword))
+
+	if w := s.do(t, http.MethodPost, "/logout", session.AccessToken, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("logout status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	w := s.do(t, http.MethodPost, "/authenticate/refresh", "", map[string]string{"refreshToken": session.RefreshToken})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh of the ended session status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = s.do(t, http.MethodPost, "/authenticate/refresh", "", map[string]string{"refreshToken": other.RefreshToken})
+	if w.Code != http.StatusOK {
+		t.Errorf("refresh of another session status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	refreshed := decodeTokens(t, w)
+
+	// Auth tokens are rejected as soon as their session ends, even though they have not
+	// expired yet, while the auth tokens of other sessions keep working.
+	tests := []struct {
+		name        string
+		accessToken string
+		wantStatus  int
+	}{
+		{"auth token of the ended session", session.AccessToken, http.StatusUnauthorized},
+		{"auth token of another session", other.AccessToken, http.StatusOK},
+		{"refreshed auth token of another session", refreshed.AccessToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, http.MethodPost, "/accounts/me/mfa/totp", tt.accessToken, nil)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
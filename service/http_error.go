@@ -1,13 +1,26 @@
 package service
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/store"
+
+	"github.com/asaskevich/govalidator"
+)
 
 // httpError represents a custom http error, defined by an http status
-// code and error message.
+// code and error message. It is sent to the client as an RFC 7807 problem
+// details body by respondErr.
 type httpError struct {
-	code          int    // code is the http status code of the error.
-	message       string // message is the error message.
-	internalError error  // internalError is the original error that occurred.
+	code          int          // code is the http status code of the error.
+	errorCode     ErrorCode    // errorCode is the machine-readable code of the error.
+	message       string       // message is the error message.
+	fields        []fieldError // fields are the fields that failed validation.
+	internalError error        // internalError is the original error that occurred.
+	header        http.Header  // header contains additional headers to send with the error.
 }
 
 // Error returns the error message satisfying the Error interface.
@@ -18,30 +31,36 @@ func (e *httpError) Error() string {
 // newValidationError creates a custom validation error.
 // This error is typically returned to the client when some data is provided
 // that does not pass validation, or if the data is missing some required fields.
+// Each field that failed validation is described separately if err is a
+// govalidator.Errors, as returned by govalidator.ValidateStruct.
 func newValidationError(err error) *httpError {
 	return &httpError{
-		code:    http.StatusBadRequest,
-		message: err.Error(),
+		code:      http.StatusBadRequest,
+		errorCode: CodeValidationFailed,
+		message:   "Validation failed",
+		fields:    validationFields(err),
 	}
 }
 
 // newBadRequestError creates a custom bad request error.
 // This error is typically returned to the client if the client provides incorrectly
 // formatted data or leaves out a request body.
-func newBadRequestError(message string) *httpError {
+func newBadRequestError(errorCode ErrorCode, message string) *httpError {
 	return &httpError{
-		code:    http.StatusBadRequest,
-		message: message,
+		code:      http.StatusBadRequest,
+		errorCode: errorCode,
+		message:   message,
 	}
 }
 
 // newNotFoundError creates a custom not found error.
 // This error is typically returned to the client when the requested resource
 // does not exist.
-func newNotFoundError(message string) *httpError {
+func newNotFoundError(errorCode ErrorCode, message string) *httpError {
 	return &httpError{
-		code:    http.StatusNotFound,
-		message: message,
+		code:      http.StatusNotFound,
+		errorCode: errorCode,
+		message:   message,
 	}
 }
 
@@ -50,26 +69,128 @@ func newNotFoundError(message string) *httpError {
 // or if an invalid auth token is provided when interacting with a service.
 func newUnauthorizedError() *httpError {
 	return &httpError{
-		code:    http.StatusUnauthorized,
-		message: "Unauthorized",
+		code:      http.StatusUnauthorized,
+		errorCode: CodeUnauthorized,
+		message:   "Unauthorized",
+	}
+}
+
+// newForbiddenError creates a custom forbidden error.
+// This error is typically returned to the client when the client is authenticated
+// but is not allowed to perform the requested action.
+func newForbiddenError(errorCode ErrorCode, message string) *httpError {
+	return &httpError{
+		code:      http.StatusForbidden,
+		errorCode: errorCode,
+		message:   message,
+	}
+}
+
+// newAccessError creates a custom forbidden error from an access restriction returned
+// by domain.Account.CheckAccess.
+func newAccessError(err error) *httpError {
+	switch err {
+	case domain.ErrAccountBanned:
+		return newForbiddenError(CodeAccountBanned, err.Error())
+	case domain.ErrAccountSuspended:
+		return newForbiddenError(CodeAccountSuspended, err.Error())
+	case domain.ErrPasswordResetRequired:
+		return newForbiddenError(CodePasswordResetRequired, err.Error())
+	}
+	return newForbiddenError(CodeForbidden, err.Error())
+}
+
+// newMethodNotAllowedError creates a custom method not allowed error.
+// This error is returned to the client when a route does not support the request method.
+func newMethodNotAllowedError() *httpError {
+	return &httpError{
+		code:      http.StatusMethodNotAllowed,
+		errorCode: CodeMethodNotAllowed,
+		message:   "Method not allowed",
 	}
 }
 
 // newConflictError creates a custom conflict error.
 // This error is typically returned to the client when a unique index is violated.
-func newConflictError(message string) *httpError {
+func newConflictError(errorCode ErrorCode, message string) *httpError {
+	return &httpError{
+		code:      http.StatusConflict,
+		errorCode: errorCode,
+		message:   message,
+	}
+}
+
+// newTooManyRequestsError creates a custom too many requests error.
+// This error is typically returned to the client when the client has to wait before
+// retrying, such as after too many failed login attempts.
+func newTooManyRequestsError(retryAfter time.Duration) *httpError {
+	seconds := int(retryAfter.Seconds())
+	if retryAfter > time.Duration(seconds)*time.Second {
+		seconds++
+	}
 	return &httpError{
-		code:    http.StatusConflict,
-		message: message,
+		code:      http.StatusTooManyRequests,
+		errorCode: CodeTooManyRequests,
+		message:   "Too many requests",
+		header:    http.Header{"Retry-After": []string{strconv.Itoa(seconds)}},
 	}
 }
 
+// statusClientClosedRequest is the non-standard http status code of requests the client
+// closed before the response was sent.
+const statusClientClosedRequest = 499
+
 // newInternalServerError creates a custom internal server error.
 // This error is typically returned to the client when an unexpected or unknown error occurs.
+// Store errors caused by the request being canceled or a query timing out are instead
+// returned as a client closed request or service unavailable error.
 func newInternalServerError(err error) *httpError {
+	switch err {
+	case store.ErrCanceled:
+		return &httpError{
+			code:          statusClientClosedRequest,
+			errorCode:     CodeRequestCanceled,
+			message:       "Request canceled",
+			internalError: err,
+		}
+	case store.ErrTimeout:
+		return &httpError{
+			code:          http.StatusServiceUnavailable,
+			errorCode:     CodeUnavailable,
+			message:       "Service temporarily unavailable",
+			internalError: err,
+		}
+	}
 	return &httpError{
 		code:          http.StatusInternalServerError,
+		errorCode:     CodeInternal,
 		message:       "Something went wrong",
 		internalError: err,
 	}
 }
+
+// validationFields returns the fields that failed validation described by err, which
+// may contain nested govalidator.Errors.
+func validationFields(err error) []fieldError {
+	var fields []fieldError
+	switch e := err.(type) {
+	case govalidator.Errors:
+		for _, err := range e {
+			fields = append(fields, validationFields(err)...)
+		}
+	case govalidator.Error:
+		field := fieldError{
+			Field:   strings.Join(append(e.Path, e.Name), "."),
+			Rule:    e.Validator,
+			Message: e.Err.Error(),
+		}
+		if ruleErr, ok := e.Err.(*domain.RuleError); ok {
+			field.args = ruleErr.Args
+		}
+		// The messages of govalidator contain the invalid value, so rules without
+		// their own message use the English catalog.
+		field.Message = translate(defaultLanguage, "rule."+field.Rule, field.Message, field.args...)
+		fields = append(fields, field)
+	}
+	return fields
+}
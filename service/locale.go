@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language of responses to clients that accept none of the
+// supported languages. Messages are written in this language in the code.
+const defaultLanguage = "en"
+
+// catalogs contains the translated error messages of every supported language, keyed by
+// error code for error details, by "title." and the http status code for error titles,
+// and by "rule." and the rule name for field validation messages. Messages of rules may
+// contain the fmt verbs of the rule message, such as the minimum length. Messages missing
+// from a catalog are returned in the default language.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"rule.email":    "must be a valid email address",
+		"rule.required": "is required",
+	},
+	"es": {
+		"title.400": "Solicitud incorrecta",
+		"title.401": "No autorizado",
+		"title.403": "Prohibido",
+		"title.404": "No encontrado",
+		"title.405": "Método no permitido",
+		"title.409": "Conflicto",
+		"title.429": "Demasiadas solicitudes",
+		"title.499": "Solicitud cerrada por el cliente",
+		"title.500": "Error interno del servidor",
+		"title.503": "Servicio no disponible",
+
+		string(CodeValidationFailed):         "Algunos campos no son válidos",
+		string(CodeUnauthorized):             "No autorizado",
+		string(CodeNotFound):                 "No encontrado",
+		string(CodeMethodNotAllowed):         "Método no permitido",
+		string(CodeTooManyRequests):          "Demasiadas solicitudes",
+		string(CodeRequestCanceled):          "Solicitud cancelada",
+		string(CodeInternal):                 "Algo salió mal",
+		string(CodeUnavailable):              "Servicio no disponible temporalmente",
+		string(CodeInvalidBody):              "Cuerpo de la solicitud no válido",
+		string(CodeNothingToUpdate):          "No hay nada que actualizar",
+		string(CodeAccountNotFound):          "Cuenta no encontrada",
+		string(CodeAccountExists):            "La cuenta ya existe",
+		string(CodeEmailNotVerified):         "Dirección de correo electrónico no verificada",
+		string(CodeInvalidVerificationToken): "Token de verificación no válido o caducado",
+		string(CodeInvalidResetToken):        "Token de restablecimiento de contraseña no válido o caducado",
+		string(CodeMFAAlreadyEnabled):        "La autenticación en dos pasos ya está activada",
+		string(CodeMFANotEnabled):            "La autenticación en dos pasos no está activada",
+		string(CodeMFAEnrollmentNotStarted):  "La activación de la autenticación en dos pasos no se ha iniciado",
+		string(CodeInvalidLogLevel):          "Nivel de registro no válido",
+		string(CodePermissionDenied):         "Permiso denegado",
+		string(CodeAccountBanned):            "Cuenta bloqueada permanentemente",
+		string(CodeAccountSuspended):         "Cuenta suspendida",
+		string(CodePasswordResetRequired):    "Es necesario restablecer la contraseña",
+		string(CodeImpersonationReadOnly):    "Los tokens de suplantación son de solo lectura",
+		string(CodeImpersonationNotAllowed):  "Solo se pueden suplantar cuentas de jugador",
+
+		"rule.email":         "debe ser una dirección de correo electrónico válida",
+		"rule.required":      "es obligatorio",
+		"rule.minLength":     "debe tener al menos %d caracteres",
+		"rule.maxLength":     "debe tener como máximo %d bytes",
+		"rule.lower":         "debe contener una letra minúscula",
+		"rule.upper":         "debe contener una letra mayúscula",
+		"rule.digit":         "debe contener un dígito",
+		"rule.symbol":        "debe contener un símbolo",
+		"rule.common":        "es demasiado común",
+		"rule.containsEmail": "no debe contener la dirección de correo electrónico",
+		"rule.future":        "debe estar en el futuro",
+	},
+	"fr": {
+		"title.400": "Requête incorrecte",
+		"title.401": "Non autorisé",
+		"title.403": "Interdit",
+		"title.404": "Introuvable",
+		"title.405": "Méthode non autorisée",
+		"title.409": "Conflit",
+		"title.429": "Trop de requêtes",
+		"title.499": "Requête fermée par le client",
+		"title.500": "Erreur interne du serveur",
+		"title.503": "Service indisponible",
+
+		string(CodeValidationFailed):         "Certains champs ne sont pas valides",
+		string(CodeUnauthorized):             "Non autorisé",
+		string(CodeNotFound):                 "Introuvable",
+		string(CodeMethodNotAllowed):         "Méthode non autorisée",
+		string(CodeTooManyRequests):          "Trop de requêtes",
+		string(CodeRequestCanceled):          "Requête annulée",
+		string(CodeInternal):                 "Une erreur est survenue",
+		string(CodeUnavailable):              "Service temporairement indisponible",
+		string(CodeInvalidBody):              "Corps de la requête invalide",
+		string(CodeNothingToUpdate):          "Rien à mettre à jour",
+		string(CodeAccountNotFound):          "Compte introuvable",
+		string(CodeAccountExists):            "Le compte existe déjà",
+		string(CodeEmailNotVerified):         "Adresse e-mail non vérifiée",
+		string(CodeInvalidVerificationToken): "Jeton de vérification invalide ou expiré",
+		string(CodeInvalidResetToken):        "Jeton de réinitialisation du mot de passe invalide ou expiré",
+		string(CodeMFAAlreadyEnabled):        "L'authentification à deux facteurs est déjà activée",
+		string(CodeMFANotEnabled):            "L'authentification à deux facteurs n'est pas activée",
+		string(CodeMFAEnrollmentNotStarted):  "L'activation de l'authentification à deux facteurs n'a pas commencé",
+		string(CodeInvalidLogLevel):          "Niveau de journalisation invalide",
+		string(CodePermissionDenied):         "Permission refusée",
+		string(CodeAccountBanned):            "Compte banni",
+		string(CodeAccountSuspended):         "Compte suspendu",
+		string(CodePasswordResetRequired):    "Réinitialisation du mot de passe requise",
+		string(CodeImpersonationReadOnly):    "Les jetons d'usurpation sont en lecture seule",
+		string(CodeImpersonationNotAllowed):  "Seuls les comptes de joueur peuvent être usurpés",
+
+		"rule.email":         "doit être une adresse e-mail valide",
+		"rule.required":      "est obligatoire",
+		"rule.minLength":     "doit contenir au moins %d caractères",
+		"rule.maxLength":     "doit contenir au plus %d octets",
+		"rule.lower":         "doit contenir une lettre minuscule",
+		"rule.upper":         "doit contenir une lettre majuscule",
+		"rule.digit":         "doit contenir un chiffre",
+		"rule.symbol":        "doit contenir un symbole",
+		"rule.common":        "est trop courant",
+		"rule.containsEmail": "ne doit pas contenir l'adresse e-mail",
+		"rule.future":        "doit être dans le futur",
+	},
+}
+
+// translate returns the message with the provided key in the language, formatted with
+// args, or fallback if the language has no such message.
+func translate(lang string, key string, fallback string, args ...interface{}) string {
+	message, ok := catalogs[lang][key]
+	if !ok {
+		return fallback
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// negotiateLanguage returns the supported language most preferred by the Accept-Language
+// header, or the default language if none of the accepted languages is supported.
+// Regional variants, such as es-MX, are matched by their base language.
+func negotiateLanguage(header string) string {
+	type accepted struct {
+		lang    string
+		quality float64
+	}
+
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(lang, "-"); i >= 0 {
+			lang = lang[:i]
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if _, ok := catalogs[lang]; ok && quality > 0 {
+			langs = append(langs, accepted{lang: lang, quality: quality})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].quality > langs[j].quality
+	})
+	if len(langs) > 0 {
+		return langs[0].lang
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// LoginGuard tracks failed login attempts per client ip address and per account and
+// locks them out with an exponentially increasing delay.
+type LoginGuard struct {
+	store         store.LoginAttemptRepository // store is used to track failed login attempts.
+	accountPolicy domain.LockoutPolicy         // accountPolicy is the lockout policy applied per account.
+	ipPolicy      domain.LockoutPolicy         // ipPolicy is the lockout policy applied per client ip address.
+}
+
+// NewLoginGuard initializes and returns a new login guard.
+func NewLoginGuard(store store.LoginAttemptRepository, accountPolicy domain.LockoutPolicy, ipPolicy domain.LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		store:         store,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+	}
+}
+
+// lockedFor returns how long a login attempt from the client ip address for the account
+// email address has to wait, or zero if the attempt is allowed.
+func (g *LoginGuard) lockedFor(ctx context.Context, ip string, email string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{ipLoginKey(ip), accountLoginKey(email)} {
+		attempt, err := g.store.GetLoginAttempt(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if d := attempt.LockedFor(); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// fail records a failed login attempt from the client ip address for the account email address.
+// Failures are tracked for email addresses without an account as well, so lockouts do not
+// reveal whether an account exists.
+func (g *LoginGuard) fail(ctx context.Context, ip string, email string) error {
+	if _, err := g.store.RecordLoginFailure(ctx, ipLoginKey(ip), g.ipPolicy); err != nil {
+		return err
+	}
+	_, err := g.store.RecordLoginFailure(ctx, accountLoginKey(email), g.accountPolicy)
+	return err
+}
+
+// succeed forgets the failed login attempts of the account email address following a
+// successful login. Failures of the client ip address are kept so that an attacker
+// cannot reset them by logging into an account of their own.
+func (g *LoginGuard) succeed(ctx context.Context, email string) error {
+	return g.store.ResetLoginAttempts(ctx, accountLoginKey(email))
+}
+
+// ipLoginKey returns the login attempt key of a client ip address.
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// accountLoginKey returns the login attempt key of an account email address.
+func accountLoginKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// LockoutService is a collection of admin http handlers for inspecting and clearing
+// login lockouts.
+type LockoutService struct {
+	store         store.LoginAttemptRepository // store is used to access and reset failed login attempts.
+	tokenProvider *token.Provider              // tokenProvider verifies the auth tokens of accounts allowed to manage lockouts.
+	accounts      store.AccountRepository      // accounts is used to check that the accounts of auth tokens are not restricted.
+	refreshTokens store.RefreshTokenRepository // refreshTokens is used to check that the sessions of auth tokens are active.
+	adminKey      string                       // adminKey is the key accepted instead of an auth token on the admin routes.
+}
+
+// NewLockoutService initializes and returns a new lockout service.
+func NewLockoutService(store store.LoginAttemptRepository, tokenProvider *token.Provider, accounts store.AccountRepository, refreshTokens store.RefreshTokenRepository, adminKey string) *LockoutService {
+	return &LockoutService{
+		store:         store,
+		tokenProvider: tokenProvider,
+		accounts:      accounts,
+		refreshTokens: refreshTokens,
+		adminKey:      adminKey,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *LockoutService) Register(router *mux.Router) {
+	admin := router.PathPrefix("/admin/lockouts").Subrouter()
+	admin.Use(RequirePermissionOrAdminKey(s.tokenProvider, s.accounts, s.refreshTokens, s.adminKey, domain.PermissionManageLockouts))
+	admin.HandleFunc("", s.listLockouts).Methods(http.MethodGet)
+	admin.HandleFunc("/{key}", s.unlock).Methods(http.MethodDelete)
+}
+
+// listLockouts is an http handler that returns every currently locked ip address and account.
+func (s *LockoutService) listLockouts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := s.store.ListLockedLoginAttempts(r.Context())
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	respond(w, r, http.StatusOK, attempts)
+}
+
+// unlock is an http handler that manually clears the failed login attempts of a key,
+// such as "account:player@example.com" or "ip:203.0.113.7".
+func (s *LockoutService) unlock(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if err := s.store.ResetLoginAttempts(r.Context(), key); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
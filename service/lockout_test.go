@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.createAccount(t, "player@example.com")
+	s.createAccount(t, "other@example.com")
+
+	// The steps run in order. Accounts are locked once accountLockout.Threshold
+	// failures are reached, even for the right password, and unknown email addresses
+	// are locked the same way so lockouts do not reveal whether an account exists.
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantStatus int
+	}{
+		{"wrong password", "player@example.com", "wrong", http.StatusUnauthorized},
+		{"right password below the threshold", "player@example.com", testPassword, http.StatusOK},
+		{"first failure after reset", "player@example.com", "wrong", http.StatusUnauthorized},
+		{"second failure", "Player@example.com", "wrong", http.StatusUnauthorized},
+		{"failure reaching the threshold", "player@example.com", "wrong", http.StatusUnauthorized},
+		{"right password while locked", "player@example.com", testPassword, http.StatusTooManyRequests},
+		{"other account", "other@example.com", testPassword, http.StatusOK},
+		{"unknown account", "nobody@example.com", "wrong", http.StatusUnauthorized},
+		{"unknown account again", "nobody@example.com", "wrong", http.StatusUnauthorized},
+		{"unknown account reaching the threshold", "nobody@example.com", "wrong", http.StatusUnauthorized},
+		{"unknown account while locked", "nobody@example.com", "wrong", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		w := s.login(t, tt.email, tt.password)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if w.Code != http.StatusTooManyRequests {
+			continue
+		}
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || time.Duration(retryAfter)*time.Second > accountLockout.BaseDelay {
+			t.Errorf("%s: Retry-After = %q, want at most %v", tt.name, w.Header().Get("Retry-After"), accountLockout.BaseDelay)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// LogLevelService is an admin http handler for changing the log level at runtime, for
+// example to collect debug messages while investigating an issue.
+type LogLevelService struct {
+	logger        logger.Logger                // logger is the root logger whose level is changed.
+	tokenProvider *token.Provider              // tokenProvider verifies the auth tokens of accounts allowed to change the log level.
+	accounts      store.AccountRepository      // accounts is used to check that the accounts of auth tokens are not restricted.
+	refreshTokens store.RefreshTokenRepository // refreshTokens is used to check that the sessions of auth tokens are active.
+	adminKey      string                       // adminKey is the key accepted instead of an auth token on the admin routes.
+}
+
+// logLevelRequest is the request and response body of a log level change.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// NewLogLevelService initializes and returns a new log level service.
+func NewLogLevelService(logger logger.Logger, tokenProvider *token.Provider, accounts store.AccountRepository, refreshTokens store.RefreshTokenRepository, adminKey string) *LogLevelService {
+	return &LogLevelService{
+		logger:        logger,
+		tokenProvider: tokenProvider,
+		accounts:      accounts,
+		refreshTokens: refreshTokens,
+		adminKey:      adminKey,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *LogLevelService) Register(router *mux.Router) {
+	admin := router.Path("/admin/log-level").Subrouter()
+	admin.Use(RequirePermissionOrAdminKey(s.tokenProvider, s.accounts, s.refreshTokens, s.adminKey, domain.PermissionManageLogging))
+	admin.HandleFunc("", s.setLevel).Methods(http.MethodPut)
+}
+
+// setLevel is an http handler that sets the minimum level of logged messages.
+func (s *LogLevelService) setLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	level, err := logger.ParseLevel(req.Level)
+	if err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidLogLevel, "Invalid log level"))
+		return
+	}
+
+	s.logger.SetLevel(level)
+	logger.FromContext(r.Context()).Info().Str("level", level.String()).Msg("Log level changed")
+
+	respond(w, r, http.StatusOK, logLevelRequest{Level: level.String()})
+}
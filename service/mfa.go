@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/ratelimit"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+	"untitled_rpg/totp"
+
+	"github.com/gorilla/mux"
+)
+
+// recoveryCodeCount is the number of recovery codes issued when enabling two-factor authentication.
+const recoveryCodeCount = 10
+
+// MFAService is a collection of two-factor authentication related http handlers.
+type MFAService struct {
+	transactor        store.Transactor             // transactor runs the steps of enabling and disabling two-factor authentication within a transaction.
+	store             store.AccountRepository      // store is the account store used to access and save account data.
+	refreshTokenStore store.RefreshTokenRepository // refreshTokenStore is used to check that the sessions of auth tokens are active.
+	recoveryCodeStore store.RecoveryCodeRepository // recoveryCodeStore is used to save and consume recovery codes.
+	tokenProvider     *token.Provider              // tokenProvider is used to verify the auth token of requests to protected routes.
+	issuer            string                       // issuer is the name shown next to the account in authenticator apps.
+}
+
+// mfaCodeRequest is the request body of requests that require a second factor. Either
+// a TOTP code or a recovery code must be provided.
+type mfaCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// totpEnrollResponse is the response body returned when starting TOTP enrollment.
+type totpEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// recoveryCodesResponse is the response body returned when TOTP enrollment is confirmed.
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// NewMFAService initializes and returns a new two-factor authentication service.
+func NewMFAService(transactor store.Transactor, store store.AccountRepository, refreshTokenStore store.RefreshTokenRepository, recoveryCodeStore store.RecoveryCodeRepository, tokenProvider *token.Provider, issuer string) *MFAService {
+	return &MFAService{
+		transactor:        transactor,
+		store:             store,
+		refreshTokenStore: refreshTokenStore,
+		recoveryCodeStore: recoveryCodeStore,
+		tokenProvider:     tokenProvider,
+		issuer:            issuer,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *MFAService) Register(router *mux.Router) {
+	mfa := router.PathPrefix("/accounts/me/mfa").Subrouter()
+	mfa.Use(RequireAuth(s.tokenProvider, s.store, s.refreshTokenStore))
+	mfa.HandleFunc("/totp", s.enrollTOTP).Methods(http.MethodPost)
+	mfa.Handle("/totp", limitedByAccount("disable_totp", ratelimit.PerMinute(10), s.disableTOTP)).Methods(http.MethodDelete)
+	mfa.Handle("/totp/confirm", limitedByAccount("confirm_totp", ratelimit.PerMinute(10), s.confirmTOTP)).Methods(http.MethodPost)
+}
+
+// enrollTOTP is an http handler that generates a new TOTP secret for the authenticated
+// account. Two-factor authentication is not enabled until the secret is confirmed.
+func (s *MFAService) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	account, ok := currentAccount(s.store, w, r)
+	if !ok {
+		return
+	}
+
+	if account.IsTOTPEnabled() {
+		respondErr(w, r, newConflictError(CodeMFAAlreadyEnabled, store.ErrTOTPEnabled.Error()))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	if err := s.store.SetPendingTOTPSecret(r.Context(), account.ID, secret); err != nil {
+		if err == store.ErrTOTPEnabled {
+			respondErr(w, r, newConflictError(CodeMFAAlreadyEnabled, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	respond(w, r, http.StatusOK, totpEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(s.issuer, account.Email, secret),
+	})
+}
+
+// confirmTOTP is an http handler that enables two-factor authentication for the
+// authenticated account once a code generated from the pending secret is provided.
+// The recovery codes are returned only once.
+func (s *MFAService) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req mfaCodeRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	account, ok := currentAccount(s.store, w, r)
+	if !ok {
+		return
+	}
+
+	if account.IsTOTPEnabled() {
+		respondErr(w, r, newConflictError(CodeMFAAlreadyEnabled, store.ErrTOTPEnabled.Error()))
+		return
+	}
+
+	if account.TOTPSecret == nil {
+		respondErr(w, r, newBadRequestError(CodeMFAEnrollmentNotStarted, "Two-factor authentication enrollment not started"))
+		return
+	}
+
+	if ok, err := s.checkTOTP(r.Context(), account, req.Code); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	} else if !ok {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	err = s.transactor.WithTx(r.Context(), func(tx store.Tx) error {
+		if err := tx.RecoveryCodes().ReplaceRecoveryCodes(r.Context(), account.ID, hashes); err != nil {
+			return err
+		}
+		return tx.Accounts().EnableTOTP(r.Context(), account.ID)
+	})
+	if err != nil {
+		if err == store.ErrTOTPEnabled {
+			respondErr(w, r, newConflictError(CodeMFAAlreadyEnabled, err.Error()))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	respond(w, r, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// disableTOTP is an http handler that disables two-factor authentication for the
+// authenticated account. A valid TOTP code or recovery code is required.
+func (s *MFAService) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req mfaCodeRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	account, ok := currentAccount(s.store, w, r)
+	if !ok {
+		return
+	}
+
+	if !account.IsTOTPEnabled() {
+		respondErr(w, r, newBadRequestError(CodeMFANotEnabled, "Two-factor authentication not enabled"))
+		return
+	}
+
+	if ok, err := s.verifyCode(r.Context(), account, req); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	} else if !ok {
+		respondErr(w, r, newUnauthorizedError())
+		return
+	}
+
+	err := s.transactor.WithTx(r.Context(), func(tx store.Tx) error {
+		if err := tx.Accounts().DisableTOTP(r.Context(), account.ID); err != nil {
+			return err
+		}
+		return tx.RecoveryCodes().DeleteRecoveryCodes(r.Context(), account.ID)
+	})
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyCode checks the TOTP code or recovery code of the request for an account
+// with two-factor authentication enabled. Used codes cannot be used again.
+func (s *MFAService) verifyCode(ctx context.Context, account domain.Account, req mfaCodeRequest) (bool, error) {
+	if req.Code != "" {
+		return s.checkTOTP(ctx, account, req.Code)
+	}
+
+	if req.RecoveryCode != "" {
+		return s.recoveryCodeStore.UseRecoveryCode(ctx, account.ID, token.HashToken(normalizeRecoveryCode(req.RecoveryCode)))
+	}
+
+	return false, nil
+}
+
+// checkTOTP checks a TOTP code against the secret of an account and records the time
+// step of the code so it cannot be replayed.
+func (s *MFAService) checkTOTP(ctx context.Context, account domain.Account, code string) (bool, error) {
+	if account.TOTPSecret == nil {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(*account.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return s.store.UseTOTPStep(ctx, account.ID, step)
+}
+
+// generateRecoveryCodes generates a new set of recovery codes along with their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes[i] = code
+		hashes[i] = token.HashToken(normalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes formatting from a recovery code so codes are accepted
+// regardless of case, spaces or dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
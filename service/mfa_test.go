@@ -0,0 +1,110 @@
+package service_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	"untitled_rpg/token"
+	"untitled_rpg/totp"
+)
+
+func TestAuthenticateMFA(t *testing.T) {
+	s := newTestServer(t)
+	account := s.createAccount(t, "player@example.com")
+
+	ctx := context.Background()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	if err := s.store.SetPendingTOTPSecret(ctx, account.ID, secret); err != nil {
+		t.Fatalf("SetPendingTOTPSecret() error = %v", err)
+	}
+	if err := s.store.EnableTOTP(ctx, account.ID); err != nil {
+		t.Fatalf("EnableTOTP() error = %v", err)
+	}
+	if err := s.store.ReplaceRecoveryCodes(ctx, account.ID, []string{token.HashToken("abcde12345")}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes() error = %v", err)
+	}
+
+	now := time.Now()
+	current, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatalf("Code() error = %v", err)
+	}
+	previous, err := totp.Code(secret, now.Add(-totp.Period*time.Second))
+	if err != nil {
+		t.Fatalf("Code() error = %v", err)
+	}
+	wrong := "000000"
+	if current == wrong || previous == wrong {
+		wrong = "111111"
+	}
+
+	// The steps run in order, each completing a new login. A TOTP code cannot be used
+	// again, nor can a code of an earlier time step once a later one was used.
+	tests := []struct {
+		name         string
+		code         string
+		recoveryCode string
+		wantStatus   int
+	}{
+		{"current code", current, "", http.StatusOK},
+		{"replayed code", current, "", http.StatusUnauthorized},
+		{"code of the previous step", previous, "", http.StatusUnauthorized},
+		{"recovery code", "", "ABCDE-12345", http.StatusOK},
+		{"reused recovery code", "", "abcde12345", http.StatusUnauthorized},
+		{"wrong code", wrong, "", http.StatusUnauthorized},
+		{"no second factor", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		challenge := decodeTokens(t, s.login(t, "player@example.com", testPassword))
+		if !challenge.MFARequired || challenge.MFAToken == "" || challenge.AccessToken != "" {
+			t.Fatalf("%s: login = %+v, want an MFA challenge", tt.name, challenge)
+		}
+
+		w := s.do(t, http.MethodPost, "/authenticate/mfa", "", map[string]string{
+			"mfaToken":     challenge.MFAToken,
+			"code":         tt.code,
+			"recoveryCode": tt.recoveryCode,
+		})
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+		if w.Code == http.StatusOK {
+			if got := decodeTokens(t, w); got.AccessToken == "" || got.RefreshToken == "" {
+				t.Errorf("%s: tokens = %+v, want an access token and refresh token", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestAuthenticateMFAInvalidToken(t *testing.T) {
+	s := newTestServer(t)
+	s.createAccount(t, "player@example.com")
+
+	// An account without two-factor authentication receives tokens directly, which
+	// cannot be used in place of an MFA token.
+	issued := decodeTokens(t, s.login(t, "player@example.com", testPassword))
+	if issued.MFARequired || issued.AccessToken == "" {
+		t.Fatalf("login = %+v, want tokens", issued)
+	}
+
+	tests := []struct {
+		name     string
+		mfaToken string
+	}{
+		{"access token", issued.AccessToken},
+		{"refresh token", issued.RefreshToken},
+		{"garbage", "garbage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, http.MethodPost, "/authenticate/mfa", "", map[string]string{"mfaToken": tt.mfaToken, "code": "000000"})
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"untitled_rpg/clientip"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/store"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// contextKey is the type used for values stored in a request context by this package.
+type contextKey int
+
+const (
+	// accountIDKey is the context key of the authenticated account id.
+	accountIDKey contextKey = iota
+	// sessionIDKey is the context key of the authenticated session id.
+	sessionIDKey
+	// roleKey is the context key of the role of the authenticated account.
+	roleKey
+	// impersonatorIDKey is the context key of the id of the staff account impersonating
+	// the authenticated account.
+	impersonatorIDKey
+	// requestIDKey is the context key of the request id.
+	requestIDKey
+)
+
+// requestIDHeader is the header used to receive and return the request id.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request id received from a client.
+const maxRequestIDLength = 128
+
+// validRequestID matches request ids received from clients that are safe to log and return.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// RequireAuth returns a middleware that rejects requests without a valid bearer
+// auth token. The id of the authenticated account is added to the request context
+// and can be retrieved with AccountID, along with the session id retrieved with SessionID
+// and the role retrieved with AccountRole.
+//
+// The account is loaded on every request, so that bans, suspensions, required password
+// resets and session revocations apply to auth tokens that were already issued. Auth
+// tokens of a session that ended, such as after logging out, are rejected as well.
+// Impersonation tokens are accepted regardless of the restrictions of the account, since
+// they are used by staff to investigate them, but their read scope only allows requests
+// that read.
+func RequireAuth(tokenProvider *token.Provider, accounts store.AccountRepository, sessions store.RefreshTokenRepository) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := bearerToken(r)
+			if !ok {
+				respondErr(w, r, newUnauthorizedError())
+				return
+			}
+
+			claims, err := tokenProvider.VerifyToken(tokenString)
+			if err != nil {
+				respondErr(w, r, newUnauthorizedError())
+				return
+			}
+
+			account, err := accounts.GetAccountByID(r.Context(), claims.AccountID)
+			if err != nil {
+				if err == store.ErrAccountNotFound {
+					respondErr(w, r, newUnauthorizedError())
+				} else {
+					respondErr(w, r, newInternalServerError(err))
+				}
+				return
+			}
+			if account.IsSessionRevoked(claims.Version) {
+				respondErr(w, r, newUnauthorizedError())
+				return
+			}
+			if claims.SessionID != "" {
+				active, err := sessions.IsSessionActive(r.Context(), claims.SessionID)
+				if err != nil {
+					respondErr(w, r, newInternalServerError(err))
+					return
+				}
+				if !active {
+					respondErr(w, r, newUnauthorizedError())
+					return
+				}
+			}
+			if claims.IsReadOnly() && !isReadOnlyMethod(r.Method) {
+				respondErr(w, r, newForbiddenError(CodeImpersonationReadOnly, "Impersonation tokens are read-only"))
+				return
+			}
+			if !claims.IsImpersonation() {
+				if err := account.CheckAccess(time.Now()); err != nil {
+					respondErr(w, r, newAccessError(err))
+					return
+				}
+			}
+
+			// The role of the account is used rather than the role claim, so that a role
+			// change applies to auth tokens that were already issued.
+			role := account.Role
+			if role == "" {
+				role = domain.RolePlayer
+			}
+
+			ctx := context.WithValue(r.Context(), accountIDKey, claims.AccountID)
+			ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+			ctx = context.WithValue(ctx, roleKey, role)
+			log := logger.FromContext(ctx).With().Uint("accountId", uint(claims.AccountID))
+			if claims.IsImpersonation() {
+				// Every request made with an impersonation token is logged with the
+				// impersonator by AccessLog, which completes the audit log.
+				ctx = context.WithValue(ctx, impersonatorIDKey, claims.ImpersonatorID)
+				log = log.Uint("impersonatorId", uint(claims.ImpersonatorID))
+			}
+			ctx = logger.NewContext(ctx, log.Logger())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AccountID returns the id of the authenticated account stored in the context
+// by RequireAuth.
+func AccountID(ctx context.Context) (uint64, bool) {
+	id, ok := ctx.Value(accountIDKey).(uint64)
+	return id, ok
+}
+
+// SessionID returns the id of the authenticated session stored in the context
+// by RequireAuth.
+func SessionID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionIDKey).(string)
+	return id, ok
+}
+
+// AccountRole returns the role of the authenticated account stored in the context
+// by RequireAuth.
+func AccountRole(ctx context.Context) (domain.Role, bool) {
+	role, ok := ctx.Value(roleKey).(domain.Role)
+	return role, ok
+}
+
+// ImpersonatorID returns the id of the staff account impersonating the authenticated
+// account, stored in the context by RequireAuth for requests made with an impersonation token.
+func ImpersonatorID(ctx context.Context) (uint64, bool) {
+	id, ok := ctx.Value(impersonatorIDKey).(uint64)
+	return id, ok
+}
+
+// RequestID returns the id of the request stored in the context by AssignRequestID.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+// AssignRequestID returns a middleware that assigns an id to every request. The id
+// received in the X-Request-ID header is used if it is valid, so that requests can be
+// traced across services; otherwise a new id is generated. The id is returned in the
+// X-Request-ID response header and can be retrieved with RequestID. A logger derived
+// from the provided logger, carrying the request id and client ip address, is added to
+// the request context and can be retrieved with logger.FromContext.
+func AssignRequestID(base logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if len(id) > maxRequestIDLength || !validRequestID.MatchString(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+
+			log := base.With().Str("requestId", id).Str("remoteIp", clientip.FromRequest(r)).Logger()
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			ctx = logger.NewContext(ctx, log)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AccessLog is a middleware that logs a line for every request once it has been handled.
+// It must be used on a mux router so that the route of the request is known; the route is
+// also added to the request logger.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		log := logger.FromContext(r.Context()).With().Str("route", route).Logger()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(logger.NewContext(r.Context(), log)))
+
+		log.Info().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", rec.status).
+			Int("bytes", rec.bytes).
+			Int("durationMs", int(time.Since(start).Milliseconds())).
+			Msg("Request handled")
+	})
+}
+
+// RequireAdminKey returns a middleware that rejects requests that do not provide the
+// admin key in the X-Admin-Key header. If the admin key is empty, every request is rejected.
+func RequireAdminKey(adminKey string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-Admin-Key")
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+				respondErr(w, r, newUnauthorizedError())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission returns a middleware that rejects requests of accounts whose role
+// does not grant the permission. It must be used after RequireAuth, for example on a
+// subrouter with router.Use(RequireAuth(tokenProvider, accounts, sessions), RequirePermission(permission)).
+func RequirePermission(permission domain.Permission) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := AccountRole(r.Context())
+			if !ok {
+				respondErr(w, r, newUnauthorizedError())
+				return
+			}
+			if !role.Can(permission) {
+				logger.FromContext(r.Context()).Warn().
+					Str("role", string(role)).
+					Str("permission", string(permission)).
+					Msg("Permission denied")
+				respondErr(w, r, newForbiddenError(CodePermissionDenied, "Permission denied"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermissionOrAdminKey returns a middleware that accepts requests providing the
+// admin key in the X-Admin-Key header, which suits automation, and requests with a bearer
+// auth token of an account whose role grants the permission.
+func RequirePermissionOrAdminKey(tokenProvider *token.Provider, accounts store.AccountRepository, sessions store.RefreshTokenRepository, adminKey string, permission domain.Permission) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		withKey := RequireAdminKey(adminKey)(next)
+		withToken := RequireAuth(tokenProvider, accounts, sessions)(RequirePermission(permission)(next))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin-Key") != "" {
+				withKey.ServeHTTP(w, r)
+				return
+			}
+			withToken.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isReadOnlyMethod reports whether requests with the http method only read.
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// bearerToken extracts the token from the Authorization header of the request.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// responseRecorder wraps an http.ResponseWriter to record the response status and size.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status before writing it.
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written.
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// newRequestID generates a new random request id.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// The id only correlates log messages, so a predictable id is acceptable
+		// in the unlikely event that the random source fails.
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/mail"
+	"untitled_rpg/ratelimit"
+	"untitled_rpg/store"
+	"untitled_rpg/task"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// PasswordService is a collection of password recovery related http handlers.
+type PasswordService struct {
+	transactor         store.Transactor              // transactor runs the steps of a password reset within a transaction, revoking all sessions.
+	store              store.AccountRepository       // store is the account store used to access and save account data.
+	passwordResetStore store.PasswordResetRepository // passwordResetStore is used to save and consume password resets.
+	passwordPolicy     *domain.PasswordPolicy        // passwordPolicy is the policy new passwords are validated against.
+	mailer             mail.Mailer                   // mailer is used to deliver password reset emails.
+	tasks              *task.Queue                   // tasks sends password reset emails in the background.
+	resetURL           string                        // resetURL is the url of the page where a new password is chosen.
+	resetTTL           time.Duration                 // resetTTL is how long password reset tokens remain valid.
+}
+
+// forgotPasswordRequest is the request body of a password reset request.
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// resetPasswordRequest is the request body of a password reset.
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// NewPasswordService initializes and returns a new password service.
+func NewPasswordService(transactor store.Transactor, store store.AccountRepository, passwordResetStore store.PasswordResetRepository, passwordPolicy *domain.PasswordPolicy, mailer mail.Mailer, tasks *task.Queue, resetURL string, resetTTL time.Duration) *PasswordService {
+	return &PasswordService{
+		transactor:         transactor,
+		store:              store,
+		passwordResetStore: passwordResetStore,
+		passwordPolicy:     passwordPolicy,
+		mailer:             mailer,
+		tasks:              tasks,
+		resetURL:           resetURL,
+		resetTTL:           resetTTL,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *PasswordService) Register(router *mux.Router) {
+	router.Handle("/password/forgot", limited("forgot_password", ratelimit.PerMinute(5), s.forgotPassword)).Methods(http.MethodPost)
+	router.Handle("/password/reset", limited("reset_password", ratelimit.PerMinute(10), s.resetPassword)).Methods(http.MethodPost)
+}
+
+// forgotPassword is an http handler that emails a password reset token to an account.
+// The response is always the same so it does not reveal whether the account exists.
+func (s *PasswordService) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	account := domain.Account{Email: req.Email}
+	if err := account.NormalizeEmail(); err != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// The email is sent in the background so the response time does not reveal
+	// whether the account exists.
+	err := s.tasks.Submit(r.Context(), "password reset email", func(ctx context.Context) error {
+		return s.sendReset(ctx, account.Email)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Err(err).Msg("Failed to send password reset email")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resetPassword is an http handler that sets a new account password using a password
+// reset token. All existing sessions of the account are revoked, along with the auth
+// tokens issued to them.
+func (s *PasswordService) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	reset, err := s.passwordResetStore.GetPasswordReset(r.Context(), token.HashToken(req.Token))
+	if err != nil {
+		if err == store.ErrPasswordResetNotFound {
+			respondErr(w, r, newBadRequestError(CodeInvalidResetToken, "Invalid or expired password reset token"))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	if reset.IsUsed() || reset.IsExpired() {
+		respondErr(w, r, newBadRequestError(CodeInvalidResetToken, "Invalid or expired password reset token"))
+		return
+	}
+
+	account, err := s.store.GetAccountByID(r.Context(), reset.AccountID)
+	if err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	account.Password = req.Password
+	if err := account.ValidatePassword(s.passwordPolicy); err != nil {
+		respondErr(w, r, newValidationError(err))
+		return
+	}
+
+	if err := account.HashPassword(); err != nil {
+		respondErr(w, r, newInternalServerError(err))
+		return
+	}
+
+	err = s.transactor.WithTx(r.Context(), func(tx store.Tx) error {
+		used, err := tx.PasswordResets().UsePasswordReset(r.Context(), reset)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return store.ErrPasswordResetNotFound
+		}
+
+		if err := tx.Accounts().UpdatePassword(r.Context(), account.ID, account.Password); err != nil {
+			return err
+		}
+
+		return endSessions(r, tx, account.ID)
+	})
+	if err != nil {
+		if err == store.ErrPasswordResetNotFound {
+			respondErr(w, r, newBadRequestError(CodeInvalidResetToken, "Invalid or expired password reset token"))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendReset creates a password reset for the account with the provided email and emails
+// its token to the account. Nothing is sent if no account exists with the email.
+func (s *PasswordService) sendReset(ctx context.Context, email string) error {
+	account, err := s.store.GetAccount(ctx, email)
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return s.mailReset(ctx, account, "Reset your password",
+		"A password reset was requested for your account.",
+		"If you did not request a password reset, you can ignore this email.")
+}
+
+// sendRequiredReset creates a password reset for an account that support staff require
+// to choose a new password, and emails its token to the account.
+func (s *PasswordService) sendRequiredReset(ctx context.Context, account domain.Account) error {
+	return s.mailReset(ctx, account, "Choose a new password",
+		"Our support team requires you to choose a new password before logging in again.",
+		"Contact support if you have any question about this request.")
+}
+
+// mailReset creates a password reset for the account and emails its token to the account,
+// between the provided introduction and conclusion.
+func (s *PasswordService) mailReset(ctx context.Context, account domain.Account, subject string, intro string, outro string) error {
+	resetToken, err := token.NewResetToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.passwordResetStore.CreatePasswordReset(ctx, domain.PasswordReset{
+		AccountID: account.ID,
+		TokenHash: token.HashToken(resetToken),
+		ExpiresAt: time.Now().Add(s.resetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := s.resetURL + "?token=" + url.QueryEscape(resetToken)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      account.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("%s Choose a new password by opening the link below:\r\n\r\n%s\r\n\r\n"+
+			"The link expires in %s. %s\r\n", intro, link, s.resetTTL, outro),
+	})
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"untitled_rpg/logger"
+)
+
+// ErrorCode is a stable, machine-readable identifier of an error returned to clients.
+// Clients should rely on the code rather than on the message, which may be reworded
+// or translated.
+type ErrorCode string
+
+// Generic error codes, returned when no more specific code applies.
+const (
+	CodeBadRequest       ErrorCode = "bad_request"        // CodeBadRequest is returned for malformed requests.
+	CodeValidationFailed ErrorCode = "validation_failed"  // CodeValidationFailed is returned when fields fail validation.
+	CodeUnauthorized     ErrorCode = "unauthorized"       // CodeUnauthorized is returned for missing or invalid credentials.
+	CodeForbidden        ErrorCode = "forbidden"          // CodeForbidden is returned when an action is not allowed.
+	CodeNotFound         ErrorCode = "not_found"          // CodeNotFound is returned when a resource does not exist.
+	CodeMethodNotAllowed ErrorCode = "method_not_allowed" // CodeMethodNotAllowed is returned when a route does not support the method.
+	CodeConflict         ErrorCode = "conflict"           // CodeConflict is returned when a resource conflicts with an existing one.
+	CodeTooManyRequests  ErrorCode = "too_many_requests"  // CodeTooManyRequests is returned when the client must wait before retrying.
+	CodeRequestCanceled  ErrorCode = "request_canceled"   // CodeRequestCanceled is returned when the client closed the request before it completed.
+	CodeInternal         ErrorCode = "internal_error"     // CodeInternal is returned when an unexpected error occurs.
+	CodeUnavailable      ErrorCode = "unavailable"        // CodeUnavailable is returned when the request could not complete in time.
+)
+
+// Specific error codes.
+const (
+	CodeInvalidBody              ErrorCode = "invalid_body"               // CodeInvalidBody is returned when the request body cannot be decoded.
+	CodeNothingToUpdate          ErrorCode = "nothing_to_update"          // CodeNothingToUpdate is returned when an update changes nothing.
+	CodeAccountNotFound          ErrorCode = "account_not_found"          // CodeAccountNotFound is returned when the account does not exist.
+	CodeAccountExists            ErrorCode = "account_exists"             // CodeAccountExists is returned when the email address is already used.
+	CodeEmailNotVerified         ErrorCode = "email_not_verified"         // CodeEmailNotVerified is returned when login requires a verified email address.
+	CodeInvalidVerificationToken ErrorCode = "invalid_verification_token" // CodeInvalidVerificationToken is returned for invalid email verification tokens.
+	CodeInvalidResetToken        ErrorCode = "invalid_reset_token"        // CodeInvalidResetToken is returned for invalid password reset tokens.
+	CodeMFAAlreadyEnabled        ErrorCode = "mfa_already_enabled"        // CodeMFAAlreadyEnabled is returned when two-factor authentication is already enabled.
+	CodeMFANotEnabled            ErrorCode = "mfa_not_enabled"            // CodeMFANotEnabled is returned when two-factor authentication is not enabled.
+	CodeMFAEnrollmentNotStarted  ErrorCode = "mfa_enrollment_not_started" // CodeMFAEnrollmentNotStarted is returned when confirming an enrollment that was not started.
+	CodeInvalidLogLevel          ErrorCode = "invalid_log_level"          // CodeInvalidLogLevel is returned for unknown log levels.
+	CodePermissionDenied         ErrorCode = "permission_denied"          // CodePermissionDenied is returned when the role of the account lacks a permission.
+	CodeAccountBanned            ErrorCode = "account_banned"             // CodeAccountBanned is returned when a banned account logs in or uses an auth token.
+	CodeAccountSuspended         ErrorCode = "account_suspended"          // CodeAccountSuspended is returned when a suspended account logs in or uses an auth token.
+	CodePasswordResetRequired    ErrorCode = "password_reset_required"    // CodePasswordResetRequired is returned when an account must reset its password before logging in.
+	CodeImpersonationReadOnly    ErrorCode = "impersonation_read_only"    // CodeImpersonationReadOnly is returned when an impersonation token is used to make a change.
+	CodeImpersonationNotAllowed  ErrorCode = "impersonation_not_allowed"  // CodeImpersonationNotAllowed is returned when impersonating a staff account.
+)
+
+// problem is an RFC 7807 problem details response body, extended with the error code,
+// the request id and the fields that failed validation.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError describes a field that failed validation.
+type fieldError struct {
+	Field   string `json:"field"`   // Field is the json name of the field.
+	Rule    string `json:"rule"`    // Rule is the name of the validation rule that failed.
+	Message string `json:"message"` // Message describes the failure in the language of the response.
+
+	args []interface{} // args are the arguments of the message, used to translate it.
+}
+
+// statusText returns the text of an http status code, including the non-standard client
+// closed request status code.
+func statusText(code int) string {
+	if code == statusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(code)
+}
+
+// problemTypePrefix is the prefix of the problem type uri, which is followed by the error code.
+const problemTypePrefix = "urn:untitled-rpg:problem:"
+
+// writeProblem replies to the request with the error as an RFC 7807 problem details
+// body, translated to the language preferred by the client.
+func writeProblem(w http.ResponseWriter, r *http.Request, err *httpError) {
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+
+	p := problem{
+		Type:     problemTypePrefix + string(err.errorCode),
+		Title:    translate(lang, "title."+strconv.Itoa(err.code), statusText(err.code)),
+		Status:   err.code,
+		Detail:   translate(lang, string(err.errorCode), err.message),
+		Instance: r.URL.Path,
+		Code:     err.errorCode,
+	}
+	if id, ok := RequestID(r.Context()); ok {
+		p.RequestID = id
+	}
+	for _, field := range err.fields {
+		field.Message = translate(lang, "rule."+field.Rule, field.Message, field.args...)
+		p.Errors = append(p.Errors, field)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.code)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logger.FromContext(r.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
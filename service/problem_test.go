@@ -0,0 +1,54 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemCodes(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		language   string
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{"invalid body", "{", "", http.StatusBadRequest, "invalid_body", "Invalid request body"},
+		{"translated invalid body", "{", "es", http.StatusBadRequest, "invalid_body", "Cuerpo de la solicitud no válido"},
+		{"unknown refresh token", `{"refreshToken":"unknown"}`, "", http.StatusUnauthorized, "unauthorized", "Unauthorized"},
+		{"translated unauthorized", `{"refreshToken":"unknown"}`, "fr-CA, es;q=0.5", http.StatusUnauthorized, "unauthorized", "Non autorisé"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/authenticate/refresh", strings.NewReader(tt.body))
+			r.Header.Set("Accept-Language", tt.language)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, r)
+
+			var got struct {
+				Type   string `json:"type"`
+				Status int    `json:"status"`
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if w.Code != tt.wantStatus || got.Status != tt.wantStatus {
+				t.Errorf("status = %d with body status %d, want %d", w.Code, got.Status, tt.wantStatus)
+			}
+			if got.Code != tt.wantCode || got.Type != "urn:untitled-rpg:problem:"+tt.wantCode {
+				t.Errorf("code = %q with type %q, want %q", got.Code, got.Type, tt.wantCode)
+			}
+			if got.Detail != tt.wantDetail {
+				t.Errorf("detail = %q, want %q", got.Detail, tt.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"untitled_rpg/ratelimit"
+)
+
+// limited wraps a handler with a route rate limit policy that identifies clients by
+// ip address.
+func limited(name string, limit ratelimit.Limit, handler http.HandlerFunc) http.Handler {
+	return ratelimit.Apply(ratelimit.Policy{Name: name, Limit: limit})(handler)
+}
+
+// limitedByAccount wraps a handler with a route rate limit policy that identifies clients
+// by the authenticated account. It must be used on routes protected by RequireAuth.
+func limitedByAccount(name string, limit ratelimit.Limit, handler http.HandlerFunc) http.Handler {
+	return ratelimit.Apply(ratelimit.Policy{Name: name, Limit: limit, Key: byAccount})(handler)
+}
+
+// byAccount identifies clients by the authenticated account, or by ip address if the
+// request is not authenticated.
+func byAccount(r *http.Request) string {
+	if id, ok := AccountID(r.Context()); ok {
+		return "account:" + strconv.FormatUint(id, 10)
+	}
+	return ratelimit.ByIP(r)
+}
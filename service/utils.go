@@ -1,16 +1,51 @@
 package service
 
 import (
+	"encoding/json"
 	"net/http"
-
-	"github.com/rs/zerolog/log"
+	"time"
+	"untitled_rpg/logger"
 )
 
+// respond replies to the request with the provided http status code and
+// body encoded as json.
+func respond(w http.ResponseWriter, r *http.Request, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.FromContext(r.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
 // respondErr replies to the request with the error message and
-// http status code defined by the provided httpError.
-func respondErr(w http.ResponseWriter, err *httpError) {
-	if err.code == http.StatusInternalServerError {
-		log.Error().Err(err.internalError).Send()
+// http status code defined by the provided httpError. Internal errors and
+// timeouts are logged with the request logger.
+func respondErr(w http.ResponseWriter, r *http.Request, err *httpError) {
+	switch err.code {
+	case http.StatusInternalServerError:
+		logger.FromContext(r.Context()).Error().Err(err.internalError).Msg("Internal server error")
+	case http.StatusServiceUnavailable:
+		logger.FromContext(r.Context()).Warn().Err(err.internalError).Msg("Request timed out")
+	}
+	for key, values := range err.header {
+		w.Header()[key] = values
 	}
-	http.Error(w, err.message, err.code)
+	writeProblem(w, r, err)
+}
+
+// NotFound is an http handler that replies to every request with a not found error.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respondErr(w, r, newNotFoundError(CodeNotFound, "Not found"))
+}
+
+// MethodNotAllowed is an http handler that replies to every request with a method not
+// allowed error.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondErr(w, r, newMethodNotAllowedError())
+}
+
+// TooManyRequests replies to the request with a too many requests error. It is used by
+// the rate limiter so that limited requests receive the same errors as other requests.
+func TooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	respondErr(w, r, newTooManyRequestsError(retryAfter))
 }
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/logger"
+	"untitled_rpg/mail"
+	"untitled_rpg/ratelimit"
+	"untitled_rpg/store"
+	"untitled_rpg/task"
+	"untitled_rpg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// VerificationService is a collection of email verification related http handlers.
+type VerificationService struct {
+	store          store.AccountRepository // store is the account store used to access and save account data.
+	tokenProvider  *token.Provider         // tokenProvider is used to issue and verify verification tokens.
+	mailer         mail.Mailer             // mailer is used to deliver verification emails.
+	tasks          *task.Queue             // tasks sends verification emails in the background.
+	verifyURL      string                  // verifyURL is the url of the page that confirms a verification token.
+	tokenTTL       time.Duration           // tokenTTL is how long verification tokens remain valid.
+	resendInterval time.Duration           // resendInterval is the minimum time between two verification emails.
+}
+
+// verifyRequest is the request body of an email verification.
+type verifyRequest struct {
+	Token string `json:"token"`
+}
+
+// resendRequest is the request body of a verification email resend.
+type resendRequest struct {
+	Email string `json:"email"`
+}
+
+// NewVerificationService initializes and returns a new verification service.
+func NewVerificationService(store store.AccountRepository, tokenProvider *token.Provider, mailer mail.Mailer, tasks *task.Queue, verifyURL string, tokenTTL time.Duration, resendInterval time.Duration) *VerificationService {
+	return &VerificationService{
+		store:          store,
+		tokenProvider:  tokenProvider,
+		mailer:         mailer,
+		tasks:          tasks,
+		verifyURL:      verifyURL,
+		tokenTTL:       tokenTTL,
+		resendInterval: resendInterval,
+	}
+}
+
+// Register registers all service routes with the provided router.
+func (s *VerificationService) Register(router *mux.Router) {
+	router.Handle("/accounts/verify", limited("verify_email", ratelimit.PerMinute(20), s.verify)).Methods(http.MethodPost)
+	router.Handle("/accounts/verify/resend", limited("resend_verification", ratelimit.PerMinute(5), s.resend)).Methods(http.MethodPost)
+}
+
+// verify is an http handler that confirms the email address of an account using the
+// token from a verification email. Each token can only be used once.
+func (s *VerificationService) verify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	claims, err := s.tokenProvider.VerifyPurposeToken(req.Token, token.PurposeVerifyEmail)
+	if err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidVerificationToken, "Invalid or expired verification token"))
+		return
+	}
+
+	if err := s.store.VerifyEmail(r.Context(), claims.AccountID, claims.Subject); err != nil {
+		if err == store.ErrAccountNotFound {
+			respondErr(w, r, newBadRequestError(CodeInvalidVerificationToken, "Invalid or expired verification token"))
+		} else {
+			respondErr(w, r, newInternalServerError(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resend is an http handler that sends a new verification email. The response is always
+// the same so it does not reveal whether an unverified account exists for the email address.
+func (s *VerificationService) resend(w http.ResponseWriter, r *http.Request) {
+	var req resendRequest
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, newBadRequestError(CodeInvalidBody, "Invalid request body"))
+		return
+	}
+
+	account := domain.Account{Email: req.Email}
+	if err := account.NormalizeEmail(); err != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// The account is looked up in the background as well, so the response time does not
+	// reveal whether the account exists.
+	err := s.tasks.Submit(r.Context(), "verification email", func(ctx context.Context) error {
+		return s.resendVerification(ctx, account.Email)
+	})
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Err(err).Msg("Failed to send verification email")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// queueVerification sends a verification email to the account in the background, so
+// clients do not wait for the mail server. Failures are logged.
+func (s *VerificationService) queueVerification(ctx context.Context, account domain.Account) {
+	err := s.tasks.Submit(ctx, "verification email", func(ctx context.Context) error {
+		return s.sendVerification(ctx, account)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("Failed to send verification email")
+	}
+}
+
+// resendVerification sends a verification email to the account with the email address,
+// if it exists and is not verified yet.
+func (s *VerificationService) resendVerification(ctx context.Context, email string) error {
+	account, err := s.store.GetAccount(ctx, email)
+	if err != nil {
+		if err == store.ErrAccountNotFound {
+			return nil
+		}
+		return err
+	}
+	if account.IsEmailVerified() {
+		return nil
+	}
+	return s.sendVerification(ctx, account)
+}
+
+// sendVerification emails a verification token to the account email address, unless
+// a verification email was already sent within the resend interval.
+func (s *VerificationService) sendVerification(ctx context.Context, account domain.Account) error {
+	ok, err := s.store.MarkVerificationSent(ctx, account.ID, s.resendInterval)
+	if err != nil || !ok {
+		return err
+	}
+
+	verificationToken, err := s.tokenProvider.IssuePurposeToken(token.PurposeVerifyEmail, account.ID, account.Email, s.tokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.verifyURL + "?token=" + url.QueryEscape(verificationToken)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      account.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Please confirm your email address by opening the link below:\r\n\r\n%s\r\n\r\n"+
+			"The link expires in %s. If you did not create an account, you can ignore this email.\r\n", link, s.tokenTTL),
+	})
+}
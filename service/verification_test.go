@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"untitled_rpg/mail"
+	"untitled_rpg/service"
+	"untitled_rpg/task"
+)
+
+// failingMailer is a Mailer that always fails.
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg mail.Message) error {
+	return errors.New("Mail server unavailable")
+}
+
+func TestResendVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		mailer mail.Mailer
+	}{
+		{"working mailer", mail.NewMemoryMailer()},
+		{"failing mailer", failingMailer{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.createAccount(t, "player@example.com")
+
+			tasks := task.NewQueue(1, 10, time.Second)
+			verification := service.NewVerificationService(s.store, s.provider, tt.mailer, tasks, "https://example.com/verify", time.Hour, time.Minute)
+			verification.Register(s.router)
+
+			// Every request gets the same response, whether or not an unverified account
+			// exists and whether or not the email can be sent.
+			for _, email := range []string{"player@example.com", "nobody@example.com", "not an email"} {
+				w := s.do(t, http.MethodPost, "/accounts/verify/resend", "", map[string]string{"email": email})
+				if w.Code != http.StatusAccepted {
+					t.Errorf("resend to %q status = %d, want %d: %s", email, w.Code, http.StatusAccepted, w.Body)
+				}
+			}
+
+			if err := tasks.Stop(context.Background()); err != nil {
+				t.Fatalf("Stop() error = %v", err)
+			}
+			if mailer, ok := tt.mailer.(*mail.MemoryMailer); ok {
+				messages := mailer.Messages()
+				if len(messages) != 1 || messages[0].To != "player@example.com" {
+					t.Errorf("sent %+v, want a single verification email to the account", messages)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 	"untitled_rpg/domain"
 
 	"github.com/jackc/pgerrcode"
@@ -15,41 +17,59 @@ var (
 	ErrAccountNotFound = errors.New("Account not found")
 	// ErrAccountExists is returned when an account already exists with the provided email address.
 	ErrAccountExists = errors.New("Account already exists")
+	// ErrTOTPEnabled is returned when two-factor authentication is already enabled for an account.
+	ErrTOTPEnabled = errors.New("Two-factor authentication already enabled")
 )
 
+// accountColumns are the columns selected when retrieving an account.
+const accountColumns = `id, email, password, role, email_verified_at, verification_sent_at,
+	totp_secret, totp_enabled_at, totp_last_step, suspended_until, banned_at, restriction_reason,
+	password_reset_required_at, session_version, created_at, updated_at`
+
+// AccountFilter selects the accounts returned by a search. Zero fields match every account.
+type AccountFilter struct {
+	Email         string     // Email matches the accounts whose email address contains it, ignoring case.
+	ID            uint64     // ID matches the account with the id.
+	CreatedAfter  *time.Time // CreatedAfter matches the accounts created at or after it.
+	CreatedBefore *time.Time // CreatedBefore matches the accounts created before it.
+	AfterID       uint64     // AfterID matches the accounts with a greater id, to continue a previous search.
+	Limit         int        // Limit is the maximum number of accounts returned.
+}
+
 // AccountStore provides functions for retrieving and saving account data.
 type AccountStore struct {
-	db *sqlx.DB
+	db executor
 }
 
 // NewAccountStore initializes and returns a new account store with the provided db handle.
-func NewAccountStore(db *sqlx.DB) *AccountStore {
+// Account queries are bounded by queryTimeout.
+func NewAccountStore(db *sqlx.DB, queryTimeout time.Duration) *AccountStore {
 	return &AccountStore{
-		db: db,
+		db: withTimeout(db, queryTimeout),
 	}
 }
 
-// CreateAccount saves a new account to storage.
-func (s *AccountStore) CreateAccount(account domain.Account) error {
-	query := `INSERT INTO accounts (email, password) VALUES ($1, $2)`
+// CreateAccount saves a new account to storage and returns the created account.
+func (s *AccountStore) CreateAccount(ctx context.Context, account domain.Account) (domain.Account, error) {
+	query := `INSERT INTO accounts (email, password) VALUES ($1, $2) RETURNING ` + accountColumns
+	var created domain.Account
 
-	_, err := s.db.Exec(query, account.Email, account.Password)
-	if err != nil {
+	if err := s.db.GetContext(ctx, &created, query, account.Email, account.Password); err != nil {
 		if err, ok := err.(pgx.PgError); ok && err.Code == pgerrcode.UniqueViolation {
-			return ErrAccountExists
+			return created, ErrAccountExists
 		}
-		return err
+		return created, err
 	}
 
-	return nil
+	return created, nil
 }
 
 // GetAccount retrieves an account from storage by email.
-func (s *AccountStore) GetAccount(email string) (domain.Account, error) {
-	query := `SELECT id, password FROM accounts WHERE email = $1`
+func (s *AccountStore) GetAccount(ctx context.Context, email string) (domain.Account, error) {
+	query := `SELECT ` + accountColumns + ` FROM accounts WHERE email = $1`
 	var account domain.Account
 
-	if err := s.db.Get(&account, query, email); err != nil {
+	if err := s.db.GetContext(ctx, &account, query, email); err != nil {
 		if err == sql.ErrNoRows {
 			return account, ErrAccountNotFound
 		}
@@ -58,3 +78,225 @@ func (s *AccountStore) GetAccount(email string) (domain.Account, error) {
 
 	return account, nil
 }
+
+// GetAccountByID retrieves an account from storage by id.
+func (s *AccountStore) GetAccountByID(ctx context.Context, id uint64) (domain.Account, error) {
+	query := `SELECT ` + accountColumns + ` FROM accounts WHERE id = $1`
+	var account domain.Account
+
+	if err := s.db.GetContext(ctx, &account, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return account, ErrAccountNotFound
+		}
+		return account, err
+	}
+
+	return account, nil
+}
+
+// UpdateAccount saves the email and password of an existing account to storage
+// and returns the updated account. Changing the email resets its verification.
+func (s *AccountStore) UpdateAccount(ctx context.Context, account domain.Account) (domain.Account, error) {
+	query := `UPDATE accounts SET email = $2, password = $3, updated_at = now(),
+		email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
+		verification_sent_at = CASE WHEN email = $2 THEN verification_sent_at ELSE NULL END
+		WHERE id = $1 RETURNING ` + accountColumns
+	var updated domain.Account
+
+	if err := s.db.GetContext(ctx, &updated, query, account.ID, account.Email, account.Password); err != nil {
+		if err == sql.ErrNoRows {
+			return updated, ErrAccountNotFound
+		}
+		if err, ok := err.(pgx.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+			return updated, ErrAccountExists
+		}
+		return updated, err
+	}
+
+	return updated, nil
+}
+
+// DeleteAccount removes an account from storage by id.
+func (s *AccountStore) DeleteAccount(ctx context.Context, id uint64) error {
+	query := `DELETE FROM accounts WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id)
+}
+
+// VerifyEmail marks the email address of an account as verified. The email must
+// match the current email of the account and must not already be verified;
+// otherwise ErrAccountNotFound is returned.
+func (s *AccountStore) VerifyEmail(ctx context.Context, id uint64, email string) error {
+	query := `UPDATE accounts SET email_verified_at = now(), updated_at = now()
+		WHERE id = $1 AND email = $2 AND email_verified_at IS NULL`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id, email)
+}
+
+// MarkVerificationSent records that a verification email is being sent to an unverified
+// account. It reports false without recording anything if the previous verification
+// email was sent less than interval ago, which throttles resending.
+func (s *AccountStore) MarkVerificationSent(ctx context.Context, id uint64, interval time.Duration) (bool, error) {
+	query := `UPDATE accounts SET verification_sent_at = now()
+		WHERE id = $1 AND email_verified_at IS NULL
+		AND (verification_sent_at IS NULL OR verification_sent_at < now() - make_interval(secs => $2))`
+
+	res, err := s.db.ExecContext(ctx, query, id, interval.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UpdatePassword saves a new hashed password for an account, which satisfies a
+// required password reset.
+func (s *AccountStore) UpdatePassword(ctx context.Context, id uint64, password string) error {
+	query := `UPDATE accounts SET password = $2, password_reset_required_at = NULL, updated_at = now() WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id, password)
+}
+
+// SetPendingTOTPSecret saves a TOTP secret for an account that has not enabled
+// two-factor authentication yet. The secret is not used to log in until it is
+// confirmed with EnableTOTP. ErrTOTPEnabled is returned if two-factor
+// authentication is already enabled.
+func (s *AccountStore) SetPendingTOTPSecret(ctx context.Context, id uint64, secret string) error {
+	query := `UPDATE accounts SET totp_secret = $2, totp_last_step = 0, updated_at = now()
+		WHERE id = $1 AND totp_enabled_at IS NULL`
+
+	return s.execOne(ctx, ErrTOTPEnabled, query, id, secret)
+}
+
+// EnableTOTP enables two-factor authentication for an account using its pending
+// TOTP secret. ErrTOTPEnabled is returned if two-factor authentication is already
+// enabled.
+func (s *AccountStore) EnableTOTP(ctx context.Context, id uint64) error {
+	query := `UPDATE accounts SET totp_enabled_at = now(), updated_at = now()
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`
+
+	return s.execOne(ctx, ErrTOTPEnabled, query, id)
+}
+
+// DisableTOTP disables two-factor authentication for an account and removes its TOTP secret.
+func (s *AccountStore) DisableTOTP(ctx context.Context, id uint64) error {
+	query := `UPDATE accounts SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = now()
+		WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id)
+}
+
+// UseTOTPStep records the time step of a TOTP code used by an account. It reports false
+// if a code for the same or a later time step was already used, which rejects replays.
+func (s *AccountStore) UseTOTPStep(ctx context.Context, id uint64, step int64) (bool, error) {
+	query := `UPDATE accounts SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+
+	err := s.execOne(ctx, ErrAccountNotFound, query, id, step)
+	if err == ErrAccountNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetRole changes the role of an account.
+func (s *AccountStore) SetRole(ctx context.Context, id uint64, role domain.Role) error {
+	query := `UPDATE accounts SET role = $2, updated_at = now() WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id, role)
+}
+
+// CountAccountsWithRole returns the number of accounts with a role.
+func (s *AccountStore) CountAccountsWithRole(ctx context.Context, role domain.Role) (int, error) {
+	query := `SELECT count(*) FROM accounts WHERE role = $1`
+
+	var count int
+	if err := s.db.GetContext(ctx, &count, query, role); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// SearchAccounts retrieves the accounts matching the filter, ordered by id.
+func (s *AccountStore) SearchAccounts(ctx context.Context, filter AccountFilter) ([]domain.Account, error) {
+	query := `SELECT ` + accountColumns + ` FROM accounts
+		WHERE ($1 = '' OR strpos(email, lower($1)) > 0)
+		AND ($2::bigint = 0 OR id = $2::bigint)
+		AND ($3::timestamptz IS NULL OR created_at >= $3::timestamptz)
+		AND ($4::timestamptz IS NULL OR created_at < $4::timestamptz)
+		AND id > $5
+		ORDER BY id LIMIT $6`
+	accounts := []domain.Account{}
+
+	err := s.db.SelectContext(ctx, &accounts, query, filter.Email, filter.ID, filter.CreatedAfter, filter.CreatedBefore, filter.AfterID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// SuspendAccount suspends an account until the provided time, replacing any previous
+// suspension.
+func (s *AccountStore) SuspendAccount(ctx context.Context, id uint64, until time.Time, reason string) error {
+	query := `UPDATE accounts SET suspended_until = $2, restriction_reason = $3, updated_at = now() WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id, until, reason)
+}
+
+// BanAccount permanently bans an account. Banning a banned account only changes the reason.
+func (s *AccountStore) BanAccount(ctx context.Context, id uint64, reason string) error {
+	query := `UPDATE accounts SET banned_at = COALESCE(banned_at, now()), restriction_reason = $2, updated_at = now()
+		WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id, reason)
+}
+
+// LiftRestrictions lifts the suspension and the ban of an account.
+func (s *AccountStore) LiftRestrictions(ctx context.Context, id uint64) error {
+	query := `UPDATE accounts SET suspended_until = NULL, banned_at = NULL, restriction_reason = NULL, updated_at = now()
+		WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id)
+}
+
+// RequirePasswordReset prevents an account from logging in until its password is
+// changed with UpdatePassword.
+func (s *AccountStore) RequirePasswordReset(ctx context.Context, id uint64) error {
+	query := `UPDATE accounts SET password_reset_required_at = COALESCE(password_reset_required_at, now()), updated_at = now()
+		WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id)
+}
+
+// RevokeSessions invalidates every auth token issued to an account until now by
+// incrementing its session version. Refresh tokens are revoked separately with
+// RevokeAccountRefreshTokens.
+func (s *AccountStore) RevokeSessions(ctx context.Context, id uint64) error {
+	query := `UPDATE accounts SET session_version = session_version + 1, updated_at = now() WHERE id = $1`
+
+	return s.execOne(ctx, ErrAccountNotFound, query, id)
+}
+
+// execOne executes a statement that is expected to affect exactly one row. If no
+// rows are affected, errNone is returned.
+func (s *AccountStore) execOne(ctx context.Context, errNone error, query string, args ...interface{}) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNone
+	}
+
+	return nil
+}
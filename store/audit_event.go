@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"time"
+	"untitled_rpg/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditEventStore provides functions for recording and retrieving the audit log.
+type AuditEventStore struct {
+	db executor
+}
+
+// NewAuditEventStore initializes and returns a new audit event store with the provided
+// db handle. Recording and listing audit events is bounded by queryTimeout.
+func NewAuditEventStore(db *sqlx.DB, queryTimeout time.Duration) *AuditEventStore {
+	return &AuditEventStore{
+		db: withTimeout(db, queryTimeout),
+	}
+}
+
+// RecordAuditEvent saves a new audit event and returns the created event.
+func (s *AuditEventStore) RecordAuditEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error) {
+	query := `INSERT INTO audit_events (actor_id, account_id, action, reason, request_id) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, actor_id, account_id, action, reason, request_id, created_at`
+	var created domain.AuditEvent
+
+	if err := s.db.GetContext(ctx, &created, query, event.ActorID, event.AccountID, event.Action, event.Reason, event.RequestID); err != nil {
+		return created, err
+	}
+
+	return created, nil
+}
+
+// ListAuditEvents retrieves the audit events of an account, most recent first.
+func (s *AuditEventStore) ListAuditEvents(ctx context.Context, accountID uint64) ([]domain.AuditEvent, error) {
+	query := `SELECT id, actor_id, account_id, action, reason, request_id, created_at FROM audit_events
+		WHERE account_id = $1 ORDER BY id DESC`
+	events := []domain.AuditEvent{}
+
+	if err := s.db.SelectContext(ctx, &events, query, accountID); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
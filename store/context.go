@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrCanceled is returned when a query is abandoned because its context was canceled,
+	// such as when the client closed the request.
+	ErrCanceled = errors.New("Query canceled")
+	// ErrTimeout is returned when a query does not complete before its deadline.
+	ErrTimeout = errors.New("Query timed out")
+)
+
+// executor is implemented by both *sqlx.DB and *sqlx.Tx, so that stores can run their
+// queries either directly or within a transaction.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// timeoutExecutor is an executor that bounds every query by a default timeout and
+// reports queries interrupted by their context as ErrCanceled or ErrTimeout.
+type timeoutExecutor struct {
+	executor executor      // executor runs the queries.
+	timeout  time.Duration // timeout is the maximum duration of a query, 0 for no timeout.
+}
+
+// withTimeout returns an executor running queries with executor, bounded by timeout.
+func withTimeout(executor executor, timeout time.Duration) executor {
+	return &timeoutExecutor{executor: executor, timeout: timeout}
+}
+
+// ExecContext executes a query without returning any rows.
+func (e *timeoutExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := e.context(ctx)
+	defer cancel()
+
+	res, err := e.executor.ExecContext(ctx, query, args...)
+	return res, contextError(ctx, err)
+}
+
+// GetContext executes a query returning a single row and scans it into dest.
+func (e *timeoutExecutor) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := e.context(ctx)
+	defer cancel()
+
+	return contextError(ctx, e.executor.GetContext(ctx, dest, query, args...))
+}
+
+// SelectContext executes a query and scans every row into dest, which must be a slice.
+func (e *timeoutExecutor) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := e.context(ctx)
+	defer cancel()
+
+	return contextError(ctx, e.executor.SelectContext(ctx, dest, query, args...))
+}
+
+// context returns the context of a query, bounded by the timeout if ctx has no earlier
+// deadline.
+func (e *timeoutExecutor) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, e.timeout)
+}
+
+// contextError returns ErrCanceled or ErrTimeout if err was caused by ctx being done,
+// and err otherwise. A query that found no rows completed, so sql.ErrNoRows is kept.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || err == sql.ErrNoRows {
+		return err
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return ErrCanceled
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+	return err
+}
+
+// checkContext returns ErrCanceled or ErrTimeout if ctx is done, and nil otherwise.
+func checkContext(ctx context.Context) error {
+	return contextError(ctx, ctx.Err())
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"untitled_rpg/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginAttemptStore provides functions for tracking failed login attempts.
+type LoginAttemptStore struct {
+	db executor
+}
+
+// NewLoginAttemptStore initializes and returns a new login attempt store with the
+// provided db handle. Lockout checks made during a login are bounded by queryTimeout.
+func NewLoginAttemptStore(db *sqlx.DB, queryTimeout time.Duration) *LoginAttemptStore {
+	return &LoginAttemptStore{
+		db: withTimeout(db, queryTimeout),
+	}
+}
+
+// GetLoginAttempt retrieves the failed login attempts of a key. A key without any
+// failed login attempts is returned with zero failures.
+func (s *LoginAttemptStore) GetLoginAttempt(ctx context.Context, key string) (domain.LoginAttempt, error) {
+	query := `SELECT key, failures, locked_until, last_failure_at FROM login_attempts WHERE key = $1`
+	var attempt domain.LoginAttempt
+
+	if err := s.db.GetContext(ctx, &attempt, query, key); err != nil {
+		if err == sql.ErrNoRows {
+			return domain.LoginAttempt{Key: key}, nil
+		}
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// RecordLoginFailure increments the failed login attempts of a key and locks the key
+// for the delay defined by the policy. Failures older than the policy's maximum delay
+// are forgotten. The lock is computed by the database within the same statement, so
+// concurrent failures cannot lose an update and the lock uses the same clock as the
+// time of the last failure.
+func (s *LoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, policy domain.LockoutPolicy) (domain.LoginAttempt, error) {
+	query := `INSERT INTO login_attempts AS attempt (key, failures, locked_until)
+		VALUES ($1, 1, CASE WHEN 1 >= $2
+			THEN now() + make_interval(secs => LEAST($3 * power(2, GREATEST(1 - $2, 0)), $4)) END)
+		ON CONFLICT (key) DO UPDATE SET (failures, locked_until, last_failure_at) = (
+			SELECT recent.failures,
+				CASE WHEN recent.failures >= $2
+					THEN now() + make_interval(secs => LEAST($3 * power(2, LEAST(GREATEST(recent.failures - $2, 0), 62)), $4))
+					ELSE attempt.locked_until END,
+				now()
+			FROM (SELECT CASE WHEN attempt.last_failure_at < now() - make_interval(secs => $4)
+				THEN 1 ELSE attempt.failures + 1 END AS failures) AS recent
+		)
+		RETURNING key, failures, locked_until, last_failure_at`
+	var attempt domain.LoginAttempt
+
+	err := s.db.GetContext(ctx, &attempt, query, key, policy.Threshold, policy.BaseDelay.Seconds(), policy.MaxDelay.Seconds())
+	return attempt, err
+}
+
+// ResetLoginAttempts forgets the failed login attempts of a key, unlocking it.
+func (s *LoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	query := `DELETE FROM login_attempts WHERE key = $1`
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// ListLockedLoginAttempts retrieves every key that is currently locked.
+func (s *LoginAttemptStore) ListLockedLoginAttempts(ctx context.Context) ([]domain.LoginAttempt, error) {
+	query := `SELECT key, failures, locked_until, last_failure_at FROM login_attempts
+		WHERE locked_until > now() ORDER BY locked_until DESC`
+	attempts := []domain.LoginAttempt{}
+
+	if err := s.db.SelectContext(ctx, &attempts, query); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// PruneLoginAttempts removes the failed login attempts of keys that are not locked and
+// whose last failure is older than maxAge.
+func (s *LoginAttemptStore) PruneLoginAttempts(ctx context.Context, maxAge time.Duration) error {
+	query := `DELETE FROM login_attempts WHERE last_failure_at < now() - make_interval(secs => $1)
+		AND (locked_until IS NULL OR locked_until <= now())`
+
+	_, err := s.db.ExecContext(ctx, query, maxAge.Seconds())
+	return err
+}
@@ -0,0 +1,972 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"untitled_rpg/domain"
+)
+
+// MemoryStore implements every repository interface by keeping data in memory. It
+// reproduces the semantics of the Postgres stores, including their errors and the
+// removal of the data of deleted accounts, so that it can replace them in tests.
+//
+// MemoryStore is also a Transactor. Transactions are serialized with each other and are
+// rolled back by restoring a snapshot of the data, but operations made outside of a
+// transaction are not isolated from transactions in progress.
+type MemoryStore struct {
+	mu   sync.Mutex
+	txMu sync.Mutex // txMu is held for the duration of a transaction.
+
+	accounts       map[uint64]domain.Account        // accounts maps ids to accounts.
+	accountEmails  map[string]uint64                // accountEmails maps email addresses to account ids.
+	refreshTokens  map[uint64]domain.RefreshToken   // refreshTokens maps ids to refresh tokens.
+	passwordResets map[uint64]domain.PasswordReset  // passwordResets maps ids to password resets.
+	recoveryCodes  map[uint64]map[string]*time.Time // recoveryCodes maps account ids to code hashes and when they were used.
+	loginAttempts  map[string]domain.LoginAttempt   // loginAttempts maps keys to failed login attempts.
+	auditEvents    []domain.AuditEvent              // auditEvents are the audit events, in the order they were recorded.
+	lastID         uint64                           // lastID is the last id assigned to a record.
+}
+
+// NewMemoryStore initializes and returns a new, empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts:       make(map[uint64]domain.Account),
+		accountEmails:  make(map[string]uint64),
+		refreshTokens:  make(map[uint64]domain.RefreshToken),
+		passwordResets: make(map[uint64]domain.PasswordReset),
+		recoveryCodes:  make(map[uint64]map[string]*time.Time),
+		loginAttempts:  make(map[string]domain.LoginAttempt),
+	}
+}
+
+// memoryTx is a Tx within a MemoryStore transaction.
+type memoryTx struct {
+	store *MemoryStore
+}
+
+// memorySnapshot is a copy of the data of a MemoryStore, restored to roll back a
+// transaction. Ids are not restored, like Postgres sequences are not rolled back.
+type memorySnapshot struct {
+	accounts       map[uint64]domain.Account
+	accountEmails  map[string]uint64
+	refreshTokens  map[uint64]domain.RefreshToken
+	passwordResets map[uint64]domain.PasswordReset
+	recoveryCodes  map[uint64]map[string]*time.Time
+	loginAttempts  map[string]domain.LoginAttempt
+	auditEvents    []domain.AuditEvent
+}
+
+// WithTx runs fn within a transaction.
+func (s *MemoryStore) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return s.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+// WithTxOptions runs fn within a transaction. Since transactions are serialized, the
+// options are ignored.
+func (s *MemoryStore) WithTxOptions(ctx context.Context, options TxOptions, fn func(tx Tx) error) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	return s.savepoint(fn)
+}
+
+// savepoint runs fn and restores the data as it was before if fn fails or panics.
+func (s *MemoryStore) savepoint(fn func(tx Tx) error) (err error) {
+	snapshot := s.snapshot()
+	defer func() {
+		if p := recover(); p != nil {
+			s.restore(snapshot)
+			panic(p)
+		}
+	}()
+
+	if err := fn(&memoryTx{store: s}); err != nil {
+		s.restore(snapshot)
+		return err
+	}
+
+	return nil
+}
+
+// snapshot returns a copy of the data.
+func (s *MemoryStore) snapshot() memorySnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := memorySnapshot{
+		accounts:       make(map[uint64]domain.Account, len(s.accounts)),
+		accountEmails:  make(map[string]uint64, len(s.accountEmails)),
+		refreshTokens:  make(map[uint64]domain.RefreshToken, len(s.refreshTokens)),
+		passwordResets: make(map[uint64]domain.PasswordReset, len(s.passwordResets)),
+		recoveryCodes:  make(map[uint64]map[string]*time.Time, len(s.recoveryCodes)),
+		loginAttempts:  make(map[string]domain.LoginAttempt, len(s.loginAttempts)),
+		auditEvents:    append([]domain.AuditEvent(nil), s.auditEvents...),
+	}
+	for k, v := range s.accounts {
+		snapshot.accounts[k] = v
+	}
+	for k, v := range s.accountEmails {
+		snapshot.accountEmails[k] = v
+	}
+	for k, v := range s.refreshTokens {
+		snapshot.refreshTokens[k] = v
+	}
+	for k, v := range s.passwordResets {
+		snapshot.passwordResets[k] = v
+	}
+	for k, codes := range s.recoveryCodes {
+		copied := make(map[string]*time.Time, len(codes))
+		for codeHash, usedAt := range codes {
+			copied[codeHash] = usedAt
+		}
+		snapshot.recoveryCodes[k] = copied
+	}
+	for k, v := range s.loginAttempts {
+		snapshot.loginAttempts[k] = v
+	}
+
+	return snapshot
+}
+
+// restore replaces the data with a snapshot.
+func (s *MemoryStore) restore(snapshot memorySnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts = snapshot.accounts
+	s.accountEmails = snapshot.accountEmails
+	s.refreshTokens = snapshot.refreshTokens
+	s.passwordResets = snapshot.passwordResets
+	s.recoveryCodes = snapshot.recoveryCodes
+	s.loginAttempts = snapshot.loginAttempts
+	s.auditEvents = snapshot.auditEvents
+}
+
+// WithTx runs fn within a savepoint of the transaction.
+func (t *memoryTx) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return t.store.savepoint(fn)
+}
+
+// WithTxOptions runs fn within a savepoint of the transaction. The options are ignored.
+func (t *memoryTx) WithTxOptions(ctx context.Context, options TxOptions, fn func(tx Tx) error) error {
+	return t.store.savepoint(fn)
+}
+
+// Accounts returns the account repository of the transaction.
+func (t *memoryTx) Accounts() AccountRepository {
+	return t.store
+}
+
+// RefreshTokens returns the refresh token repository of the transaction.
+func (t *memoryTx) RefreshTokens() RefreshTokenRepository {
+	return t.store
+}
+
+// PasswordResets returns the password reset repository of the transaction.
+func (t *memoryTx) PasswordResets() PasswordResetRepository {
+	return t.store
+}
+
+// RecoveryCodes returns the recovery code repository of the transaction.
+func (t *memoryTx) RecoveryCodes() RecoveryCodeRepository {
+	return t.store
+}
+
+// LoginAttempts returns the login attempt repository of the transaction.
+func (t *memoryTx) LoginAttempts() LoginAttemptRepository {
+	return t.store
+}
+
+// AuditEvents returns the audit event repository of the transaction.
+func (t *memoryTx) AuditEvents() AuditEventRepository {
+	return t.store
+}
+
+// newMeta returns the metadata of a new record created at now.
+func (s *MemoryStore) newMeta(now time.Time) domain.Meta {
+	s.lastID++
+	return domain.Meta{ID: s.lastID, CreatedAt: &now, UpdatedAt: &now}
+}
+
+// CreateAccount saves a new account and returns the created account.
+func (s *MemoryStore) CreateAccount(ctx context.Context, account domain.Account) (domain.Account, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accountEmails[account.Email]; ok {
+		return domain.Account{}, ErrAccountExists
+	}
+
+	created := domain.Account{
+		Meta:     s.newMeta(time.Now()),
+		Email:    account.Email,
+		Password: account.Password,
+		Role:     domain.RolePlayer,
+	}
+	s.accounts[created.ID] = created
+	s.accountEmails[created.Email] = created.ID
+
+	return created, nil
+}
+
+// GetAccount retrieves an account by email.
+func (s *MemoryStore) GetAccount(ctx context.Context, email string) (domain.Account, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.accountEmails[email]
+	if !ok {
+		return domain.Account{}, ErrAccountNotFound
+	}
+
+	return s.accounts[id], nil
+}
+
+// GetAccountByID retrieves an account by id.
+func (s *MemoryStore) GetAccountByID(ctx context.Context, id uint64) (domain.Account, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return domain.Account{}, ErrAccountNotFound
+	}
+
+	return account, nil
+}
+
+// UpdateAccount saves the email and password of an existing account and returns the
+// updated account. Changing the email resets its verification.
+func (s *MemoryStore) UpdateAccount(ctx context.Context, account domain.Account) (domain.Account, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, ok := s.accounts[account.ID]
+	if !ok {
+		return domain.Account{}, ErrAccountNotFound
+	}
+	if id, ok := s.accountEmails[account.Email]; ok && id != account.ID {
+		return domain.Account{}, ErrAccountExists
+	}
+
+	if updated.Email != account.Email {
+		delete(s.accountEmails, updated.Email)
+		s.accountEmails[account.Email] = updated.ID
+		updated.EmailVerifiedAt = nil
+		updated.VerificationSentAt = nil
+	}
+	now := time.Now()
+	updated.Email = account.Email
+	updated.Password = account.Password
+	updated.UpdatedAt = &now
+	s.accounts[updated.ID] = updated
+
+	return updated, nil
+}
+
+// DeleteAccount removes an account by id, along with its refresh tokens, password
+// resets and recovery codes.
+func (s *MemoryStore) DeleteAccount(ctx context.Context, id uint64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return ErrAccountNotFound
+	}
+
+	delete(s.accounts, id)
+	delete(s.accountEmails, account.Email)
+	delete(s.recoveryCodes, id)
+	for tokenID, token := range s.refreshTokens {
+		if token.AccountID == id {
+			delete(s.refreshTokens, tokenID)
+		}
+	}
+	for resetID, reset := range s.passwordResets {
+		if reset.AccountID == id {
+			delete(s.passwordResets, resetID)
+		}
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email address of an account as verified. The email must
+// match the current email of the account and must not already be verified;
+// otherwise ErrAccountNotFound is returned.
+func (s *MemoryStore) VerifyEmail(ctx context.Context, id uint64, email string) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		if account.Email != email || account.EmailVerifiedAt != nil {
+			return false
+		}
+		account.EmailVerifiedAt = now
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// MarkVerificationSent records that a verification email is being sent to an unverified
+// account. It reports false without recording anything if the previous verification
+// email was sent less than interval ago.
+func (s *MemoryStore) MarkVerificationSent(ctx context.Context, id uint64, interval time.Duration) (bool, error) {
+	err := s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		if account.EmailVerifiedAt != nil {
+			return false
+		}
+		if account.VerificationSentAt != nil && !account.VerificationSentAt.Before(now.Add(-interval)) {
+			return false
+		}
+		account.VerificationSentAt = now
+		return true
+	})
+	if err == ErrAccountNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdatePassword saves a new hashed password for an account, which satisfies a
+// required password reset.
+func (s *MemoryStore) UpdatePassword(ctx context.Context, id uint64, password string) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.Password = password
+		account.PasswordResetRequiredAt = nil
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// SetPendingTOTPSecret saves a TOTP secret for an account that has not enabled
+// two-factor authentication yet. ErrTOTPEnabled is returned if two-factor
+// authentication is already enabled.
+func (s *MemoryStore) SetPendingTOTPSecret(ctx context.Context, id uint64, secret string) error {
+	return s.updateAccount(ctx, ErrTOTPEnabled, id, func(account *domain.Account, now *time.Time) bool {
+		if account.TOTPEnabledAt != nil {
+			return false
+		}
+		account.TOTPSecret = &secret
+		account.TOTPLastStep = 0
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// EnableTOTP enables two-factor authentication for an account using its pending
+// TOTP secret. ErrTOTPEnabled is returned if two-factor authentication is already
+// enabled.
+func (s *MemoryStore) EnableTOTP(ctx context.Context, id uint64) error {
+	return s.updateAccount(ctx, ErrTOTPEnabled, id, func(account *domain.Account, now *time.Time) bool {
+		if account.TOTPSecret == nil || account.TOTPEnabledAt != nil {
+			return false
+		}
+		account.TOTPEnabledAt = now
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// DisableTOTP disables two-factor authentication for an account and removes its TOTP secret.
+func (s *MemoryStore) DisableTOTP(ctx context.Context, id uint64) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.TOTPSecret = nil
+		account.TOTPEnabledAt = nil
+		account.TOTPLastStep = 0
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// UseTOTPStep records the time step of a TOTP code used by an account. It reports false
+// if a code for the same or a later time step was already used.
+func (s *MemoryStore) UseTOTPStep(ctx context.Context, id uint64, step int64) (bool, error) {
+	err := s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		if account.TOTPLastStep >= step {
+			return false
+		}
+		account.TOTPLastStep = step
+		return true
+	})
+	if err == ErrAccountNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetRole changes the role of an account.
+func (s *MemoryStore) SetRole(ctx context.Context, id uint64, role domain.Role) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.Role = role
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// CountAccountsWithRole returns the number of accounts with a role.
+func (s *MemoryStore) CountAccountsWithRole(ctx context.Context, role domain.Role) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, account := range s.accounts {
+		if account.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SearchAccounts retrieves the accounts matching the filter, ordered by id.
+func (s *MemoryStore) SearchAccounts(ctx context.Context, filter AccountFilter) ([]domain.Account, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := strings.ToLower(filter.Email)
+	accounts := []domain.Account{}
+	for _, account := range s.accounts {
+		switch {
+		case !strings.Contains(account.Email, email),
+			filter.ID != 0 && account.ID != filter.ID,
+			filter.CreatedAfter != nil && account.CreatedAt.Before(*filter.CreatedAfter),
+			filter.CreatedBefore != nil && !account.CreatedAt.Before(*filter.CreatedBefore),
+			account.ID <= filter.AfterID:
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+	if len(accounts) > filter.Limit {
+		accounts = accounts[:filter.Limit]
+	}
+
+	return accounts, nil
+}
+
+// SuspendAccount suspends an account until the provided time, replacing any previous
+// suspension.
+func (s *MemoryStore) SuspendAccount(ctx context.Context, id uint64, until time.Time, reason string) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.SuspendedUntil = &until
+		account.RestrictionReason = &reason
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// BanAccount permanently bans an account. Banning a banned account only changes the reason.
+func (s *MemoryStore) BanAccount(ctx context.Context, id uint64, reason string) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		if account.BannedAt == nil {
+			account.BannedAt = now
+		}
+		account.RestrictionReason = &reason
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// LiftRestrictions lifts the suspension and the ban of an account.
+func (s *MemoryStore) LiftRestrictions(ctx context.Context, id uint64) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.SuspendedUntil = nil
+		account.BannedAt = nil
+		account.RestrictionReason = nil
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// RequirePasswordReset prevents an account from logging in until its password is
+// changed with UpdatePassword.
+func (s *MemoryStore) RequirePasswordReset(ctx context.Context, id uint64) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		if account.PasswordResetRequiredAt == nil {
+			account.PasswordResetRequiredAt = now
+		}
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// RevokeSessions invalidates every auth token issued to an account until now.
+func (s *MemoryStore) RevokeSessions(ctx context.Context, id uint64) error {
+	return s.updateAccount(ctx, ErrAccountNotFound, id, func(account *domain.Account, now *time.Time) bool {
+		account.SessionVersion++
+		account.UpdatedAt = now
+		return true
+	})
+}
+
+// updateAccount applies update to a copy of an account and saves the copy if update
+// reports true. If the account does not exist or update reports false, errNone is
+// returned, like execOne does when no rows are affected.
+func (s *MemoryStore) updateAccount(ctx context.Context, errNone error, id uint64, update func(account *domain.Account, now *time.Time) bool) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return errNone
+	}
+
+	now := time.Now()
+	if !update(&account, &now) {
+		return errNone
+	}
+	s.accounts[id] = account
+
+	return nil
+}
+
+// CreateRefreshToken saves a new refresh token.
+func (s *MemoryStore) CreateRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta := s.newMeta(time.Now())
+	s.refreshTokens[meta.ID] = domain.RefreshToken{
+		Meta:      meta,
+		AccountID: token.AccountID,
+		FamilyID:  token.FamilyID,
+		TokenHash: token.TokenHash,
+		ExpiresAt: token.ExpiresAt,
+	}
+
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by the hash of the token.
+func (s *MemoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (domain.RefreshToken, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.RefreshToken{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+
+	return domain.RefreshToken{}, ErrRefreshTokenNotFound
+}
+
+// RevokeRefreshToken revokes a single refresh token. It reports false if the token
+// was already revoked.
+func (s *MemoryStore) RevokeRefreshToken(ctx context.Context, id uint64) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.revokeRefreshTokens(func(token domain.RefreshToken) bool {
+		return token.ID == id
+	})
+
+	return n == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token belonging to a family.
+func (s *MemoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(token domain.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+
+	return nil
+}
+
+// RevokeAccountRefreshTokens revokes every refresh token of an account.
+func (s *MemoryStore) RevokeAccountRefreshTokens(ctx context.Context, accountID uint64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(token domain.RefreshToken) bool {
+		return token.AccountID == accountID
+	})
+
+	return nil
+}
+
+// RevokeOtherRefreshTokens revokes every refresh token of an account outside of a family.
+func (s *MemoryStore) RevokeOtherRefreshTokens(ctx context.Context, accountID uint64, familyID string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(token domain.RefreshToken) bool {
+		return token.AccountID == accountID && token.FamilyID != familyID
+	})
+
+	return nil
+}
+
+// IsSessionActive reports whether any refresh token belonging to a family is not revoked.
+func (s *MemoryStore) IsSessionActive(ctx context.Context, familyID string) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// revokeRefreshTokens revokes every unrevoked refresh token matched by match and
+// returns the number of refresh tokens revoked. The caller must hold the lock.
+func (s *MemoryStore) revokeRefreshTokens(match func(token domain.RefreshToken) bool) int {
+	now := time.Now()
+	n := 0
+	for id, token := range s.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			token.UpdatedAt = &now
+			s.refreshTokens[id] = token
+			n++
+		}
+	}
+	return n
+}
+
+// CreatePasswordReset saves a new password reset.
+func (s *MemoryStore) CreatePasswordReset(ctx context.Context, reset domain.PasswordReset) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta := s.newMeta(time.Now())
+	s.passwordResets[meta.ID] = domain.PasswordReset{
+		Meta:      meta,
+		AccountID: reset.AccountID,
+		TokenHash: reset.TokenHash,
+		ExpiresAt: reset.ExpiresAt,
+	}
+
+	return nil
+}
+
+// GetPasswordReset retrieves a password reset by the hash of its token.
+func (s *MemoryStore) GetPasswordReset(ctx context.Context, tokenHash string) (domain.PasswordReset, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.PasswordReset{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reset := range s.passwordResets {
+		if reset.TokenHash == tokenHash {
+			return reset, nil
+		}
+	}
+
+	return domain.PasswordReset{}, ErrPasswordResetNotFound
+}
+
+// UsePasswordReset marks a password reset as used along with every other unused
+// password reset of the same account. It reports false if the password reset was
+// already used.
+func (s *MemoryStore) UsePasswordReset(ctx context.Context, reset domain.PasswordReset) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.passwordResets[reset.ID]
+	if !ok || current.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	for id, other := range s.passwordResets {
+		if id == reset.ID || (other.AccountID == reset.AccountID && other.UsedAt == nil) {
+			other.UsedAt = &now
+			other.UpdatedAt = &now
+			s.passwordResets[id] = other
+		}
+	}
+
+	return true, nil
+}
+
+// ReplaceRecoveryCodes removes every recovery code of an account and saves the
+// provided recovery code hashes in their place.
+func (s *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, accountID uint64, codeHashes []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := make(map[string]*time.Time, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes[codeHash] = nil
+	}
+	s.recoveryCodes[accountID] = codes
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of an account as used. It reports
+// false if the account has no unused recovery code with the provided hash.
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, accountID uint64, codeHash string) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usedAt, ok := s.recoveryCodes[accountID][codeHash]
+	if !ok || usedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	s.recoveryCodes[accountID][codeHash] = &now
+
+	return true, nil
+}
+
+// DeleteRecoveryCodes removes every recovery code of an account.
+func (s *MemoryStore) DeleteRecoveryCodes(ctx context.Context, accountID uint64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.recoveryCodes, accountID)
+
+	return nil
+}
+
+// GetLoginAttempt retrieves the failed login attempts of a key. A key without any
+// failed login attempts is returned with zero failures.
+func (s *MemoryStore) GetLoginAttempt(ctx context.Context, key string) (domain.LoginAttempt, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.LoginAttempt{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		return domain.LoginAttempt{Key: key}, nil
+	}
+
+	return attempt, nil
+}
+
+// RecordLoginFailure increments the failed login attempts of a key and locks the key
+// for the delay defined by the policy. Failures older than the policy's maximum delay
+// are forgotten.
+func (s *MemoryStore) RecordLoginFailure(ctx context.Context, key string, policy domain.LockoutPolicy) (domain.LoginAttempt, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.LoginAttempt{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		attempt = domain.LoginAttempt{Key: key}
+	}
+	if ok && attempt.LastFailureAt.Before(now.Add(-policy.MaxDelay)) {
+		attempt.Failures = 1
+	} else {
+		attempt.Failures++
+	}
+	attempt.LastFailureAt = now
+
+	if delay := policy.Delay(attempt.Failures); delay > 0 {
+		lockedUntil := now.Add(delay)
+		attempt.LockedUntil = &lockedUntil
+	}
+	s.loginAttempts[key] = attempt
+
+	return attempt, nil
+}
+
+// ResetLoginAttempts forgets the failed login attempts of a key, unlocking it.
+func (s *MemoryStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+
+	return nil
+}
+
+// ListLockedLoginAttempts retrieves every key that is currently locked.
+func (s *MemoryStore) ListLockedLoginAttempts(ctx context.Context) ([]domain.LoginAttempt, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	attempts := []domain.LoginAttempt{}
+	for _, attempt := range s.loginAttempts {
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].LockedUntil.After(*attempts[j].LockedUntil)
+	})
+
+	return attempts, nil
+}
+
+// PruneLoginAttempts removes the failed login attempts of keys that are not locked and
+// whose last failure is older than maxAge.
+func (s *MemoryStore) PruneLoginAttempts(ctx context.Context, maxAge time.Duration) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, attempt := range s.loginAttempts {
+		if attempt.LastFailureAt.Before(now.Add(-maxAge)) && attempt.LockedFor() == 0 {
+			delete(s.loginAttempts, key)
+		}
+	}
+
+	return nil
+}
+
+// RecordAuditEvent saves a new audit event and returns the created event.
+func (s *MemoryStore) RecordAuditEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return domain.AuditEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	event.ID = s.lastID
+	event.CreatedAt = time.Now()
+	s.auditEvents = append(s.auditEvents, event)
+
+	return event, nil
+}
+
+// ListAuditEvents retrieves the audit events of an account, most recent first.
+func (s *MemoryStore) ListAuditEvents(ctx context.Context, accountID uint64) ([]domain.AuditEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []domain.AuditEvent{}
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if s.auditEvents[i].AccountID == accountID {
+			events = append(events, s.auditEvents[i])
+		}
+	}
+
+	return events, nil
+}
+
+var (
+	_ AccountRepository       = (*MemoryStore)(nil)
+	_ RefreshTokenRepository  = (*MemoryStore)(nil)
+	_ PasswordResetRepository = (*MemoryStore)(nil)
+	_ RecoveryCodeRepository  = (*MemoryStore)(nil)
+	_ LoginAttemptRepository  = (*MemoryStore)(nil)
+	_ AuditEventRepository    = (*MemoryStore)(nil)
+	_ Transactor              = (*MemoryStore)(nil)
+	_ Tx                      = (*memoryTx)(nil)
+)
@@ -0,0 +1,22 @@
+package store_test
+
+import (
+	"testing"
+	"untitled_rpg/store"
+	"untitled_rpg/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Repositories {
+		s := store.NewMemoryStore()
+		return storetest.Repositories{
+			Transactor:     s,
+			Accounts:       s,
+			RefreshTokens:  s,
+			PasswordResets: s,
+			RecoveryCodes:  s,
+			LoginAttempts:  s,
+			AuditEvents:    s,
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"untitled_rpg/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrPasswordResetNotFound is returned when no password reset is found.
+	ErrPasswordResetNotFound = errors.New("Password reset not found")
+)
+
+// PasswordResetStore provides functions for retrieving and saving password resets.
+type PasswordResetStore struct {
+	db executor
+}
+
+// NewPasswordResetStore initializes and returns a new password reset store with the
+// provided db handle. Its queries are bounded by queryTimeout.
+func NewPasswordResetStore(db *sqlx.DB, queryTimeout time.Duration) *PasswordResetStore {
+	return &PasswordResetStore{
+		db: withTimeout(db, queryTimeout),
+	}
+}
+
+// CreatePasswordReset saves a new password reset to storage.
+func (s *PasswordResetStore) CreatePasswordReset(ctx context.Context, reset domain.PasswordReset) error {
+	query := `INSERT INTO password_resets (account_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+
+	_, err := s.db.ExecContext(ctx, query, reset.AccountID, reset.TokenHash, reset.ExpiresAt)
+	return err
+}
+
+// GetPasswordReset retrieves a password reset from storage by the hash of its token.
+func (s *PasswordResetStore) GetPasswordReset(ctx context.Context, tokenHash string) (domain.PasswordReset, error) {
+	query := `SELECT id, account_id, token_hash, expires_at, used_at, created_at, updated_at
+		FROM password_resets WHERE token_hash = $1`
+	var reset domain.PasswordReset
+
+	if err := s.db.GetContext(ctx, &reset, query, tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return reset, ErrPasswordResetNotFound
+		}
+		return reset, err
+	}
+
+	return reset, nil
+}
+
+// UsePasswordReset marks a password reset as used along with every other unused
+// password reset of the same account. It reports false if the password reset was
+// already used.
+func (s *PasswordResetStore) UsePasswordReset(ctx context.Context, reset domain.PasswordReset) (bool, error) {
+	query := `UPDATE password_resets SET used_at = now(), updated_at = now() WHERE id = $1 AND used_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, reset.ID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	query = `UPDATE password_resets SET used_at = now(), updated_at = now() WHERE account_id = $1 AND used_at IS NULL`
+	if _, err := s.db.ExecContext(ctx, query, reset.AccountID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
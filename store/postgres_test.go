@@ -0,0 +1,53 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"untitled_rpg/logger"
+	"untitled_rpg/migrate"
+	"untitled_rpg/store"
+	"untitled_rpg/store/storetest"
+
+	_ "github.com/jackc/pgx/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// TestPostgresStores runs the conformance suite against the database at
+// TEST_DATABASE_URL. Every table used by the stores is truncated between tests, so it
+// must not point to a database holding data worth keeping.
+func TestPostgresStores(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sqlx.Open("pgx", url)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to ping database: %v", err)
+	}
+	if err := migrate.Migrate(context.Background(), logger.Nop(), url); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) storetest.Repositories {
+		query := `TRUNCATE accounts, refresh_tokens, password_resets, recovery_codes, login_attempts, audit_events RESTART IDENTITY CASCADE`
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Failed to truncate tables: %v", err)
+		}
+
+		return storetest.Repositories{
+			Transactor:     store.NewTxManager(db, store.TxOptions{}, 3, 0),
+			Accounts:       store.NewAccountStore(db, 0),
+			RefreshTokens:  store.NewRefreshTokenStore(db, 0),
+			PasswordResets: store.NewPasswordResetStore(db, 0),
+			RecoveryCodes:  store.NewRecoveryCodeStore(db, 0),
+			LoginAttempts:  store.NewLoginAttemptStore(db, 0),
+			AuditEvents:    store.NewAuditEventStore(db, 0),
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RecoveryCodeStore provides functions for saving and consuming two-factor
+// authentication recovery codes.
+type RecoveryCodeStore struct {
+	db executor
+}
+
+// NewRecoveryCodeStore initializes and returns a new recovery code store with the
+// provided db handle, bounding its queries by queryTimeout.
+func NewRecoveryCodeStore(db *sqlx.DB, queryTimeout time.Duration) *RecoveryCodeStore {
+	return &RecoveryCodeStore{
+		db: withTimeout(db, queryTimeout),
+	}
+}
+
+// ReplaceRecoveryCodes removes every recovery code of an account and saves the
+// provided recovery code hashes in their place.
+func (s *RecoveryCodeStore) ReplaceRecoveryCodes(ctx context.Context, accountID uint64, codeHashes []string) error {
+	if err := s.DeleteRecoveryCodes(ctx, accountID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO recovery_codes (account_id, code_hash) VALUES ($1, $2)`
+	for _, codeHash := range codeHashes {
+		if _, err := s.db.ExecContext(ctx, query, accountID, codeHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of an account as used. It reports
+// false if the account has no unused recovery code with the provided hash.
+func (s *RecoveryCodeStore) UseRecoveryCode(ctx context.Context, accountID uint64, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = now(), updated_at = now()
+		WHERE account_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, accountID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// DeleteRecoveryCodes removes every recovery code of an account.
+func (s *RecoveryCodeStore) DeleteRecoveryCodes(ctx context.Context, accountID uint64) error {
+	query := `DELETE FROM recovery_codes WHERE account_id = $1`
+
+	_, err := s.db.ExecContext(ctx, query, accountID)
+	return err
+}
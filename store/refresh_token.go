@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"untitled_rpg/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned when no refresh token is found.
+	ErrRefreshTokenNotFound = errors.New("Refresh token not found")
+)
+
+// RefreshTokenStore provides functions for retrieving and saving refresh tokens.
+type RefreshTokenStore struct {
+	db executor
+}
+
+// NewRefreshTokenStore initializes and returns a new refresh token store with the
+// provided db handle. Refresh token lookups and revocations are bounded by queryTimeout.
+func NewRefreshTokenStore(db *sqlx.DB, queryTimeout time.Duration) *RefreshTokenStore {
+	return &RefreshTokenStore{
+		db: withTimeout(db, queryTimeout),
+	}
+}
+
+// CreateRefreshToken saves a new refresh token to storage.
+func (s *RefreshTokenStore) CreateRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (account_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+
+	_, err := s.db.ExecContext(ctx, query, token.AccountID, token.FamilyID, token.TokenHash, token.ExpiresAt)
+	return err
+}
+
+// GetRefreshToken retrieves a refresh token from storage by the hash of the token.
+func (s *RefreshTokenStore) GetRefreshToken(ctx context.Context, tokenHash string) (domain.RefreshToken, error) {
+	query := `SELECT id, account_id, family_id, token_hash, expires_at, revoked_at, created_at, updated_at
+		FROM refresh_tokens WHERE token_hash = $1`
+	var token domain.RefreshToken
+
+	if err := s.db.GetContext(ctx, &token, query, tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return token, ErrRefreshTokenNotFound
+		}
+		return token, err
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken revokes a single refresh token. It reports false if the token
+// was already revoked, which happens when the same token is used twice.
+func (s *RefreshTokenStore) RevokeRefreshToken(ctx context.Context, id uint64) (bool, error) {
+	query := `UPDATE refresh_tokens SET revoked_at = now(), updated_at = now() WHERE id = $1 AND revoked_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token belonging to a family,
+// ending the session.
+func (s *RefreshTokenStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now(), updated_at = now() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	_, err := s.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// RevokeAccountRefreshTokens revokes every refresh token of an account, ending all
+// of its sessions.
+func (s *RefreshTokenStore) RevokeAccountRefreshTokens(ctx context.Context, accountID uint64) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now(), updated_at = now() WHERE account_id = $1 AND revoked_at IS NULL`
+
+	_, err := s.db.ExecContext(ctx, query, accountID)
+	return err
+}
+
+// RevokeOtherRefreshTokens revokes every refresh token of an account outside of a family,
+// ending all of its sessions but one.
+func (s *RefreshTokenStore) RevokeOtherRefreshTokens(ctx context.Context, accountID uint64, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now(), updated_at = now()
+		WHERE account_id = $1 AND family_id <> $2 AND revoked_at IS NULL`
+
+	_, err := s.db.ExecContext(ctx, query, accountID, familyID)
+	return err
+}
+
+// IsSessionActive reports whether any refresh token belonging to a family is not
+// revoked. Auth tokens issued within a session are rejected once it is no longer active.
+func (s *RefreshTokenStore) IsSessionActive(ctx context.Context, familyID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL)`
+	var active bool
+
+	if err := s.db.GetContext(ctx, &active, query, familyID); err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
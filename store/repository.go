@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"time"
+	"untitled_rpg/domain"
+)
+
+// AccountRepository defines an interface to the storage of accounts.
+type AccountRepository interface {
+	// CreateAccount saves a new account and returns the created account. ErrAccountExists
+	// is returned if an account already exists with the same email address.
+	CreateAccount(ctx context.Context, account domain.Account) (domain.Account, error)
+	// GetAccount retrieves an account by email. ErrAccountNotFound is returned if no
+	// account has the email address.
+	GetAccount(ctx context.Context, email string) (domain.Account, error)
+	// GetAccountByID retrieves an account by id. ErrAccountNotFound is returned if no
+	// account has the id.
+	GetAccountByID(ctx context.Context, id uint64) (domain.Account, error)
+	// UpdateAccount saves the email and password of an existing account and returns the
+	// updated account. Changing the email resets its verification.
+	UpdateAccount(ctx context.Context, account domain.Account) (domain.Account, error)
+	// DeleteAccount removes an account by id, along with its refresh tokens, password
+	// resets and recovery codes.
+	DeleteAccount(ctx context.Context, id uint64) error
+	// VerifyEmail marks the email address of an unverified account as verified.
+	VerifyEmail(ctx context.Context, id uint64, email string) error
+	// MarkVerificationSent records that a verification email is being sent to an
+	// unverified account, unless the previous one was sent less than interval ago.
+	MarkVerificationSent(ctx context.Context, id uint64, interval time.Duration) (bool, error)
+	// UpdatePassword saves a new hashed password for an account, which satisfies a
+	// required password reset.
+	UpdatePassword(ctx context.Context, id uint64, password string) error
+	// SetPendingTOTPSecret saves a TOTP secret for an account that has not enabled
+	// two-factor authentication yet.
+	SetPendingTOTPSecret(ctx context.Context, id uint64, secret string) error
+	// EnableTOTP enables two-factor authentication for an account using its pending
+	// TOTP secret.
+	EnableTOTP(ctx context.Context, id uint64) error
+	// DisableTOTP disables two-factor authentication for an account and removes its TOTP secret.
+	DisableTOTP(ctx context.Context, id uint64) error
+	// UseTOTPStep records the time step of a TOTP code used by an account, reporting
+	// false if a code for the same or a later time step was already used.
+	UseTOTPStep(ctx context.Context, id uint64, step int64) (bool, error)
+	// SetRole changes the role of an account.
+	SetRole(ctx context.Context, id uint64, role domain.Role) error
+	// CountAccountsWithRole returns the number of accounts with a role.
+	CountAccountsWithRole(ctx context.Context, role domain.Role) (int, error)
+	// SearchAccounts retrieves the accounts matching the filter, ordered by id.
+	SearchAccounts(ctx context.Context, filter AccountFilter) ([]domain.Account, error)
+	// SuspendAccount suspends an account until the provided time, replacing any
+	// previous suspension.
+	SuspendAccount(ctx context.Context, id uint64, until time.Time, reason string) error
+	// BanAccount permanently bans an account.
+	BanAccount(ctx context.Context, id uint64, reason string) error
+	// LiftRestrictions lifts the suspension and the ban of an account.
+	LiftRestrictions(ctx context.Context, id uint64) error
+	// RequirePasswordReset prevents an account from logging in until its password is
+	// changed with UpdatePassword.
+	RequirePasswordReset(ctx context.Context, id uint64) error
+	// RevokeSessions invalidates every auth token issued to an account until now by
+	// incrementing its session version.
+	RevokeSessions(ctx context.Context, id uint64) error
+}
+
+// RefreshTokenRepository defines an interface to the storage of refresh tokens.
+type RefreshTokenRepository interface {
+	// CreateRefreshToken saves a new refresh token.
+	CreateRefreshToken(ctx context.Context, token domain.RefreshToken) error
+	// GetRefreshToken retrieves a refresh token by the hash of the token.
+	// ErrRefreshTokenNotFound is returned if no refresh token has the hash.
+	GetRefreshToken(ctx context.Context, tokenHash string) (domain.RefreshToken, error)
+	// RevokeRefreshToken revokes a single refresh token, reporting false if it was
+	// already revoked.
+	RevokeRefreshToken(ctx context.Context, id uint64) (bool, error)
+	// RevokeRefreshTokenFamily revokes every refresh token belonging to a family.
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	// RevokeAccountRefreshTokens revokes every refresh token of an account.
+	RevokeAccountRefreshTokens(ctx context.Context, accountID uint64) error
+	// RevokeOtherRefreshTokens revokes every refresh token of an account that does not
+	// belong to the provided family.
+	RevokeOtherRefreshTokens(ctx context.Context, accountID uint64, familyID string) error
+	// IsSessionActive reports whether the session of a refresh token family is still
+	// active, that is whether any refresh token of the family is not revoked.
+	IsSessionActive(ctx context.Context, familyID string) (bool, error)
+}
+
+// PasswordResetRepository defines an interface to the storage of password resets.
+type PasswordResetRepository interface {
+	// CreatePasswordReset saves a new password reset.
+	CreatePasswordReset(ctx context.Context, reset domain.PasswordReset) error
+	// GetPasswordReset retrieves a password reset by the hash of its token.
+	// ErrPasswordResetNotFound is returned if no password reset has the hash.
+	GetPasswordReset(ctx context.Context, tokenHash string) (domain.PasswordReset, error)
+	// UsePasswordReset marks a password reset as used along with every other unused
+	// password reset of the same account, reporting false if it was already used.
+	UsePasswordReset(ctx context.Context, reset domain.PasswordReset) (bool, error)
+}
+
+// RecoveryCodeRepository defines an interface to the storage of two-factor
+// authentication recovery codes.
+type RecoveryCodeRepository interface {
+	// ReplaceRecoveryCodes replaces every recovery code of an account with the provided
+	// recovery code hashes.
+	ReplaceRecoveryCodes(ctx context.Context, accountID uint64, codeHashes []string) error
+	// UseRecoveryCode marks an unused recovery code of an account as used, reporting
+	// false if the account has no unused recovery code with the hash.
+	UseRecoveryCode(ctx context.Context, accountID uint64, codeHash string) (bool, error)
+	// DeleteRecoveryCodes removes every recovery code of an account.
+	DeleteRecoveryCodes(ctx context.Context, accountID uint64) error
+}
+
+// LoginAttemptRepository defines an interface to the storage of failed login attempts.
+type LoginAttemptRepository interface {
+	// GetLoginAttempt retrieves the failed login attempts of a key, with zero failures
+	// if there are none.
+	GetLoginAttempt(ctx context.Context, key string) (domain.LoginAttempt, error)
+	// RecordLoginFailure increments the failed login attempts of a key and locks the key
+	// for the delay defined by the policy.
+	RecordLoginFailure(ctx context.Context, key string, policy domain.LockoutPolicy) (domain.LoginAttempt, error)
+	// ResetLoginAttempts forgets the failed login attempts of a key, unlocking it.
+	ResetLoginAttempts(ctx context.Context, key string) error
+	// ListLockedLoginAttempts retrieves every key that is currently locked, most
+	// recently locked until first.
+	ListLockedLoginAttempts(ctx context.Context) ([]domain.LoginAttempt, error)
+	// PruneLoginAttempts removes the failed login attempts of keys that are not locked
+	// and whose last failure is older than maxAge.
+	PruneLoginAttempts(ctx context.Context, maxAge time.Duration) error
+}
+
+// AuditEventRepository defines an interface to the storage of the audit log of actions
+// taken by support staff.
+type AuditEventRepository interface {
+	// RecordAuditEvent saves a new audit event and returns the created event.
+	RecordAuditEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error)
+	// ListAuditEvents retrieves the audit events of an account, most recent first.
+	ListAuditEvents(ctx context.Context, accountID uint64) ([]domain.AuditEvent, error)
+}
+
+var (
+	_ AccountRepository       = (*AccountStore)(nil)
+	_ RefreshTokenRepository  = (*RefreshTokenStore)(nil)
+	_ PasswordResetRepository = (*PasswordResetStore)(nil)
+	_ RecoveryCodeRepository  = (*RecoveryCodeStore)(nil)
+	_ LoginAttemptRepository  = (*LoginAttemptStore)(nil)
+	_ AuditEventRepository    = (*AuditEventStore)(nil)
+)
@@ -0,0 +1,911 @@
+// Package storetest provides a conformance test suite for implementations of the
+// store repository interfaces, so that every implementation behaves like the
+// Postgres stores.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/store"
+)
+
+// Repositories are the repositories tested by the conformance suite. They must share
+// their storage, so that refresh tokens, password resets and recovery codes can belong
+// to accounts created with Accounts, and so that the repositories of transactions begun
+// with Transactor operate on the same data.
+type Repositories struct {
+	Transactor     store.Transactor              // Transactor runs transactions.
+	Accounts       store.AccountRepository       // Accounts stores accounts.
+	RefreshTokens  store.RefreshTokenRepository  // RefreshTokens stores refresh tokens.
+	PasswordResets store.PasswordResetRepository // PasswordResets stores password resets.
+	RecoveryCodes  store.RecoveryCodeRepository  // RecoveryCodes stores recovery codes.
+	LoginAttempts  store.LoginAttemptRepository  // LoginAttempts stores failed login attempts.
+	AuditEvents    store.AuditEventRepository    // AuditEvents stores the audit log.
+}
+
+// Run runs the conformance suite as subtests of t. newRepositories is called by every
+// subtest and must return repositories with empty storage.
+func Run(t *testing.T, newRepositories func(t *testing.T) Repositories) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repos Repositories)
+	}{
+		{"CreateAccount", testCreateAccount},
+		{"GetAccount", testGetAccount},
+		{"UpdateAccount", testUpdateAccount},
+		{"DeleteAccount", testDeleteAccount},
+		{"VerifyEmail", testVerifyEmail},
+		{"MarkVerificationSent", testMarkVerificationSent},
+		{"UpdatePassword", testUpdatePassword},
+		{"TOTP", testTOTP},
+		{"Roles", testRoles},
+		{"SearchAccounts", testSearchAccounts},
+		{"Restrictions", testRestrictions},
+		{"RefreshTokens", testRefreshTokens},
+		{"PasswordResets", testPasswordResets},
+		{"RecoveryCodes", testRecoveryCodes},
+		{"LoginAttempts", testLoginAttempts},
+		{"AuditEvents", testAuditEvents},
+		{"Transactions", testTransactions},
+		{"NestedTransactions", testNestedTransactions},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepositories(t))
+		})
+	}
+}
+
+// createAccount creates an account with the email address or fails the test.
+func createAccount(t *testing.T, repos Repositories, email string) domain.Account {
+	t.Helper()
+
+	account, err := repos.Accounts.CreateAccount(context.Background(), domain.Account{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateAccount(%q): %v", email, err)
+	}
+	return account
+}
+
+// getAccount retrieves an account by id or fails the test.
+func getAccount(t *testing.T, repos Repositories, id uint64) domain.Account {
+	t.Helper()
+
+	account, err := repos.Accounts.GetAccountByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetAccountByID(%d): %v", id, err)
+	}
+	return account
+}
+
+// expectErr fails the test if err is not want.
+func expectErr(t *testing.T, call string, err error, want error) {
+	t.Helper()
+
+	if err != want {
+		t.Fatalf("%s: got error %v, want %v", call, err, want)
+	}
+}
+
+// expectResult fails the test if the call failed or reported the wrong result.
+func expectResult(t *testing.T, call string, got bool, err error, want bool) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s: %v", call, err)
+	}
+	if got != want {
+		t.Fatalf("%s: got %t, want %t", call, got, want)
+	}
+}
+
+func testCreateAccount(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	if account.ID == 0 || account.CreatedAt == nil || account.UpdatedAt == nil {
+		t.Fatalf("CreateAccount: metadata not set: %+v", account.Meta)
+	}
+	if account.Email != "player@example.com" || account.Password != "hash" {
+		t.Fatalf("CreateAccount: got %q/%q, want the saved email and password", account.Email, account.Password)
+	}
+	if account.IsEmailVerified() || account.IsTOTPEnabled() {
+		t.Fatal("CreateAccount: new account is verified or has two-factor authentication enabled")
+	}
+
+	other := createAccount(t, repos, "other@example.com")
+	if other.ID == account.ID {
+		t.Fatalf("CreateAccount: accounts share id %d", account.ID)
+	}
+
+	_, err := repos.Accounts.CreateAccount(ctx, domain.Account{Email: "player@example.com", Password: "hash"})
+	expectErr(t, "CreateAccount with an existing email", err, store.ErrAccountExists)
+}
+
+func testGetAccount(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	created := createAccount(t, repos, "player@example.com")
+
+	account, err := repos.Accounts.GetAccount(ctx, "player@example.com")
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	if account.ID != created.ID {
+		t.Fatalf("GetAccount: got id %d, want %d", account.ID, created.ID)
+	}
+	if account := getAccount(t, repos, created.ID); account.Email != created.Email {
+		t.Fatalf("GetAccountByID: got email %q, want %q", account.Email, created.Email)
+	}
+
+	_, err = repos.Accounts.GetAccount(ctx, "missing@example.com")
+	expectErr(t, "GetAccount with a missing email", err, store.ErrAccountNotFound)
+	_, err = repos.Accounts.GetAccountByID(ctx, created.ID+1000)
+	expectErr(t, "GetAccountByID with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testUpdateAccount(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	createAccount(t, repos, "other@example.com")
+	if err := repos.Accounts.VerifyEmail(ctx, account.ID, account.Email); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+
+	account.Password = "new hash"
+	updated, err := repos.Accounts.UpdateAccount(ctx, account)
+	if err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	if updated.Password != "new hash" || !updated.IsEmailVerified() {
+		t.Fatal("UpdateAccount with the same email: password not saved or verification reset")
+	}
+
+	account.Email = "renamed@example.com"
+	updated, err = repos.Accounts.UpdateAccount(ctx, account)
+	if err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	if updated.Email != "renamed@example.com" || updated.IsEmailVerified() {
+		t.Fatal("UpdateAccount with a new email: email not saved or verification not reset")
+	}
+	if _, err := repos.Accounts.GetAccount(ctx, "player@example.com"); err != store.ErrAccountNotFound {
+		t.Fatalf("GetAccount with the previous email: got error %v, want %v", err, store.ErrAccountNotFound)
+	}
+
+	account.Email = "other@example.com"
+	_, err = repos.Accounts.UpdateAccount(ctx, account)
+	expectErr(t, "UpdateAccount with an existing email", err, store.ErrAccountExists)
+
+	_, err = repos.Accounts.UpdateAccount(ctx, domain.Account{Meta: domain.Meta{ID: account.ID + 1000}, Email: "missing@example.com"})
+	expectErr(t, "UpdateAccount with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testDeleteAccount(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	expiresAt := time.Now().Add(time.Hour)
+	if err := repos.RefreshTokens.CreateRefreshToken(ctx, domain.RefreshToken{AccountID: account.ID, FamilyID: "family", TokenHash: "token", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := repos.PasswordResets.CreatePasswordReset(ctx, domain.PasswordReset{AccountID: account.ID, TokenHash: "reset", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+	if err := repos.RecoveryCodes.ReplaceRecoveryCodes(ctx, account.ID, []string{"code"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+
+	if err := repos.Accounts.DeleteAccount(ctx, account.ID); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	_, err := repos.Accounts.GetAccountByID(ctx, account.ID)
+	expectErr(t, "GetAccountByID after DeleteAccount", err, store.ErrAccountNotFound)
+	_, err = repos.RefreshTokens.GetRefreshToken(ctx, "token")
+	expectErr(t, "GetRefreshToken after DeleteAccount", err, store.ErrRefreshTokenNotFound)
+	_, err = repos.PasswordResets.GetPasswordReset(ctx, "reset")
+	expectErr(t, "GetPasswordReset after DeleteAccount", err, store.ErrPasswordResetNotFound)
+	used, err := repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "code")
+	expectResult(t, "UseRecoveryCode after DeleteAccount", used, err, false)
+
+	// The email address can be used again.
+	createAccount(t, repos, "player@example.com")
+
+	err = repos.Accounts.DeleteAccount(ctx, account.ID)
+	expectErr(t, "DeleteAccount with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testVerifyEmail(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+
+	err := repos.Accounts.VerifyEmail(ctx, account.ID, "other@example.com")
+	expectErr(t, "VerifyEmail with another email", err, store.ErrAccountNotFound)
+
+	if err := repos.Accounts.VerifyEmail(ctx, account.ID, account.Email); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if !getAccount(t, repos, account.ID).IsEmailVerified() {
+		t.Fatal("VerifyEmail: email not verified")
+	}
+
+	err = repos.Accounts.VerifyEmail(ctx, account.ID, account.Email)
+	expectErr(t, "VerifyEmail of a verified email", err, store.ErrAccountNotFound)
+	err = repos.Accounts.VerifyEmail(ctx, account.ID+1000, account.Email)
+	expectErr(t, "VerifyEmail with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testMarkVerificationSent(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+
+	sent, err := repos.Accounts.MarkVerificationSent(ctx, account.ID, time.Hour)
+	expectResult(t, "MarkVerificationSent", sent, err, true)
+	if getAccount(t, repos, account.ID).VerificationSentAt == nil {
+		t.Fatal("MarkVerificationSent: sending not recorded")
+	}
+
+	sent, err = repos.Accounts.MarkVerificationSent(ctx, account.ID, time.Hour)
+	expectResult(t, "MarkVerificationSent within the interval", sent, err, false)
+
+	other := createAccount(t, repos, "other@example.com")
+	if err := repos.Accounts.VerifyEmail(ctx, other.ID, other.Email); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	sent, err = repos.Accounts.MarkVerificationSent(ctx, other.ID, time.Hour)
+	expectResult(t, "MarkVerificationSent of a verified account", sent, err, false)
+
+	sent, err = repos.Accounts.MarkVerificationSent(ctx, account.ID+1000, time.Hour)
+	expectResult(t, "MarkVerificationSent with a missing id", sent, err, false)
+}
+
+func testUpdatePassword(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+
+	if err := repos.Accounts.UpdatePassword(ctx, account.ID, "new hash"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	if password := getAccount(t, repos, account.ID).Password; password != "new hash" {
+		t.Fatalf("UpdatePassword: got password %q, want %q", password, "new hash")
+	}
+
+	if err := repos.Accounts.RequirePasswordReset(ctx, account.ID); err != nil {
+		t.Fatalf("RequirePasswordReset: %v", err)
+	}
+	if err := repos.Accounts.UpdatePassword(ctx, account.ID, "newer hash"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	if getAccount(t, repos, account.ID).PasswordResetRequiredAt != nil {
+		t.Fatal("UpdatePassword: required password reset not satisfied")
+	}
+
+	err := repos.Accounts.UpdatePassword(ctx, account.ID+1000, "new hash")
+	expectErr(t, "UpdatePassword with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testRoles(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	createAccount(t, repos, "other@example.com")
+	if role := getAccount(t, repos, account.ID).Role; role != domain.RolePlayer {
+		t.Fatalf("CreateAccount: got role %q, want %q", role, domain.RolePlayer)
+	}
+
+	if err := repos.Accounts.SetRole(ctx, account.ID, domain.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	if role := getAccount(t, repos, account.ID).Role; role != domain.RoleAdmin {
+		t.Fatalf("SetRole: got role %q, want %q", role, domain.RoleAdmin)
+	}
+
+	for role, want := range map[domain.Role]int{domain.RoleAdmin: 1, domain.RolePlayer: 1, domain.RoleModerator: 0} {
+		count, err := repos.Accounts.CountAccountsWithRole(ctx, role)
+		if err != nil {
+			t.Fatalf("CountAccountsWithRole: %v", err)
+		}
+		if count != want {
+			t.Fatalf("CountAccountsWithRole(%q): got %d, want %d", role, count, want)
+		}
+	}
+
+	err := repos.Accounts.SetRole(ctx, account.ID+1000, domain.RoleAdmin)
+	expectErr(t, "SetRole with a missing id", err, store.ErrAccountNotFound)
+}
+
+func testSearchAccounts(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	first := createAccount(t, repos, "player@example.com")
+	second := createAccount(t, repos, "other@example.com")
+	third := createAccount(t, repos, "player2@example.org")
+
+	search := func(call string, filter store.AccountFilter, want ...domain.Account) {
+		t.Helper()
+
+		if filter.Limit == 0 {
+			filter.Limit = 10
+		}
+		accounts, err := repos.Accounts.SearchAccounts(ctx, filter)
+		if err != nil {
+			t.Fatalf("%s: %v", call, err)
+		}
+		if len(accounts) != len(want) {
+			t.Fatalf("%s: got %d accounts, want %d", call, len(accounts), len(want))
+		}
+		for i := range want {
+			if accounts[i].ID != want[i].ID {
+				t.Fatalf("%s: got account %d at %d, want %d", call, accounts[i].ID, i, want[i].ID)
+			}
+		}
+	}
+
+	search("SearchAccounts without criteria", store.AccountFilter{}, first, second, third)
+	search("SearchAccounts by email", store.AccountFilter{Email: "PLAYER"}, first, third)
+	search("SearchAccounts by id", store.AccountFilter{ID: second.ID}, second)
+	search("SearchAccounts by id and email", store.AccountFilter{ID: second.ID, Email: "player"})
+	search("SearchAccounts with a limit", store.AccountFilter{Limit: 2}, first, second)
+	search("SearchAccounts after an id", store.AccountFilter{AfterID: second.ID}, third)
+
+	after := first.CreatedAt.Add(-time.Minute)
+	before := first.CreatedAt.Add(time.Minute)
+	search("SearchAccounts by creation date", store.AccountFilter{CreatedAfter: &after, CreatedBefore: &before}, first, second, third)
+	search("SearchAccounts created after", store.AccountFilter{CreatedAfter: &before})
+	search("SearchAccounts created before", store.AccountFilter{CreatedBefore: &after})
+}
+
+func testRestrictions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	if err := getAccount(t, repos, account.ID).CheckAccess(time.Now()); err != nil {
+		t.Fatalf("CreateAccount: new account is restricted: %v", err)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := repos.Accounts.SuspendAccount(ctx, account.ID, until, "spam"); err != nil {
+		t.Fatalf("SuspendAccount: %v", err)
+	}
+	suspended := getAccount(t, repos, account.ID)
+	if err := suspended.CheckAccess(time.Now()); err != domain.ErrAccountSuspended {
+		t.Fatalf("SuspendAccount: got access error %v, want %v", err, domain.ErrAccountSuspended)
+	}
+	if suspended.RestrictionReason == nil || *suspended.RestrictionReason != "spam" {
+		t.Fatalf("SuspendAccount: got reason %v, want %q", suspended.RestrictionReason, "spam")
+	}
+	if suspended.CheckAccess(until.Add(time.Second)) != nil {
+		t.Fatal("SuspendAccount: account still suspended after the suspension ends")
+	}
+
+	if err := repos.Accounts.BanAccount(ctx, account.ID, "cheating"); err != nil {
+		t.Fatalf("BanAccount: %v", err)
+	}
+	banned := getAccount(t, repos, account.ID)
+	if err := banned.CheckAccess(until.Add(time.Second)); err != domain.ErrAccountBanned {
+		t.Fatalf("BanAccount: got access error %v, want %v", err, domain.ErrAccountBanned)
+	}
+	if err := repos.Accounts.BanAccount(ctx, account.ID, "cheating again"); err != nil {
+		t.Fatalf("BanAccount: %v", err)
+	}
+	if bannedAt := getAccount(t, repos, account.ID).BannedAt; !bannedAt.Equal(*banned.BannedAt) {
+		t.Fatalf("BanAccount of a banned account: got ban time %v, want %v", bannedAt, banned.BannedAt)
+	}
+
+	if err := repos.Accounts.LiftRestrictions(ctx, account.ID); err != nil {
+		t.Fatalf("LiftRestrictions: %v", err)
+	}
+	lifted := getAccount(t, repos, account.ID)
+	if lifted.CheckAccess(time.Now()) != nil || lifted.RestrictionReason != nil {
+		t.Fatalf("LiftRestrictions: account still restricted: %+v", lifted)
+	}
+
+	if err := repos.Accounts.RequirePasswordReset(ctx, account.ID); err != nil {
+		t.Fatalf("RequirePasswordReset: %v", err)
+	}
+	if err := getAccount(t, repos, account.ID).CheckAccess(time.Now()); err != domain.ErrPasswordResetRequired {
+		t.Fatalf("RequirePasswordReset: got access error %v, want %v", err, domain.ErrPasswordResetRequired)
+	}
+
+	version := getAccount(t, repos, account.ID).SessionVersion
+	if err := repos.Accounts.RevokeSessions(ctx, account.ID); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	revoked := getAccount(t, repos, account.ID)
+	if !revoked.IsSessionRevoked(version) || revoked.IsSessionRevoked(revoked.SessionVersion) {
+		t.Fatalf("RevokeSessions: got session version %d, was %d", revoked.SessionVersion, version)
+	}
+
+	for call, err := range map[string]error{
+		"SuspendAccount":       repos.Accounts.SuspendAccount(ctx, account.ID+1000, until, "spam"),
+		"BanAccount":           repos.Accounts.BanAccount(ctx, account.ID+1000, "cheating"),
+		"LiftRestrictions":     repos.Accounts.LiftRestrictions(ctx, account.ID+1000),
+		"RequirePasswordReset": repos.Accounts.RequirePasswordReset(ctx, account.ID+1000),
+		"RevokeSessions":       repos.Accounts.RevokeSessions(ctx, account.ID+1000),
+	} {
+		expectErr(t, call+" with a missing id", err, store.ErrAccountNotFound)
+	}
+}
+
+func testTOTP(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+
+	err := repos.Accounts.EnableTOTP(ctx, account.ID)
+	expectErr(t, "EnableTOTP without a pending secret", err, store.ErrTOTPEnabled)
+
+	if err := repos.Accounts.SetPendingTOTPSecret(ctx, account.ID, "secret"); err != nil {
+		t.Fatalf("SetPendingTOTPSecret: %v", err)
+	}
+	if getAccount(t, repos, account.ID).IsTOTPEnabled() {
+		t.Fatal("SetPendingTOTPSecret: two-factor authentication enabled before confirmation")
+	}
+	if err := repos.Accounts.EnableTOTP(ctx, account.ID); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+	enabled := getAccount(t, repos, account.ID)
+	if !enabled.IsTOTPEnabled() || enabled.TOTPSecret == nil || *enabled.TOTPSecret != "secret" {
+		t.Fatal("EnableTOTP: two-factor authentication not enabled with the pending secret")
+	}
+
+	err = repos.Accounts.SetPendingTOTPSecret(ctx, account.ID, "other secret")
+	expectErr(t, "SetPendingTOTPSecret with two-factor authentication enabled", err, store.ErrTOTPEnabled)
+	err = repos.Accounts.EnableTOTP(ctx, account.ID)
+	expectErr(t, "EnableTOTP with two-factor authentication enabled", err, store.ErrTOTPEnabled)
+
+	used, err := repos.Accounts.UseTOTPStep(ctx, account.ID, 5)
+	expectResult(t, "UseTOTPStep", used, err, true)
+	used, err = repos.Accounts.UseTOTPStep(ctx, account.ID, 5)
+	expectResult(t, "UseTOTPStep with the same step", used, err, false)
+	used, err = repos.Accounts.UseTOTPStep(ctx, account.ID, 4)
+	expectResult(t, "UseTOTPStep with an earlier step", used, err, false)
+	used, err = repos.Accounts.UseTOTPStep(ctx, account.ID, 6)
+	expectResult(t, "UseTOTPStep with a later step", used, err, true)
+
+	if err := repos.Accounts.DisableTOTP(ctx, account.ID); err != nil {
+		t.Fatalf("DisableTOTP: %v", err)
+	}
+	disabled := getAccount(t, repos, account.ID)
+	if disabled.IsTOTPEnabled() || disabled.TOTPSecret != nil || disabled.TOTPLastStep != 0 {
+		t.Fatal("DisableTOTP: two-factor authentication state not cleared")
+	}
+
+	err = repos.Accounts.DisableTOTP(ctx, account.ID+1000)
+	expectErr(t, "DisableTOTP with a missing id", err, store.ErrAccountNotFound)
+	used, err = repos.Accounts.UseTOTPStep(ctx, account.ID+1000, 1)
+	expectResult(t, "UseTOTPStep with a missing id", used, err, false)
+}
+
+func testRefreshTokens(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	other := createAccount(t, repos, "other@example.com")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	for _, token := range []domain.RefreshToken{
+		{AccountID: account.ID, FamilyID: "a", TokenHash: "a1", ExpiresAt: expiresAt},
+		{AccountID: account.ID, FamilyID: "a", TokenHash: "a2", ExpiresAt: expiresAt},
+		{AccountID: account.ID, FamilyID: "b", TokenHash: "b1", ExpiresAt: expiresAt},
+		{AccountID: other.ID, FamilyID: "c", TokenHash: "c1", ExpiresAt: expiresAt},
+		{AccountID: account.ID, FamilyID: "d", TokenHash: "d1", ExpiresAt: expiresAt},
+	} {
+		if err := repos.RefreshTokens.CreateRefreshToken(ctx, token); err != nil {
+			t.Fatalf("CreateRefreshToken(%q): %v", token.TokenHash, err)
+		}
+	}
+
+	// isRevoked reports whether the refresh token with the hash is revoked.
+	isRevoked := func(tokenHash string) bool {
+		t.Helper()
+		token, err := repos.RefreshTokens.GetRefreshToken(ctx, tokenHash)
+		if err != nil {
+			t.Fatalf("GetRefreshToken(%q): %v", tokenHash, err)
+		}
+		return token.IsRevoked()
+	}
+
+	token, err := repos.RefreshTokens.GetRefreshToken(ctx, "a1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if token.ID == 0 || token.AccountID != account.ID || token.FamilyID != "a" || !token.ExpiresAt.Equal(expiresAt) || token.IsRevoked() {
+		t.Fatalf("GetRefreshToken: got %+v, want the saved refresh token", token)
+	}
+	_, err = repos.RefreshTokens.GetRefreshToken(ctx, "missing")
+	expectErr(t, "GetRefreshToken with a missing hash", err, store.ErrRefreshTokenNotFound)
+
+	revoked, err := repos.RefreshTokens.RevokeRefreshToken(ctx, token.ID)
+	expectResult(t, "RevokeRefreshToken", revoked, err, true)
+	revoked, err = repos.RefreshTokens.RevokeRefreshToken(ctx, token.ID)
+	expectResult(t, "RevokeRefreshToken of a revoked token", revoked, err, false)
+	if !isRevoked("a1") || isRevoked("a2") {
+		t.Fatal("RevokeRefreshToken: revoked the wrong tokens")
+	}
+
+	active, err := repos.RefreshTokens.IsSessionActive(ctx, "a")
+	expectResult(t, "IsSessionActive with a token left", active, err, true)
+
+	if err := repos.RefreshTokens.RevokeRefreshTokenFamily(ctx, "a"); err != nil {
+		t.Fatalf("RevokeRefreshTokenFamily: %v", err)
+	}
+	if !isRevoked("a2") || isRevoked("b1") {
+		t.Fatal("RevokeRefreshTokenFamily: revoked the wrong tokens")
+	}
+	active, err = repos.RefreshTokens.IsSessionActive(ctx, "a")
+	expectResult(t, "IsSessionActive of a revoked family", active, err, false)
+	active, err = repos.RefreshTokens.IsSessionActive(ctx, "missing")
+	expectResult(t, "IsSessionActive of a missing family", active, err, false)
+
+	if err := repos.RefreshTokens.RevokeOtherRefreshTokens(ctx, account.ID, "b"); err != nil {
+		t.Fatalf("RevokeOtherRefreshTokens: %v", err)
+	}
+	if !isRevoked("d1") || isRevoked("b1") || isRevoked("c1") {
+		t.Fatal("RevokeOtherRefreshTokens: revoked the wrong tokens")
+	}
+
+	if err := repos.RefreshTokens.RevokeAccountRefreshTokens(ctx, account.ID); err != nil {
+		t.Fatalf("RevokeAccountRefreshTokens: %v", err)
+	}
+	if !isRevoked("b1") || isRevoked("c1") {
+		t.Fatal("RevokeAccountRefreshTokens: revoked the wrong tokens")
+	}
+}
+
+func testPasswordResets(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	other := createAccount(t, repos, "other@example.com")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	for _, reset := range []domain.PasswordReset{
+		{AccountID: account.ID, TokenHash: "first", ExpiresAt: expiresAt},
+		{AccountID: account.ID, TokenHash: "second", ExpiresAt: expiresAt},
+		{AccountID: other.ID, TokenHash: "other", ExpiresAt: expiresAt},
+	} {
+		if err := repos.PasswordResets.CreatePasswordReset(ctx, reset); err != nil {
+			t.Fatalf("CreatePasswordReset(%q): %v", reset.TokenHash, err)
+		}
+	}
+
+	// getReset retrieves the password reset with the hash.
+	getReset := func(tokenHash string) domain.PasswordReset {
+		t.Helper()
+		reset, err := repos.PasswordResets.GetPasswordReset(ctx, tokenHash)
+		if err != nil {
+			t.Fatalf("GetPasswordReset(%q): %v", tokenHash, err)
+		}
+		return reset
+	}
+
+	reset := getReset("first")
+	if reset.ID == 0 || reset.AccountID != account.ID || !reset.ExpiresAt.Equal(expiresAt) || reset.IsUsed() {
+		t.Fatalf("GetPasswordReset: got %+v, want the saved password reset", reset)
+	}
+	_, err := repos.PasswordResets.GetPasswordReset(ctx, "missing")
+	expectErr(t, "GetPasswordReset with a missing hash", err, store.ErrPasswordResetNotFound)
+
+	used, err := repos.PasswordResets.UsePasswordReset(ctx, reset)
+	expectResult(t, "UsePasswordReset", used, err, true)
+	if !getReset("first").IsUsed() || !getReset("second").IsUsed() || getReset("other").IsUsed() {
+		t.Fatal("UsePasswordReset: used the wrong password resets")
+	}
+
+	used, err = repos.PasswordResets.UsePasswordReset(ctx, reset)
+	expectResult(t, "UsePasswordReset of a used password reset", used, err, false)
+	used, err = repos.PasswordResets.UsePasswordReset(ctx, getReset("second"))
+	expectResult(t, "UsePasswordReset of an invalidated password reset", used, err, false)
+}
+
+func testRecoveryCodes(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	account := createAccount(t, repos, "player@example.com")
+	other := createAccount(t, repos, "other@example.com")
+
+	if err := repos.RecoveryCodes.ReplaceRecoveryCodes(ctx, account.ID, []string{"a", "b"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+
+	used, err := repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "a")
+	expectResult(t, "UseRecoveryCode", used, err, true)
+	used, err = repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "a")
+	expectResult(t, "UseRecoveryCode of a used code", used, err, false)
+	used, err = repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "c")
+	expectResult(t, "UseRecoveryCode of a missing code", used, err, false)
+	used, err = repos.RecoveryCodes.UseRecoveryCode(ctx, other.ID, "b")
+	expectResult(t, "UseRecoveryCode of another account's code", used, err, false)
+
+	if err := repos.RecoveryCodes.ReplaceRecoveryCodes(ctx, account.ID, []string{"c"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	used, err = repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "b")
+	expectResult(t, "UseRecoveryCode of a replaced code", used, err, false)
+
+	if err := repos.RecoveryCodes.DeleteRecoveryCodes(ctx, account.ID); err != nil {
+		t.Fatalf("DeleteRecoveryCodes: %v", err)
+	}
+	used, err = repos.RecoveryCodes.UseRecoveryCode(ctx, account.ID, "c")
+	expectResult(t, "UseRecoveryCode of a deleted code", used, err, false)
+}
+
+func testLoginAttempts(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	policy := domain.LockoutPolicy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "ip:127.0.0.1")
+	if err != nil {
+		t.Fatalf("GetLoginAttempt: %v", err)
+	}
+	if attempt.Key != "ip:127.0.0.1" || attempt.Failures != 0 || attempt.LockedFor() != 0 {
+		t.Fatalf("GetLoginAttempt without failures: got %+v", attempt)
+	}
+
+	attempt, err = repos.LoginAttempts.RecordLoginFailure(ctx, "ip:127.0.0.1", policy)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if attempt.Failures != 1 || attempt.LockedFor() != 0 {
+		t.Fatalf("RecordLoginFailure below the threshold: got %+v", attempt)
+	}
+
+	attempt, err = repos.LoginAttempts.RecordLoginFailure(ctx, "ip:127.0.0.1", policy)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if attempt.Failures != 2 || attempt.LockedFor() <= 0 || attempt.LockedFor() > time.Minute {
+		t.Fatalf("RecordLoginFailure at the threshold: got %+v", attempt)
+	}
+	if attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil || attempt.Failures != 2 || attempt.LockedFor() <= 0 {
+		t.Fatalf("GetLoginAttempt of a locked key: got %+v, %v", attempt, err)
+	}
+
+	attempt, err = repos.LoginAttempts.RecordLoginFailure(ctx, "ip:127.0.0.1", policy)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if attempt.Failures != 3 || attempt.LockedFor() <= time.Minute || attempt.LockedFor() > 2*time.Minute {
+		t.Fatalf("RecordLoginFailure beyond the threshold: got %+v", attempt)
+	}
+
+	if _, err := repos.LoginAttempts.RecordLoginFailure(ctx, "account:player@example.com", policy); err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	locked, err := repos.LoginAttempts.ListLockedLoginAttempts(ctx)
+	if err != nil {
+		t.Fatalf("ListLockedLoginAttempts: %v", err)
+	}
+	if len(locked) != 1 || locked[0].Key != "ip:127.0.0.1" {
+		t.Fatalf("ListLockedLoginAttempts: got %+v, want only the locked key", locked)
+	}
+
+	if err := repos.LoginAttempts.ResetLoginAttempts(ctx, "ip:127.0.0.1"); err != nil {
+		t.Fatalf("ResetLoginAttempts: %v", err)
+	}
+	if attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil || attempt.Failures != 0 || attempt.LockedFor() != 0 {
+		t.Fatalf("GetLoginAttempt after ResetLoginAttempts: got %+v, %v", attempt, err)
+	}
+	locked, err = repos.LoginAttempts.ListLockedLoginAttempts(ctx)
+	if err != nil {
+		t.Fatalf("ListLockedLoginAttempts: %v", err)
+	}
+	if len(locked) != 0 {
+		t.Fatalf("ListLockedLoginAttempts after ResetLoginAttempts: got %+v, want none", locked)
+	}
+
+	if err := repos.LoginAttempts.PruneLoginAttempts(ctx, time.Hour); err != nil {
+		t.Fatalf("PruneLoginAttempts: %v", err)
+	}
+	if attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "account:player@example.com"); err != nil || attempt.Failures != 1 {
+		t.Fatalf("GetLoginAttempt of a recent failure after PruneLoginAttempts: got %+v, %v", attempt, err)
+	}
+	for i := 0; i < policy.Threshold; i++ {
+		if _, err := repos.LoginAttempts.RecordLoginFailure(ctx, "ip:127.0.0.1", policy); err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+	}
+	if err := repos.LoginAttempts.PruneLoginAttempts(ctx, 0); err != nil {
+		t.Fatalf("PruneLoginAttempts: %v", err)
+	}
+	if attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "account:player@example.com"); err != nil || attempt.Failures != 0 {
+		t.Fatalf("GetLoginAttempt of an old failure after PruneLoginAttempts: got %+v, %v", attempt, err)
+	}
+	if attempt, err := repos.LoginAttempts.GetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil || attempt.Failures != 2 || attempt.LockedFor() <= 0 {
+		t.Fatalf("GetLoginAttempt of a locked key after PruneLoginAttempts: got %+v, %v", attempt, err)
+	}
+}
+
+// errRollback is returned by transactions of the suite that must be rolled back.
+var errRollback = errors.New("Rollback")
+
+func testAuditEvents(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	actor := createAccount(t, repos, "moderator@example.com")
+	account := createAccount(t, repos, "player@example.com")
+
+	events, err := repos.AuditEvents.ListAuditEvents(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("ListAuditEvents without events: got %d events", len(events))
+	}
+
+	recorded, err := repos.AuditEvents.RecordAuditEvent(ctx, domain.AuditEvent{
+		ActorID:   actor.ID,
+		AccountID: account.ID,
+		Action:    domain.AuditSuspend,
+		Reason:    "spam",
+		RequestID: "request",
+	})
+	if err != nil {
+		t.Fatalf("RecordAuditEvent: %v", err)
+	}
+	if recorded.ID == 0 || recorded.CreatedAt.IsZero() || recorded.Action != domain.AuditSuspend || recorded.Reason != "spam" {
+		t.Fatalf("RecordAuditEvent: got %+v", recorded)
+	}
+	if _, err := repos.AuditEvents.RecordAuditEvent(ctx, domain.AuditEvent{ActorID: actor.ID, AccountID: actor.ID, Action: domain.AuditImpersonate}); err != nil {
+		t.Fatalf("RecordAuditEvent: %v", err)
+	}
+	if _, err := repos.AuditEvents.RecordAuditEvent(ctx, domain.AuditEvent{ActorID: actor.ID, AccountID: account.ID, Action: domain.AuditLiftRestrictions}); err != nil {
+		t.Fatalf("RecordAuditEvent: %v", err)
+	}
+
+	// Audit events are kept when the account is deleted.
+	if err := repos.Accounts.DeleteAccount(ctx, account.ID); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+
+	events, err = repos.AuditEvents.ListAuditEvents(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != domain.AuditLiftRestrictions || events[1].ID != recorded.ID {
+		t.Fatalf("ListAuditEvents: got %+v, want the events of the account, most recent first", events)
+	}
+}
+
+func testTransactions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	err := repos.Transactor.WithTx(ctx, func(tx store.Tx) error {
+		account, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "player@example.com", Password: "hash"})
+		if err != nil {
+			return err
+		}
+		return tx.RecoveryCodes().ReplaceRecoveryCodes(ctx, account.ID, []string{"code"})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	committed, err := repos.Accounts.GetAccount(ctx, "player@example.com")
+	if err != nil {
+		t.Fatalf("GetAccount after a committed transaction: %v", err)
+	}
+
+	err = repos.Transactor.WithTx(ctx, func(tx store.Tx) error {
+		if _, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "other@example.com", Password: "hash"}); err != nil {
+			return err
+		}
+		if err := tx.Accounts().UpdatePassword(ctx, committed.ID, "new hash"); err != nil {
+			return err
+		}
+		if used, err := tx.RecoveryCodes().UseRecoveryCode(ctx, committed.ID, "code"); err != nil || !used {
+			t.Errorf("UseRecoveryCode within a transaction: got %t, %v", used, err)
+		}
+		return errRollback
+	})
+	expectErr(t, "WithTx of a failed transaction", err, errRollback)
+
+	_, err = repos.Accounts.GetAccount(ctx, "other@example.com")
+	expectErr(t, "GetAccount after a rolled back transaction", err, store.ErrAccountNotFound)
+	if password := getAccount(t, repos, committed.ID).Password; password != "hash" {
+		t.Fatalf("UpdatePassword in a rolled back transaction: got password %q, want %q", password, "hash")
+	}
+	used, err := repos.RecoveryCodes.UseRecoveryCode(ctx, committed.ID, "code")
+	expectResult(t, "UseRecoveryCode after a rolled back transaction", used, err, true)
+
+	err = repos.Transactor.WithTxOptions(ctx, store.TxOptions{Isolation: sql.LevelSerializable}, func(tx store.Tx) error {
+		_, err := tx.Accounts().GetAccountByID(ctx, committed.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTxOptions: %v", err)
+	}
+}
+
+func testNestedTransactions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	err := repos.Transactor.WithTx(ctx, func(tx store.Tx) error {
+		if _, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "outer@example.com", Password: "hash"}); err != nil {
+			return err
+		}
+
+		err := tx.WithTx(ctx, func(tx store.Tx) error {
+			if _, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "inner@example.com", Password: "hash"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if err != errRollback {
+			t.Errorf("WithTx of a failed nested transaction: got error %v, want %v", err, errRollback)
+		}
+
+		// The enclosing transaction can still be used after a nested transaction fails.
+		return tx.WithTx(ctx, func(tx store.Tx) error {
+			_, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "sibling@example.com", Password: "hash"})
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	for email, want := range map[string]error{
+		"outer@example.com":   nil,
+		"inner@example.com":   store.ErrAccountNotFound,
+		"sibling@example.com": nil,
+	} {
+		_, err := repos.Accounts.GetAccount(ctx, email)
+		expectErr(t, "GetAccount("+email+")", err, want)
+	}
+
+	err = repos.Transactor.WithTx(ctx, func(tx store.Tx) error {
+		err := tx.WithTx(ctx, func(tx store.Tx) error {
+			_, err := tx.Accounts().CreateAccount(ctx, domain.Account{Email: "nested@example.com", Password: "hash"})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+	expectErr(t, "WithTx of a failed transaction", err, errRollback)
+	_, err = repos.Accounts.GetAccount(ctx, "nested@example.com")
+	expectErr(t, "GetAccount of an account created by a nested transaction that was rolled back", err, store.ErrAccountNotFound)
+}
+
+func testCanceledContext(t *testing.T, repos Repositories) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repos.Accounts.CreateAccount(ctx, domain.Account{Email: "player@example.com", Password: "hash"})
+	expectErr(t, "CreateAccount with a canceled context", err, store.ErrCanceled)
+	_, err = repos.Accounts.GetAccount(context.Background(), "player@example.com")
+	expectErr(t, "GetAccount of an account created with a canceled context", err, store.ErrAccountNotFound)
+
+	account := createAccount(t, repos, "player@example.com")
+	_, err = repos.Accounts.GetAccountByID(ctx, account.ID)
+	expectErr(t, "GetAccountByID with a canceled context", err, store.ErrCanceled)
+	_, err = repos.Accounts.MarkVerificationSent(ctx, account.ID, time.Hour)
+	expectErr(t, "MarkVerificationSent with a canceled context", err, store.ErrCanceled)
+	_, err = repos.LoginAttempts.ListLockedLoginAttempts(ctx)
+	expectErr(t, "ListLockedLoginAttempts with a canceled context", err, store.ErrCanceled)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = repos.Accounts.GetAccountByID(ctx, account.ID)
+	expectErr(t, "GetAccountByID with an expired deadline", err, store.ErrTimeout)
+
+	err = repos.Transactor.WithTx(ctx, func(tx store.Tx) error {
+		t.Error("WithTx with an expired deadline: transaction begun")
+		return nil
+	})
+	expectErr(t, "WithTx with an expired deadline", err, store.ErrTimeout)
+}
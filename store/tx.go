@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx"
+	"github.com/jmoiron/sqlx"
+)
+
+// retryBaseDelay is the delay before the first retry of a transaction. The delay
+// doubles with each retry.
+const retryBaseDelay = 10 * time.Millisecond
+
+// TxOptions configures a transaction.
+type TxOptions struct {
+	Isolation sql.IsolationLevel // Isolation is the isolation level, sql.LevelDefault for the database default.
+	ReadOnly  bool               // ReadOnly rejects writes within the transaction.
+}
+
+// Transactor runs functions within transactions.
+type Transactor interface {
+	// WithTx runs fn within a transaction with the default options. The transaction is
+	// committed if fn returns nil and rolled back otherwise. fn may be run several times
+	// if the transaction is retried, so it must not have side effects outside of it.
+	WithTx(ctx context.Context, fn func(tx Tx) error) error
+	// WithTxOptions runs fn within a transaction with the provided options.
+	WithTxOptions(ctx context.Context, options TxOptions, fn func(tx Tx) error) error
+}
+
+// Tx is a transaction. The repositories it returns operate within the transaction. Since
+// Tx is itself a Transactor, functions accepting a Transactor can join a transaction in
+// progress: nested calls to WithTx run within a savepoint, which is rolled back if the
+// nested function fails without aborting the enclosing transaction. The options of
+// nested calls are ignored.
+type Tx interface {
+	Transactor
+
+	// Accounts returns the account repository of the transaction.
+	Accounts() AccountRepository
+	// RefreshTokens returns the refresh token repository of the transaction.
+	RefreshTokens() RefreshTokenRepository
+	// PasswordResets returns the password reset repository of the transaction.
+	PasswordResets() PasswordResetRepository
+	// RecoveryCodes returns the recovery code repository of the transaction.
+	RecoveryCodes() RecoveryCodeRepository
+	// LoginAttempts returns the login attempt repository of the transaction.
+	LoginAttempts() LoginAttemptRepository
+	// AuditEvents returns the audit event repository of the transaction.
+	AuditEvents() AuditEventRepository
+}
+
+// TxManager runs functions within Postgres transactions. Transactions that fail with
+// a serialization failure or a deadlock are retried.
+type TxManager struct {
+	db           *sqlx.DB      // db is the database handle used to begin transactions.
+	options      TxOptions     // options are the default transaction options.
+	maxRetries   int           // maxRetries is the maximum number of times a transaction is retried.
+	queryTimeout time.Duration // queryTimeout is the default timeout of queries within transactions.
+}
+
+// postgresTx is a Tx within a Postgres transaction.
+type postgresTx struct {
+	tx         executor // tx runs queries within the underlying transaction.
+	savepoints int      // savepoints is the number of savepoints created, used to name them.
+}
+
+// NewTxManager initializes and returns a new transaction manager with the provided db
+// handle, default transaction options, maximum number of retries and default query timeout.
+func NewTxManager(db *sqlx.DB, options TxOptions, maxRetries int, queryTimeout time.Duration) *TxManager {
+	return &TxManager{
+		db:           db,
+		options:      options,
+		maxRetries:   maxRetries,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// ParseIsolationLevel parses an isolation level name, such as "read_committed", or
+// "default" for the database default.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	switch name {
+	case "default":
+		return sql.LevelDefault, nil
+	case "read_committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable_read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	}
+	return sql.LevelDefault, fmt.Errorf("Unknown isolation level %q", name)
+}
+
+// WithTx runs fn within a transaction with the default options.
+func (m *TxManager) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return m.WithTxOptions(ctx, m.options, fn)
+}
+
+// WithTxOptions runs fn within a transaction with the provided options. If the
+// transaction fails with a serialization failure or a deadlock, it is retried after
+// an exponentially increasing delay, up to the maximum number of retries.
+func (m *TxManager) WithTxOptions(ctx context.Context, options TxOptions, fn func(tx Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		err := m.run(ctx, options, fn)
+		if err == nil || attempt >= m.maxRetries || !isRetryable(err) {
+			return err
+		}
+
+		delay := retryBaseDelay << uint(attempt)
+		delay += time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// run runs fn within a single transaction.
+func (m *TxManager) run(ctx context.Context, options TxOptions, fn func(tx Tx) error) error {
+	tx, err := m.db.BeginTxx(ctx, &sql.TxOptions{Isolation: options.Isolation, ReadOnly: options.ReadOnly})
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&postgresTx{tx: withTimeout(tx, m.queryTimeout)}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return contextError(ctx, tx.Commit())
+}
+
+// isRetryable reports whether a transaction that failed with err may succeed if retried.
+func isRetryable(err error) bool {
+	var pgErr pgx.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgerrcode.SerializationFailure || pgErr.Code == pgerrcode.DeadlockDetected
+}
+
+// WithTx runs fn within a savepoint of the transaction.
+func (t *postgresTx) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	t.savepoints++
+	name := fmt.Sprintf("savepoint_%d", t.savepoints)
+
+	if _, err := t.tx.ExecContext(ctx, `SAVEPOINT `+name); err != nil {
+		return err
+	}
+
+	if err := fn(t); err != nil {
+		if _, rbErr := t.tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT `+name); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+
+	_, err := t.tx.ExecContext(ctx, `RELEASE SAVEPOINT `+name)
+	return err
+}
+
+// WithTxOptions runs fn within a savepoint of the transaction. The options are ignored.
+func (t *postgresTx) WithTxOptions(ctx context.Context, options TxOptions, fn func(tx Tx) error) error {
+	return t.WithTx(ctx, fn)
+}
+
+// Accounts returns the account repository of the transaction.
+func (t *postgresTx) Accounts() AccountRepository {
+	return &AccountStore{db: t.tx}
+}
+
+// RefreshTokens returns the refresh token repository of the transaction.
+func (t *postgresTx) RefreshTokens() RefreshTokenRepository {
+	return &RefreshTokenStore{db: t.tx}
+}
+
+// PasswordResets returns the password reset repository of the transaction.
+func (t *postgresTx) PasswordResets() PasswordResetRepository {
+	return &PasswordResetStore{db: t.tx}
+}
+
+// RecoveryCodes returns the recovery code repository of the transaction.
+func (t *postgresTx) RecoveryCodes() RecoveryCodeRepository {
+	return &RecoveryCodeStore{db: t.tx}
+}
+
+// LoginAttempts returns the login attempt repository of the transaction.
+func (t *postgresTx) LoginAttempts() LoginAttemptRepository {
+	return &LoginAttemptStore{db: t.tx}
+}
+
+// AuditEvents returns the audit event repository of the transaction.
+func (t *postgresTx) AuditEvents() AuditEventRepository {
+	return &AuditEventStore{db: t.tx}
+}
+
+var (
+	_ Transactor = (*TxManager)(nil)
+	_ Tx         = (*postgresTx)(nil)
+)
@@ -0,0 +1,125 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"untitled_rpg/logger"
+)
+
+var (
+	// ErrQueueFull is returned when a task is submitted to a queue that has no room left.
+	ErrQueueFull = errors.New("Task queue full")
+	// ErrQueueStopped is returned when a task is submitted to a queue that was stopped.
+	ErrQueueStopped = errors.New("Task queue stopped")
+)
+
+// Func is a task run in the background. The context is canceled once the task timeout
+// elapses or the queue gives up on draining.
+type Func func(ctx context.Context) error
+
+// task is a submitted task along with the context it runs with.
+type task struct {
+	ctx  context.Context // ctx carries the logger of the submitter, detached from its cancellation.
+	name string          // name identifies the task in logs.
+	fn   Func            // fn is the task itself.
+}
+
+// Queue runs tasks in the background on a fixed number of workers, so that work started
+// by a request can outlive its response. Stopping the queue waits for queued and running
+// tasks to complete, so it must be stopped before the components the tasks depend on.
+type Queue struct {
+	tasks   chan task          // tasks buffers the submitted tasks until a worker runs them.
+	timeout time.Duration      // timeout is the maximum duration of a task.
+	ctx     context.Context    // ctx is the parent context of every task, canceled if draining gives up.
+	cancel  context.CancelFunc // cancel cancels ctx.
+	mu      sync.RWMutex       // mu guards stopped and prevents submitting while the queue stops.
+	stopped bool               // stopped is set once the queue stops accepting tasks.
+	wg      sync.WaitGroup     // wg waits for the workers to exit.
+}
+
+// NewQueue initializes and returns a new queue running tasks on the provided number of
+// workers. Up to size tasks wait for a worker, and each task may run for up to timeout.
+func NewQueue(workers int, size int, timeout time.Duration) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		tasks:   make(chan task, size),
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues a task. The task runs with the logger of ctx but is not canceled along
+// with ctx, so a task submitted by a request keeps running after the response is sent.
+// ErrQueueFull is returned if the queue has no room left, and ErrQueueStopped if the
+// queue was stopped.
+func (q *Queue) Submit(ctx context.Context, name string, fn Func) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stopped {
+		return ErrQueueStopped
+	}
+	select {
+	case q.tasks <- task{ctx: logger.NewContext(q.ctx, logger.FromContext(ctx)), name: name, fn: fn}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop stops accepting tasks and waits for the queued and running tasks to complete.
+// If ctx is done first, the running tasks are canceled, the remaining tasks are dropped
+// and the context error is returned.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.tasks)
+	}
+	q.mu.Unlock()
+	defer q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work runs queued tasks until the queue is stopped and drained. Once draining gives
+// up, the remaining tasks are dropped.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for t := range q.tasks {
+		if q.ctx.Err() != nil {
+			logger.FromContext(t.ctx).Warn().Str("task", t.name).Msg("Background task dropped")
+			continue
+		}
+		q.run(t)
+	}
+}
+
+// run runs a task within its timeout and logs its failure.
+func (q *Queue) run(t task) {
+	ctx, cancel := context.WithTimeout(t.ctx, q.timeout)
+	defer cancel()
+
+	if err := t.fn(ctx); err != nil {
+		logger.FromContext(ctx).Error().Err(err).Str("task", t.name).Msg("Background task failed")
+	}
+}
@@ -0,0 +1,105 @@
+package task_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	"untitled_rpg/task"
+)
+
+func TestQueueDrainsOnStop(t *testing.T) {
+	q := task.NewQueue(1, 10, time.Second)
+
+	var ran int32
+	for i := 0; i < 5; i++ {
+		err := q.Submit(context.Background(), "count", func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&ran, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&ran); got != 5 {
+		t.Errorf("ran %d tasks before Stop returned, want 5", got)
+	}
+
+	err := q.Submit(context.Background(), "late", func(ctx context.Context) error { return nil })
+	if err != task.ErrQueueStopped {
+		t.Errorf("Submit() after Stop error = %v, want %v", err, task.ErrQueueStopped)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := task.NewQueue(1, 1, time.Second)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	block := func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}
+	if err := q.Submit(context.Background(), "block", block); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	<-started
+
+	noop := func(ctx context.Context) error { return nil }
+	if err := q.Submit(context.Background(), "queued", noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := q.Submit(context.Background(), "overflow", noop); err != task.ErrQueueFull {
+		t.Errorf("Submit() to a full queue error = %v, want %v", err, task.ErrQueueFull)
+	}
+
+	close(release)
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+func TestQueueTimeouts(t *testing.T) {
+	q := task.NewQueue(1, 1, 10*time.Millisecond)
+
+	deadline := make(chan bool, 1)
+	err := q.Submit(context.Background(), "deadline", func(ctx context.Context) error {
+		<-ctx.Done()
+		deadline <- ctx.Err() == context.DeadlineExceeded
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if !<-deadline {
+		t.Error("task context was not canceled by the task timeout")
+	}
+
+	q = task.NewQueue(1, 1, time.Minute)
+	canceled := make(chan struct{})
+	err = q.Submit(context.Background(), "stuck", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("running task was not canceled when Stop gave up")
+	}
+}
@@ -0,0 +1,178 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned when a key uses an algorithm other than HS256, RS256 or EdDSA.
+	ErrUnsupportedAlgorithm = errors.New("Unsupported signing algorithm")
+	// ErrInvalidKey is returned when key material cannot be parsed.
+	ErrInvalidKey = errors.New("Invalid key")
+)
+
+// Key is a named key used to sign or verify auth tokens.
+type Key struct {
+	ID         string            // ID is the key id, sent in the kid header of tokens signed with the key.
+	Method     jwt.SigningMethod // Method is the signing method used with the key.
+	signingKey interface{}       // signingKey is the key used to sign tokens, nil for verification-only keys.
+	verifyKey  interface{}       // verifyKey is the key used to verify tokens.
+}
+
+// CanSign reports whether the key contains the private or secret material needed to sign tokens.
+func (k *Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// NewHMACKey returns an HS256 key with the provided shared secret.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, ErrInvalidKey
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signingKey: secret, verifyKey: secret}, nil
+}
+
+// ParseKey returns a key for the provided algorithm. HS256 keys are raw shared secrets;
+// RS256 and EdDSA keys are PEM encoded private keys, or public keys for keys that are
+// only used for verification.
+func ParseKey(id string, alg string, data []byte) (*Key, error) {
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		return NewHMACKey(id, data)
+	case jwt.SigningMethodRS256.Alg():
+		return parseRSAKey(id, data)
+	case SigningMethodEdDSA.Alg():
+		return parseEdDSAKey(id, data)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// LoadKey loads a key from a spec of the form "kid:alg:source", where source is either
+// "file:<path>" to read the key from a file, or "env:<name>" to read the key from an
+// environment variable.
+func LoadKey(spec string) (*Key, error) {
+	parts := strings.SplitN(spec, ":", 4)
+	if len(parts) != 4 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid key spec %q", spec)
+	}
+	id, alg, source, location := parts[0], parts[1], parts[2], parts[3]
+
+	var data []byte
+	switch source {
+	case "file":
+		b, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	case "env":
+		value, ok := os.LookupEnv(location)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", location)
+		}
+		data = []byte(value)
+	default:
+		return nil, fmt.Errorf("invalid key source %q", source)
+	}
+
+	key, err := ParseKey(id, alg, data)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+	return key, nil
+}
+
+// parseRSAKey parses a PEM encoded RSA private or public key.
+func parseRSAKey(id string, data []byte) (*Key, error) {
+	if privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, signingKey: privateKey, verifyKey: &privateKey.PublicKey}, nil
+	}
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: publicKey}, nil
+}
+
+// parseEdDSAKey parses a PEM encoded PKCS#8 Ed25519 private key or PKIX Ed25519 public key.
+func parseEdDSAKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		privateKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+		return &Key{ID: id, Method: SigningMethodEdDSA, signingKey: privateKey, verifyKey: privateKey.Public()}, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return &Key{ID: id, Method: SigningMethodEdDSA, verifyKey: publicKey}, nil
+}
+
+// JWK represents a public key in JSON Web Key format, as defined in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS represents a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwk returns the public part of the key in JSON Web Key format. Symmetric keys
+// are never published, so false is returned for them.
+func (k *Key) jwk() (JWK, bool) {
+	switch key := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Method.Alg(),
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Method.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
@@ -0,0 +1,74 @@
+package token
+
+import (
+	"fmt"
+	"sort"
+)
+
+// KeySet is a collection of keys used to verify auth tokens, one of which is
+// used to sign newly issued tokens. Keeping previous keys in the set after
+// rotating the signing key allows tokens signed with them to remain valid until
+// they expire.
+type KeySet struct {
+	signingKey *Key            // signingKey is the key used to sign new tokens.
+	keys       map[string]*Key // keys maps key ids to the keys used to verify tokens.
+}
+
+// NewKeySet initializes and returns a new key set containing the provided keys.
+// The key with id signingKeyID is used to sign new tokens and must contain the
+// private or secret key material required for signing.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signingKey, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyID)
+	}
+	if !signingKey.CanSign() {
+		return nil, fmt.Errorf("signing key %q cannot be used for signing", signingKeyID)
+	}
+	ks.signingKey = signingKey
+
+	return ks, nil
+}
+
+// Key returns the verification key with the provided id.
+func (ks *KeySet) Key(id string) (*Key, bool) {
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+// algorithms returns the algorithms of all keys in the set.
+func (ks *KeySet) algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range ks.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWKS returns the public keys of the set in JSON Web Key Set format.
+// Symmetric keys are omitted.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if jwk, ok := key.jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
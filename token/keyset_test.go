@@ -0,0 +1,182 @@
+package token_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newEdDSAKey returns a new Ed25519 private key along with its PEM encoded private and public keys.
+func newEdDSAKey(t *testing.T) (ed25519.PrivateKey, []byte, []byte) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+	return privateKey,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+}
+
+// mustParseKey parses a key and fails the test on error.
+func mustParseKey(t *testing.T, id string, alg string, data []byte) *token.Key {
+	t.Helper()
+	key, err := token.ParseKey(id, alg, data)
+	if err != nil {
+		t.Fatalf("ParseKey(%q, %q) error = %v", id, alg, err)
+	}
+	return key
+}
+
+func TestParseKey(t *testing.T) {
+	_, privatePEM, publicPEM := newEdDSAKey(t)
+	rsaKeys := newTestKeys(t)
+
+	tests := []struct {
+		name     string
+		alg      string
+		data     []byte
+		wantErr  error
+		wantSign bool
+	}{
+		{"hmac secret", "HS256", []byte("secret"), nil, true},
+		{"empty hmac secret", "HS256", nil, token.ErrInvalidKey, false},
+		{"eddsa private key", "EdDSA", privatePEM, nil, true},
+		{"eddsa public key", "EdDSA", publicPEM, nil, false},
+		{"rsa public key", "RS256", rsaKeys.publicPEM, nil, false},
+		{"rsa key as eddsa", "EdDSA", rsaKeys.publicPEM, token.ErrInvalidKey, false},
+		{"eddsa key as rsa", "RS256", publicPEM, token.ErrInvalidKey, false},
+		{"garbage", "EdDSA", []byte("garbage"), token.ErrInvalidKey, false},
+		{"unsupported algorithm", "ES256", privatePEM, token.ErrUnsupportedAlgorithm, false},
+		{"alg none", "none", nil, token.ErrUnsupportedAlgorithm, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := token.ParseKey("kid", tt.alg, tt.data)
+			if err != tt.wantErr {
+				t.Fatalf("ParseKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && key.CanSign() != tt.wantSign {
+				t.Errorf("CanSign() = %v, want %v", key.CanSign(), tt.wantSign)
+			}
+		})
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	_, privatePEM, publicPEM := newEdDSAKey(t)
+	signing := mustParseKey(t, "ed", "EdDSA", privatePEM)
+	verifyOnly := mustParseKey(t, "old", "EdDSA", publicPEM)
+	duplicate := mustParseKey(t, "ed", "HS256", []byte("secret"))
+
+	tests := []struct {
+		name         string
+		signingKeyID string
+		keys         []*token.Key
+		wantErr      bool
+	}{
+		{"signing key", "ed", []*token.Key{signing, verifyOnly}, false},
+		{"duplicate key id", "ed", []*token.Key{signing, duplicate}, true},
+		{"missing signing key", "other", []*token.Key{signing}, true},
+		{"verification-only signing key", "old", []*token.Key{signing, verifyOnly}, true},
+		{"no keys", "ed", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := token.NewKeySet(tt.signingKeyID, tt.keys...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKeySet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	_, privatePEM, _ := newEdDSAKey(t)
+	rsaKeys := newTestKeys(t)
+
+	keys, err := token.NewKeySet("b-ed",
+		mustParseKey(t, "c-rsa", "RS256", rsaKeys.publicPEM),
+		mustParseKey(t, "a-hmac", "HS256", []byte("secret")),
+		mustParseKey(t, "b-ed", "EdDSA", privatePEM),
+	)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	jwks := keys.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2 without the HS256 key", len(jwks.Keys))
+	}
+
+	ed, rsa := jwks.Keys[0], jwks.Keys[1]
+	if ed.KeyID != "b-ed" || ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != "EdDSA" || ed.Use != "sig" || ed.X == "" {
+		t.Errorf("JWKS() first key = %+v, want the Ed25519 key", ed)
+	}
+	if rsa.KeyID != "c-rsa" || rsa.KeyType != "RSA" || rsa.Algorithm != "RS256" || rsa.Use != "sig" || rsa.N == "" || rsa.E != "AQAB" {
+		t.Errorf("JWKS() second key = %+v, want the RSA key", rsa)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	edKey, privatePEM, publicPEM := newEdDSAKey(t)
+	rsaKeys := newTestKeys(t)
+	account := domain.Account{Meta: domain.Meta{ID: 1}}
+
+	oldKeys, err := token.NewKeySet("ed", mustParseKey(t, "ed", "EdDSA", privatePEM))
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	oldToken, err := token.NewProvider(oldKeys, issuer, audience, time.Minute).IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	// The signing key is rotated to the RSA key, keeping the previous key for verification only.
+	keys, err := token.NewKeySet("rsa",
+		mustParseKey(t, "rsa", "RS256", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKeys.privateKey)})),
+		mustParseKey(t, "ed", "EdDSA", publicPEM),
+	)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	provider := token.NewProvider(keys, issuer, audience, time.Minute)
+	newToken, err := provider.IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"signed with the new key", newToken, nil},
+		{"signed with the previous key", oldToken, nil},
+		{"eddsa signature with the rsa kid", forge(t, token.SigningMethodEdDSA, edKey, "rsa", claims()), token.ErrInvalidToken},
+		{"rsa signature with the eddsa kid", forge(t, jwt.SigningMethodRS256, rsaKeys.privateKey, "ed", claims()), token.ErrInvalidToken},
+		{"HS256 signed with the eddsa public key", forge(t, jwt.SigningMethodHS256, publicPEM, "ed", claims()), token.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.VerifyToken(tt.token); err != tt.wantErr {
+				t.Errorf("VerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewRefreshToken generates a new opaque refresh token. Only the hash of the token,
+// as returned by HashToken, should be persisted.
+func NewRefreshToken() (string, error) {
+	return randomString(32)
+}
+
+// NewResetToken generates a new opaque password reset token. Only the hash of the
+// token, as returned by HashToken, should be persisted.
+func NewResetToken() (string, error) {
+	return randomString(32)
+}
+
+// NewSessionID generates a new random session id used to group refresh tokens
+// issued from the same login.
+func NewSessionID() (string, error) {
+	return randomString(16)
+}
+
+// HashToken returns the hex encoded sha256 hash of an opaque token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns a url safe string encoding n cryptographically secure random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
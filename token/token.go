@@ -1,33 +1,263 @@
 package token
 
 import (
+	"errors"
+	"fmt"
+	"time"
 	"untitled_rpg/domain"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+var (
+	// ErrInvalidToken is returned when an auth token cannot be parsed, has an invalid
+	// signature or does not contain the expected claims.
+	ErrInvalidToken = errors.New("Invalid token")
+	// ErrExpiredToken is returned when an auth token has expired.
+	ErrExpiredToken = errors.New("Token expired")
+)
+
+// Claims represents the claims contained in an auth token.
+type Claims struct {
+	jwt.StandardClaims
+	AccountID uint64 `json:"id"`              // AccountID is the id of the account the token was issued to.
+	SessionID string `json:"sid"`             // SessionID identifies the login session the token belongs to.
+	Role      string `json:"role,omitempty"`  // Role is the role of the account when the token was issued, empty for special purpose tokens.
+	Purpose   string `json:"pur,omitempty"`   // Purpose restricts a special purpose token to a single use case, empty for auth tokens.
+	Scope     string `json:"scope,omitempty"` // Scope restricts what an auth token allows, empty for full access.
+	Version   int    `json:"sv,omitempty"`    // Version is the session version of the account when the token was issued.
+
+	ImpersonatorID uint64 `json:"imp,omitempty"` // ImpersonatorID is the id of the staff account acting as the account with a read-only token, zero otherwise.
+}
+
+// IsImpersonation reports whether the token was issued to a staff account acting as
+// the account.
+func (c Claims) IsImpersonation() bool {
+	return c.ImpersonatorID != 0
+}
+
+// IsReadOnly reports whether the token only allows reading.
+func (c Claims) IsReadOnly() bool {
+	return c.Scope == ScopeRead
+}
+
+const (
+	// PurposeVerifyEmail is the purpose of tokens used to verify an account email address.
+	PurposeVerifyEmail = "verify_email"
+	// PurposeMFA is the purpose of tokens returned after a successful password check that
+	// must be exchanged along with a second factor to complete a two-factor login.
+	PurposeMFA = "mfa"
+)
+
+// ScopeRead is the scope of tokens that only allow reading.
+const ScopeRead = "read"
+
+// Valid validates the time based claims and ensures the token identifies an account
+// and has an expiry.
+func (c Claims) Valid() error {
+	if err := c.StandardClaims.Valid(); err != nil {
+		return err
+	}
+	if c.AccountID == 0 || c.ExpiresAt == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
 // Provider is a utility that handles issuing and verifying auth tokens.
 // Most services will require a valid auth token to be able to interact with them.
 type Provider struct {
-	encryptionKey string // encryptionKey is the secret key used when issuing and verifying auth tokens.
+	keys     *KeySet       // keys is the key set used when issuing and verifying auth tokens.
+	issuer   string        // issuer is the value of the iss claim of issued tokens.
+	audience string        // audience is the value of the aud claim of issued tokens.
+	ttl      time.Duration // ttl is how long issued tokens remain valid.
 }
 
-// NewProvider initializes and returns a new token provider with the provided key.
-func NewProvider(encryptionKey string) *Provider {
+// NewProvider initializes and returns a new token provider with the provided key set,
+// issuer, audience and token lifetime.
+func NewProvider(keys *KeySet, issuer string, audience string, ttl time.Duration) *Provider {
 	return &Provider{
-		encryptionKey: encryptionKey,
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
 	}
 }
 
-// IssueToken creates an auth token to use when interacting with the service.
-func (p *Provider) IssueToken(account domain.Account) (string, error) {
-	claims := jwt.MapClaims{}
-	claims["id"] = account.ID
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(p.encryptionKey))
+// JWKS returns the public verification keys in JSON Web Key Set format.
+func (p *Provider) JWKS() JWKS {
+	return p.keys.JWKS()
+}
+
+// TTL returns how long issued tokens remain valid.
+func (p *Provider) TTL() time.Duration {
+	return p.ttl
 }
 
-// VerifyToken verifies an auth token.
-func VerifyToken(token string) bool {
-	return false
+// IssueToken creates a short-lived auth token to use when interacting with the service.
+// The session id ties the token to the refresh token family it was issued with. The
+// role of the account is included for services that cannot load the account.
+func (p *Provider) IssueToken(account domain.Account, sessionID string) (string, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Issuer:    p.issuer,
+			Audience:  p.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(p.ttl).Unix(),
+		},
+		AccountID: account.ID,
+		SessionID: sessionID,
+		Role:      string(account.Role),
+		Version:   account.SessionVersion,
+	}
+	return p.sign(claims)
+}
+
+// IssueImpersonationToken creates a read-only auth token allowing a staff account to act
+// as the account for the provided duration, to investigate an issue from the point of
+// view of the account. The token does not belong to a session and cannot be refreshed.
+// It has its own audience, so that services verifying auth tokens with the published
+// keys reject it unless they accept impersonation and enforce its read scope.
+func (p *Provider) IssueImpersonationToken(account domain.Account, impersonatorID uint64, ttl time.Duration) (string, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Issuer:    p.issuer,
+			Audience:  p.impersonationAudience(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		AccountID:      account.ID,
+		Role:           string(account.Role),
+		Scope:          ScopeRead,
+		Version:        account.SessionVersion,
+		ImpersonatorID: impersonatorID,
+	}
+	return p.sign(claims)
+}
+
+// VerifyToken parses an auth token, verifies its signature and validates its claims.
+// Impersonation tokens are accepted and are always read-only. Special purpose tokens are
+// rejected, since their audience differs from the audience of auth tokens.
+func (p *Provider) VerifyToken(tokenString string) (*Claims, error) {
+	claims, err := p.parse(tokenString, p.audience, p.impersonationAudience())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	impersonation := claims.Audience == p.impersonationAudience()
+	if impersonation != claims.IsImpersonation() || (impersonation && !claims.IsReadOnly()) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IssuePurposeToken creates a token that can only be used for the provided purpose.
+// The subject is an arbitrary value bound to the token, such as an email address,
+// which is checked by the caller when the token is used. The token has its own
+// audience, so that services verifying auth tokens with the published keys reject it.
+func (p *Provider) IssuePurposeToken(purpose string, accountID uint64, subject string, ttl time.Duration) (string, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Subject:   subject,
+			Issuer:    p.issuer,
+			Audience:  p.purposeAudience(purpose),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		AccountID: accountID,
+		Purpose:   purpose,
+	}
+	return p.sign(claims)
+}
+
+// VerifyPurposeToken parses a special purpose token, verifies its signature and
+// validates its claims. Tokens issued for any other purpose are rejected.
+func (p *Provider) VerifyPurposeToken(tokenString string, purpose string) (*Claims, error) {
+	claims, err := p.parse(tokenString, p.purposeAudience(purpose))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// impersonationAudience returns the value of the aud claim of impersonation tokens.
+func (p *Provider) impersonationAudience() string {
+	return p.audience + ":impersonation"
+}
+
+// purposeAudience returns the value of the aud claim of tokens issued for the purpose.
+func (p *Provider) purposeAudience(purpose string) string {
+	return p.audience + ":purpose:" + purpose
+}
+
+// sign signs the claims with the signing key of the key set.
+func (p *Provider) sign(claims Claims) (string, error) {
+	signingKey := p.keys.signingKey
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.signingKey)
+}
+
+// parse parses a token, verifies its signature and validates its claims, including
+// that its aud claim is exactly one of the provided audiences. The token is verified
+// with the key identified by its kid header, and the signing algorithm is pinned to the
+// algorithm of that key so tokens signed with any other algorithm, including "none",
+// are rejected.
+func (p *Provider) parse(tokenString string, audiences ...string) (*Claims, error) {
+	var claims Claims
+
+	parser := &jwt.Parser{ValidMethods: p.keys.algorithms()}
+	_, err := parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := p.keys.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id: %v", token.Header["kid"])
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		if err, ok := err.(*jwt.ValidationError); ok && err.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(p.issuer, true) {
+		return nil, ErrInvalidToken
+	}
+	for _, audience := range audiences {
+		if claims.Audience == audience {
+			return &claims, nil
+		}
+	}
+	return nil, ErrInvalidToken
 }
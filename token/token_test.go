@@ -0,0 +1,216 @@
+package token_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+	"untitled_rpg/domain"
+	"untitled_rpg/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	issuer   = "untitled_rpg"
+	audience = "players"
+)
+
+// testKeys holds an RSA key set along with the material needed to forge tokens.
+type testKeys struct {
+	keys       *token.KeySet
+	privateKey *rsa.PrivateKey
+	publicPEM  []byte
+}
+
+// newTestKeys returns a key set signing with a new RS256 key with id "rsa".
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+
+	key, err := token.ParseKey("rsa", "RS256", privatePEM)
+	if err != nil {
+		t.Fatalf("ParseKey() error = %v", err)
+	}
+	keys, err := token.NewKeySet("rsa", key)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	return testKeys{
+		keys:       keys,
+		privateKey: privateKey,
+		publicPEM:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+	}
+}
+
+// forge signs claims with the provided method, key and kid header.
+func forge(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	return s
+}
+
+// claims returns valid access token claims for account 1.
+func claims() token.Claims {
+	now := time.Now()
+	return token.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    issuer,
+			Audience:  audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+		AccountID: 1,
+		SessionID: "session",
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	k := newTestKeys(t)
+	provider := token.NewProvider(k.keys, issuer, audience, time.Minute)
+	account := domain.Account{Meta: domain.Meta{ID: 1}, Role: domain.RolePlayer, SessionVersion: 3}
+
+	issued, err := provider.IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	expired, err := token.NewProvider(k.keys, issuer, audience, -time.Minute).IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	otherIssuer, err := token.NewProvider(k.keys, "other", audience, time.Minute).IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	otherAudience, err := token.NewProvider(k.keys, issuer, "other", time.Minute).IssueToken(account, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	mfa, err := provider.IssuePurposeToken(token.PurposeMFA, account.ID, "", time.Minute)
+	if err != nil {
+		t.Fatalf("IssuePurposeToken() error = %v", err)
+	}
+
+	noneClaims := claims()
+	withoutAccount := claims()
+	withoutAccount.AccountID = 0
+	withoutExpiry := claims()
+	withoutExpiry.ExpiresAt = 0
+	purposeClaims := claims()
+	purposeClaims.Purpose = token.PurposeMFA
+	writableImpersonation := claims()
+	writableImpersonation.Audience = audience + ":impersonation"
+	writableImpersonation.ImpersonatorID = 2
+	impersonationAsAccess := claims()
+	impersonationAsAccess.ImpersonatorID = 2
+	impersonationAsAccess.Scope = token.ScopeRead
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"issued token", issued, nil},
+		{"forged with the signing key", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", claims()), nil},
+		{"expired", expired, token.ErrExpiredToken},
+		{"tampered signature", issued[:len(issued)-4] + "AAAA", token.ErrInvalidToken},
+		{"malformed", "not.a.token", token.ErrInvalidToken},
+		{"other issuer", otherIssuer, token.ErrInvalidToken},
+		{"other audience", otherAudience, token.ErrInvalidToken},
+		{"mfa challenge token", mfa, token.ErrInvalidToken},
+		{"purpose claim with the access audience", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", purposeClaims), token.ErrInvalidToken},
+		{"alg none", forge(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rsa", noneClaims), token.ErrInvalidToken},
+		{"HS256 signed with the RSA public key", forge(t, jwt.SigningMethodHS256, k.publicPEM, "rsa", claims()), token.ErrInvalidToken},
+		{"unknown kid", forge(t, jwt.SigningMethodRS256, k.privateKey, "other", claims()), token.ErrInvalidToken},
+		{"missing kid", forge(t, jwt.SigningMethodRS256, k.privateKey, "", claims()), token.ErrInvalidToken},
+		{"without account", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", withoutAccount), token.ErrInvalidToken},
+		{"without expiry", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", withoutExpiry), token.ErrInvalidToken},
+		{"impersonation without read scope", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", writableImpersonation), token.ErrInvalidToken},
+		{"impersonation with the access audience", forge(t, jwt.SigningMethodRS256, k.privateKey, "rsa", impersonationAsAccess), token.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyToken(tt.token)
+			if err != tt.wantErr {
+				t.Errorf("VerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := provider.VerifyToken(issued)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+	if got.AccountID != 1 || got.SessionID != "session" || got.Role != "player" || got.Version != 3 || got.IsReadOnly() || got.IsImpersonation() {
+		t.Errorf("VerifyToken() = %+v, want the claims of the issued token", got)
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	k := newTestKeys(t)
+	provider := token.NewProvider(k.keys, issuer, audience, time.Minute)
+	account := domain.Account{Meta: domain.Meta{ID: 1}, Role: domain.RolePlayer}
+
+	issued, err := provider.IssueImpersonationToken(account, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueImpersonationToken() error = %v", err)
+	}
+
+	got, err := provider.VerifyToken(issued)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+	if !got.IsImpersonation() || !got.IsReadOnly() || got.ImpersonatorID != 2 || got.SessionID != "" {
+		t.Errorf("VerifyToken() = %+v, want a read-only impersonation token without session", got)
+	}
+	if got.Audience == audience {
+		t.Errorf("impersonation token audience = %q, want an audience other than the access audience", got.Audience)
+	}
+}
+
+func TestPurposeToken(t *testing.T) {
+	k := newTestKeys(t)
+	provider := token.NewProvider(k.keys, issuer, audience, time.Minute)
+
+	issued, err := provider.IssuePurposeToken(token.PurposeVerifyEmail, 1, "player@example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("IssuePurposeToken() error = %v", err)
+	}
+	access, err := provider.IssueToken(domain.Account{Meta: domain.Meta{ID: 1}}, "session")
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	got, err := provider.VerifyPurposeToken(issued, token.PurposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("VerifyPurposeToken() error = %v", err)
+	}
+	if got.AccountID != 1 || got.Subject != "player@example.com" {
+		t.Errorf("VerifyPurposeToken() = %+v, want the claims of the issued token", got)
+	}
+	if got.Audience == audience {
+		t.Errorf("purpose token audience = %q, want an audience other than the access audience", got.Audience)
+	}
+
+	if _, err := provider.VerifyPurposeToken(issued, token.PurposeMFA); err != token.ErrInvalidToken {
+		t.Errorf("VerifyPurposeToken() with another purpose error = %v, want %v", err, token.ErrInvalidToken)
+	}
+	if _, err := provider.VerifyPurposeToken(access, token.PurposeMFA); err != token.ErrInvalidToken {
+		t.Errorf("VerifyPurposeToken() of an auth token error = %v, want %v", err, token.ErrInvalidToken)
+	}
+}
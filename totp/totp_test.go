@@ -0,0 +1,104 @@
+package totp_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"untitled_rpg/totp"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 secret "12345678901234567890" of RFC 6238.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		time     int64
+		wantStep int64
+		wantOK   bool
+	}{
+		// Test vectors of RFC 6238, truncated to 6 digits.
+		{"rfc 6238 at 59", rfcSecret, "287082", 59, 1, true},
+		{"rfc 6238 at 1111111109", rfcSecret, "081804", 1111111109, 37037036, true},
+		{"rfc 6238 at 1234567890", rfcSecret, "005924", 1234567890, 41152263, true},
+		{"rfc 6238 at 2000000000", rfcSecret, "279037", 2000000000, 66666666, true},
+		{"lowercase secret", strings.ToLower(rfcSecret), "287082", 59, 1, true},
+
+		// The code of step 1 (30s to 59s) is accepted one step before and after.
+		{"one step early", rfcSecret, "287082", 0, 1, true},
+		{"one step late", rfcSecret, "287082", 89, 1, true},
+		{"two steps late", rfcSecret, "287082", 90, 0, false},
+		{"two steps early", rfcSecret, "081804", 1111111109 - 2*totp.Period, 0, false},
+
+		{"wrong code", rfcSecret, "287083", 59, 0, false},
+		{"short code", rfcSecret, "28708", 59, 0, false},
+		{"long code", rfcSecret, "2870820", 59, 0, false},
+		{"invalid secret", "not base32!", "287082", 59, 0, false},
+		{"empty code", rfcSecret, "", 59, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := totp.Validate(tt.secret, tt.code, time.Unix(tt.time, 0))
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totp.Code(rfcSecret, time.Unix(tt.time, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code() at %d = %q, want %q", tt.time, got, tt.want)
+		}
+	}
+
+	if _, err := totp.Code("not base32!", time.Now()); err == nil {
+		t.Error("Code() with an invalid secret error = nil, want an error")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	b, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	if len(a) != 32 || a == b {
+		t.Errorf("GenerateSecret() = %q, %q, want distinct 32 character secrets", a, b)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(totp.URI("Untitled RPG", "player@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Untitled RPG:player@example.com" {
+		t.Errorf("URI() = %q, want an otpauth totp URI labelled with the issuer and account", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Untitled RPG" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("URI() query = %v, want the secret, issuer, digits and period", query)
+	}
+}